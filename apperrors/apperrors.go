@@ -0,0 +1,133 @@
+// apperrors/apperrors.go
+package apperrors
+
+import (
+	"errors"
+	"net/http"
+)
+
+// Kinds of errors. Every error returned to API clients is one of them, which
+// decides its HTTP status; errors of no kind are internal errors.
+var (
+	ErrValidation        = errors.New("validation failed")
+	ErrUnauthorized      = errors.New("unauthorized")
+	ErrForbidden         = errors.New("forbidden")
+	ErrNotFound          = errors.New("not found")
+	ErrConflict          = errors.New("conflict")
+	ErrTooLarge          = errors.New("too large")
+	ErrInsufficientFunds = errors.New("insufficient funds")
+	ErrUnprocessable     = errors.New("unprocessable")
+	ErrRateLimited       = errors.New("rate limited")
+	ErrUnavailable       = errors.New("unavailable")
+)
+
+// kind describes how errors of a kind are rendered.
+type kind struct {
+	err    error
+	status int
+	code   string // code of errors of the kind without a code of their own
+}
+
+// kinds lists the kinds in the order errors are matched against them, so that
+// an error wrapping several kinds is always rendered as the first one.
+var kinds = []kind{
+	{ErrValidation, http.StatusBadRequest, "validation_failed"},
+	{ErrUnauthorized, http.StatusUnauthorized, "unauthorized"},
+	{ErrForbidden, http.StatusForbidden, "forbidden"},
+	{ErrNotFound, http.StatusNotFound, "not_found"},
+	{ErrConflict, http.StatusConflict, "conflict"},
+	{ErrTooLarge, http.StatusRequestEntityTooLarge, "too_large"},
+	{ErrInsufficientFunds, http.StatusUnprocessableEntity, "insufficient_funds"},
+	{ErrUnprocessable, http.StatusUnprocessableEntity, "unprocessable"},
+	{ErrRateLimited, http.StatusTooManyRequests, "rate_limited"},
+	{ErrUnavailable, http.StatusServiceUnavailable, "unavailable"},
+}
+
+// kindOf returns the first kind err is of.
+func kindOf(err error) (kind, bool) {
+	for _, k := range kinds {
+		if errors.Is(err, k.err) {
+			return k, true
+		}
+	}
+	return kind{}, false
+}
+
+// internalCode is the code of errors of no kind.
+const internalCode = "internal_error"
+
+// Error is an error of a kind with a stable machine code, such as
+// "transaction_not_found". Codes are part of the API and must not change.
+type Error struct {
+	Kind    error
+	Code    string
+	Message string
+	Fields  []FieldError
+}
+
+// FieldError describes an invalid field of a request.
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// New returns an error of a kind with a code. Packages declare their errors
+// with it, so that errors.Is matches both the error and its kind.
+func New(kind error, code, message string) *Error {
+	return &Error{Kind: kind, Code: code, Message: message}
+}
+
+// Validation returns a validation error listing the invalid fields.
+func Validation(message string, fields ...FieldError) *Error {
+	return &Error{Kind: ErrValidation, Code: "validation_failed", Message: message, Fields: fields}
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Unwrap returns the kind of the error.
+func (e *Error) Unwrap() error {
+	return e.Kind
+}
+
+// Problem is an RFC 7807 problem details object, extended with the error's
+// code, the request ID and the invalid fields.
+type Problem struct {
+	Type      string       `json:"type"`
+	Title     string       `json:"title"`
+	Status    int          `json:"status"`
+	Detail    string       `json:"detail,omitempty"`
+	Instance  string       `json:"instance,omitempty"`
+	Code      string       `json:"code"`
+	RequestID string       `json:"request_id,omitempty"`
+	Errors    []FieldError `json:"errors,omitempty"`
+}
+
+// ToProblem describes an error to API clients. Internal errors are described
+// without details, so that nothing about the internals leaks.
+func ToProblem(err error) Problem {
+	problem := Problem{Type: "about:blank"}
+	var e *Error
+	if errors.As(err, &e) {
+		if k, ok := kindOf(e.Kind); ok {
+			problem.Status, problem.Code = k.status, k.code
+			if e.Code != "" {
+				problem.Code = e.Code
+			}
+			problem.Detail = err.Error()
+			problem.Errors = e.Fields
+		}
+	} else if k, ok := kindOf(err); ok {
+		problem.Status, problem.Code = k.status, k.code
+		problem.Detail = err.Error()
+	}
+	if problem.Status == 0 {
+		problem.Status = http.StatusInternalServerError
+		problem.Code = internalCode
+		problem.Detail = "An unexpected error occurred"
+	}
+	problem.Title = http.StatusText(problem.Status)
+	return problem
+}
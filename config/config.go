@@ -24,6 +24,20 @@ type Config struct {
 	Redis            RedisConfig            `mapstructure:"redis" validate:"required,dive"`
 	Cassandra        CassandraConfig        `mapstructure:"cassandra" validate:"required,dive"`
 	Kafka            KafkaConfig            `mapstructure:"kafka" validate:"required,dive"`
+	Money            MoneyConfig            `mapstructure:"money" validate:"required"`
+	Trading          TradingConfig          `mapstructure:"trading"`
+	Idempotency      IdempotencyConfig      `mapstructure:"idempotency"`
+	Outbox           OutboxConfig           `mapstructure:"outbox"`
+	MarketData       MarketDataConfig       `mapstructure:"market_data"`
+	Stream           StreamConfig           `mapstructure:"stream"`
+	Users            UsersConfig            `mapstructure:"users"`
+	TwoFactor        TwoFactorConfig        `mapstructure:"two_factor"`
+	UserAPIKeys      UserAPIKeysConfig      `mapstructure:"user_api_keys"`
+	RateLimit        RateLimitConfig        `mapstructure:"rate_limit"`
+	Shutdown         ShutdownConfig         `mapstructure:"shutdown"`
+	Health           HealthConfig           `mapstructure:"health"`
+	Metrics          MetricsConfig          `mapstructure:"metrics"`
+	Tracing          TracingConfig          `mapstructure:"tracing"`
 	Features         FeaturesConfig         `mapstructure:"features"`
 }
 
@@ -34,6 +48,8 @@ type ServerConfig struct {
 	ReadTimeout  time.Duration `mapstructure:"read_timeout" validate:"required"`
 	WriteTimeout time.Duration `mapstructure:"write_timeout" validate:"required"`
 	IdleTimeout  time.Duration `mapstructure:"idle_timeout" validate:"required"`
+	// TrustedProxies may set X-Forwarded-For; the client IP of other requests is their peer address.
+	TrustedProxies []string `mapstructure:"trusted_proxies" validate:"dive,ip|cidr"`
 }
 
 // DatabaseConfig holds database-related configurations.
@@ -69,10 +85,21 @@ type LoggingConfig struct {
 	OutputPaths []string `mapstructure:"output_paths" validate:"required,min=1,dive,required"`
 }
 
-// JWTConfig holds JWT-related configurations.
+// JWTConfig holds JWT-related configurations. Tokens are signed with SecretKey
+// and carry KeyID as their kid; tokens signed with a retired key stay valid
+// until they expire, so keys can be rotated without logging everyone out.
 type JWTConfig struct {
-	SecretKey     string        `mapstructure:"secret_key" validate:"required"`
-	TokenDuration time.Duration `mapstructure:"token_duration" validate:"required"`
+	SecretKey            string         `mapstructure:"secret_key" validate:"required"`
+	KeyID                string         `mapstructure:"key_id" validate:"required"`
+	TokenDuration        time.Duration  `mapstructure:"token_duration" validate:"required"` // access tokens
+	RefreshTokenDuration time.Duration  `mapstructure:"refresh_token_duration" validate:"required"`
+	RetiredKeys          []JWTKeyConfig `mapstructure:"retired_keys" validate:"dive"`
+}
+
+// JWTKeyConfig holds a retired signing key that is still accepted for verification.
+type JWTKeyConfig struct {
+	KeyID     string `mapstructure:"key_id" validate:"required"`
+	SecretKey string `mapstructure:"secret_key" validate:"required"`
 }
 
 // APIKeysConfig holds API keys for external services.
@@ -115,8 +142,179 @@ type CassandraConfig struct {
 
 // KafkaConfig holds Kafka-related configurations.
 type KafkaConfig struct {
-	Brokers []string `mapstructure:"brokers" validate:"required,min=1,dive,required"`
-	Topic   string   `mapstructure:"topic" validate:"required"`
+	Brokers  []string            `mapstructure:"brokers" validate:"required,min=1,dive,required"`
+	Topic    string              `mapstructure:"topic" validate:"required"`
+	Consumer KafkaConsumerConfig `mapstructure:"consumer"`
+}
+
+// KafkaConsumerConfig holds settings for consuming inbound events.
+// Failed events are retried through one topic per delay (<topic>.retry.<n>)
+// and end up in <topic>.dlq once all retries are exhausted.
+type KafkaConsumerConfig struct {
+	GroupID     string          `mapstructure:"group_id"`
+	Topics      []string        `mapstructure:"topics"`
+	RetryDelays []time.Duration `mapstructure:"retry_delays"`
+}
+
+// MoneyConfig holds the precision and rounding rules of supported assets.
+type MoneyConfig struct {
+	FiatCurrency string        `mapstructure:"fiat_currency" validate:"required"`
+	Assets       []AssetConfig `mapstructure:"assets" validate:"required,min=1,dive"`
+}
+
+// AssetConfig holds the precision and rounding rule of a single asset.
+type AssetConfig struct {
+	Symbol    string `mapstructure:"symbol" validate:"required"`
+	Precision int32  `mapstructure:"precision" validate:"min=0,max=18"`
+	Rounding  string `mapstructure:"rounding" validate:"required,oneof=half_even half_up down up floor ceil"`
+}
+
+// TradingConfig holds the trading pairs served by the matching engine.
+// Nodes with Matching set compete for the order books; the one holding them
+// places and cancels orders, and the others stand by to take over.
+type TradingConfig struct {
+	Pairs    []PairConfig `mapstructure:"pairs" validate:"dive"`
+	Matching bool         `mapstructure:"matching"`
+}
+
+// PairConfig names the base and quote assets of a trading pair, e.g. BTC and USDT.
+type PairConfig struct {
+	Base  string `mapstructure:"base" validate:"required"`
+	Quote string `mapstructure:"quote" validate:"required,nefield=Base"`
+}
+
+// IdempotencyConfig holds settings for Idempotency-Key handling.
+type IdempotencyConfig struct {
+	TTL         time.Duration `mapstructure:"ttl"`
+	LockTimeout time.Duration `mapstructure:"lock_timeout"`
+}
+
+// OutboxConfig holds settings for the relay that publishes outbox messages to Kafka.
+type OutboxConfig struct {
+	PollInterval time.Duration `mapstructure:"poll_interval"`
+	BatchSize    int           `mapstructure:"batch_size" validate:"min=0"`
+	MaxBackoff   time.Duration `mapstructure:"max_backoff"`
+}
+
+// MarketDataConfig holds settings for the market ticker and price history snapshots.
+// A history holds at most MaxCandles candles per market.
+type MarketDataConfig struct {
+	RefreshInterval time.Duration `mapstructure:"refresh_interval" validate:"gte=1s"`
+	HistoryWindow   time.Duration `mapstructure:"history_window" validate:"gte=1h,lte=8760h,gtefield=CandleInterval"`
+	CandleInterval  time.Duration `mapstructure:"candle_interval" validate:"gte=1s,lte=168h"`
+}
+
+// MaxCandles caps the candles of a market's price history, that is the history
+// window divided by the candle interval.
+const MaxCandles = 10000
+
+// validateMarketData checks that a price history does not exceed MaxCandles.
+func validateMarketData(sl validator.StructLevel) {
+	cfg := sl.Current().Interface().(MarketDataConfig)
+	if cfg.CandleInterval > 0 && cfg.HistoryWindow/cfg.CandleInterval > MaxCandles {
+		sl.ReportError(cfg.HistoryWindow, "HistoryWindow", "history_window", "max_candles", "")
+	}
+}
+
+// StreamConfig holds settings for the WebSocket streaming gateway.
+type StreamConfig struct {
+	PingInterval time.Duration `mapstructure:"ping_interval"`
+	SendBuffer   int           `mapstructure:"send_buffer" validate:"min=0"`
+	SnapshotSize int           `mapstructure:"snapshot_size" validate:"min=0,max=100"`
+}
+
+// UsersConfig holds settings for user accounts. VerificationURL is the link
+// sent to verify an email address; %s is replaced by the token.
+type UsersConfig struct {
+	VerificationTTL time.Duration `mapstructure:"verification_ttl"`
+	VerificationURL string        `mapstructure:"verification_url"`
+}
+
+// TwoFactorConfig holds settings for TOTP two-factor authentication. Codes of
+// up to Skew 30-second steps before or after the current one are accepted.
+type TwoFactorConfig struct {
+	Issuer        string `mapstructure:"issuer" validate:"required"`
+	Skew          int    `mapstructure:"skew" validate:"min=0,max=2"`
+	RecoveryCodes int    `mapstructure:"recovery_codes" validate:"min=1,max=20"`
+}
+
+// UserAPIKeysConfig holds settings for the HMAC-signed API keys of users.
+// EncryptionKey is the base64 AES-256 key the stored key secrets are encrypted
+// with; requests are rejected if their timestamp is off by more than
+// TimestampWindow.
+type UserAPIKeysConfig struct {
+	EncryptionKey   string        `mapstructure:"encryption_key" validate:"required,base64"`
+	TimestampWindow time.Duration `mapstructure:"timestamp_window"`
+	MaxPerUser      int           `mapstructure:"max_per_user" validate:"min=1"`
+}
+
+// RateLimitConfig holds the request rate limits of the route groups. Limits
+// apply per user, per API key or, before authentication, per client IP.
+// APIKeyTiers scale the limits of requests signed with keys of a tier.
+type RateLimitConfig struct {
+	Enabled     bool                     `mapstructure:"enabled"`
+	Groups      map[string]RateLimitRule `mapstructure:"groups" validate:"dive"`
+	APIKeyTiers map[string]float64       `mapstructure:"api_key_tiers" validate:"dive,gt=0"`
+}
+
+// RateLimitRule allows Requests requests per Window, all of which may come at once.
+// Each request takes up Window/Requests of the window, which must be at least
+// MinRateLimitInterval.
+type RateLimitRule struct {
+	Requests int           `mapstructure:"requests" validate:"min=1"`
+	Window   time.Duration `mapstructure:"window" validate:"gt=0"`
+}
+
+// MinRateLimitInterval is the smallest share of its window one request may take
+// up; rate limit buckets in Redis count in microseconds.
+const MinRateLimitInterval = time.Microsecond
+
+// validateRateLimitRule checks that a rule does not allow more requests than
+// its window can count.
+func validateRateLimitRule(sl validator.StructLevel) {
+	rule := sl.Current().Interface().(RateLimitRule)
+	if rule.Requests > 0 && rule.Window/time.Duration(rule.Requests) < MinRateLimitInterval {
+		sl.ReportError(rule.Requests, "Requests", "requests", "min_interval", "")
+	}
+}
+
+// ShutdownConfig holds the timeouts of a graceful shutdown. DrainTimeout bounds
+// finishing in-flight HTTP requests and Kafka events; StopTimeout bounds
+// stopping any other component.
+type ShutdownConfig struct {
+	DrainTimeout time.Duration `mapstructure:"drain_timeout" validate:"gt=0"`
+	StopTimeout  time.Duration `mapstructure:"stop_timeout" validate:"gt=0"`
+}
+
+// HealthConfig holds settings for the dependency health checks. The service is
+// not ready while a dependency named in Critical is down; other dependencies
+// only degrade it. Results are reused for CacheTTL, so that frequent probes do
+// not load the stores.
+type HealthConfig struct {
+	Timeout  time.Duration `mapstructure:"timeout" validate:"gt=0"`
+	CacheTTL time.Duration `mapstructure:"cache_ttl" validate:"min=0"`
+	Critical []string      `mapstructure:"critical" validate:"dive,oneof=postgres redis cassandra kafka"`
+}
+
+// MetricsConfig holds settings for the Prometheus metrics endpoint. It is
+// served on a listener of its own, so that it is not exposed with the API.
+type MetricsConfig struct {
+	Enabled bool   `mapstructure:"enabled"`
+	Host    string `mapstructure:"host" validate:"required_if=Enabled true,omitempty,ip"`
+	Port    int    `mapstructure:"port" validate:"required_if=Enabled true,omitempty,min=1,max=65535"`
+}
+
+// TracingConfig holds settings for OpenTelemetry tracing. Spans are exported
+// over OTLP/HTTP to Endpoint, e.g. a local collector, or written to stdout.
+// SampleRatio is the share of new traces recorded; traces started by a caller
+// follow the caller's decision.
+type TracingConfig struct {
+	Enabled     bool    `mapstructure:"enabled"`
+	Exporter    string  `mapstructure:"exporter" validate:"oneof=otlp stdout"`
+	Endpoint    string  `mapstructure:"endpoint" validate:"required_if=Exporter otlp"`
+	Insecure    bool    `mapstructure:"insecure"`
+	ServiceName string  `mapstructure:"service_name" validate:"required"`
+	SampleRatio float64 `mapstructure:"sample_ratio" validate:"min=0,max=1"`
 }
 
 // FeaturesConfig holds feature flags configurations.
@@ -142,6 +340,41 @@ func LoadConfig(configPath string) (Config, error) {
 	viper.SetDefault("server.idle_timeout", "60s")
 	viper.SetDefault("logging.level", "info")
 	viper.SetDefault("logging.format", "console")
+	viper.SetDefault("jwt.key_id", "default")
+	viper.SetDefault("jwt.refresh_token_duration", "720h")
+	viper.SetDefault("idempotency.ttl", "24h")
+	viper.SetDefault("idempotency.lock_timeout", "30s")
+	viper.SetDefault("outbox.poll_interval", "1s")
+	viper.SetDefault("outbox.batch_size", 100)
+	viper.SetDefault("outbox.max_backoff", "1m")
+	viper.SetDefault("kafka.consumer.group_id", "crypto-exchange")
+	viper.SetDefault("kafka.consumer.retry_delays", []string{"5s", "1m", "10m"})
+	viper.SetDefault("market_data.refresh_interval", "10s")
+	viper.SetDefault("market_data.history_window", "168h")
+	viper.SetDefault("market_data.candle_interval", "1h")
+	viper.SetDefault("stream.ping_interval", "30s")
+	viper.SetDefault("stream.send_buffer", 64)
+	viper.SetDefault("stream.snapshot_size", 50)
+	viper.SetDefault("users.verification_ttl", "24h")
+	viper.SetDefault("users.verification_url", "http://localhost:3000/verify-email?token=%s")
+	viper.SetDefault("two_factor.issuer", "Crypto Exchange")
+	viper.SetDefault("two_factor.skew", 1)
+	viper.SetDefault("two_factor.recovery_codes", 10)
+	viper.SetDefault("user_api_keys.timestamp_window", "30s")
+	viper.SetDefault("user_api_keys.max_per_user", 10)
+	viper.SetDefault("rate_limit.enabled", true)
+	viper.SetDefault("shutdown.drain_timeout", "20s")
+	viper.SetDefault("shutdown.stop_timeout", "5s")
+	viper.SetDefault("health.timeout", "2s")
+	viper.SetDefault("health.cache_ttl", "1s")
+	viper.SetDefault("health.critical", []string{"postgres"})
+	viper.SetDefault("metrics.enabled", true)
+	viper.SetDefault("metrics.host", "0.0.0.0")
+	viper.SetDefault("metrics.port", 9102)
+	viper.SetDefault("tracing.exporter", "otlp")
+	viper.SetDefault("tracing.endpoint", "localhost:4318")
+	viper.SetDefault("tracing.service_name", "crypto-exchange")
+	viper.SetDefault("tracing.sample_ratio", 1.0)
 
 	// Read the config file
 	if err := viper.ReadInConfig(); err != nil {
@@ -156,6 +389,8 @@ func LoadConfig(configPath string) (Config, error) {
 
 	// Validate the configuration
 	validate := validator.New()
+	validate.RegisterStructValidation(validateMarketData, MarketDataConfig{})
+	validate.RegisterStructValidation(validateRateLimitRule, RateLimitRule{})
 	if err := validate.Struct(config); err != nil {
 		return config, fmt.Errorf("configuration validation failed: %w", err)
 	}
@@ -0,0 +1,117 @@
+// controllers/admin_controller.go
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+
+	"crypto-exchange/middleware"
+	"crypto-exchange/models"
+	"crypto-exchange/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog"
+)
+
+// AdminController handles staff requests on the accounts of other users.
+type AdminController struct {
+	Users  services.UserService
+	Logger zerolog.Logger
+}
+
+// NewAdminController creates a new instance of AdminController.
+func NewAdminController(users services.UserService, logger zerolog.Logger) *AdminController {
+	return &AdminController{
+		Users:  users,
+		Logger: logger,
+	}
+}
+
+// GetUser handles fetching the account of any user.
+func (ac *AdminController) GetUser(c *gin.Context) {
+	userID, ok := ac.userID(c)
+	if !ok {
+		return
+	}
+	user, err := ac.Users.GetUser(userID)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, user)
+}
+
+// SetUserStatus handles activating, freezing or closing an account.
+func (ac *AdminController) SetUserStatus(c *gin.Context) {
+	userID, ok := ac.userID(c)
+	if !ok {
+		return
+	}
+	var req models.SetUserStatusRequest
+	// Bind JSON input to SetUserStatusRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		ac.Logger.Warn().
+			Err(err).
+			Msg("Invalid user status payload")
+		_ = c.Error(bindingError(err))
+		return
+	}
+
+	actor := middleware.CurrentActor(c)
+	user, err := ac.Users.SetStatus(actor, userID, req.Status)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	ac.Logger.Info().
+		Uint("user_id", userID).
+		Str("status", user.Status).
+		Uint("actor_id", actor.UserID).
+		Msg("User status changed successfully")
+	c.JSON(http.StatusOK, user)
+}
+
+// SetUserRole handles changing the role of a user.
+func (ac *AdminController) SetUserRole(c *gin.Context) {
+	userID, ok := ac.userID(c)
+	if !ok {
+		return
+	}
+	var req models.SetUserRoleRequest
+	// Bind JSON input to SetUserRoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		ac.Logger.Warn().
+			Err(err).
+			Msg("Invalid user role payload")
+		_ = c.Error(bindingError(err))
+		return
+	}
+
+	actor := middleware.CurrentActor(c)
+	user, err := ac.Users.SetRole(actor, userID, req.Role)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	ac.Logger.Info().
+		Uint("user_id", userID).
+		Str("role", user.Role).
+		Uint("actor_id", actor.UserID).
+		Msg("User role changed successfully")
+	c.JSON(http.StatusOK, user)
+}
+
+// userID parses the user_id path parameter, failing the request if it is invalid.
+func (ac *AdminController) userID(c *gin.Context) (uint, bool) {
+	userID, err := strconv.ParseUint(c.Param("user_id"), 10, 64)
+	if err != nil {
+		ac.Logger.Warn().
+			Str("user_id", c.Param("user_id")).
+			Msg("Invalid user ID")
+		_ = c.Error(paramError("user_id", "must be a positive integer"))
+		return 0, false
+	}
+	return uint(userID), true
+}
@@ -0,0 +1,75 @@
+// controllers/api_key_controller.go
+package controllers
+
+import (
+	"net/http"
+
+	"crypto-exchange/middleware"
+	"crypto-exchange/models"
+	"crypto-exchange/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog"
+)
+
+// APIKeyController handles managing the API keys of the authenticated user.
+type APIKeyController struct {
+	Service services.APIKeyService
+	Logger  zerolog.Logger
+}
+
+// NewAPIKeyController creates a new instance of APIKeyController.
+func NewAPIKeyController(service services.APIKeyService, logger zerolog.Logger) *APIKeyController {
+	return &APIKeyController{
+		Service: service,
+		Logger:  logger,
+	}
+}
+
+// CreateAPIKey handles issuing a new API key. Its secret is only returned here.
+func (ac *APIKeyController) CreateAPIKey(c *gin.Context) {
+	var req models.CreateAPIKeyRequest
+	// Bind JSON input to CreateAPIKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		ac.Logger.Warn().
+			Err(err).
+			Msg("Invalid API key payload")
+		_ = c.Error(bindingError(err))
+		return
+	}
+
+	userID := c.GetUint(middleware.UserIDKey)
+	key, err := ac.Service.Create(userID, req)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	c.JSON(http.StatusCreated, key)
+}
+
+// ListAPIKeys handles listing the API keys of the authenticated user.
+func (ac *APIKeyController) ListAPIKeys(c *gin.Context) {
+	userID := c.GetUint(middleware.UserIDKey)
+	keys, err := ac.Service.List(userID)
+	if err != nil {
+		ac.Logger.Error().
+			Err(err).
+			Uint("user_id", userID).
+			Msg("Failed to list API keys")
+		_ = c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"api_keys": keys})
+}
+
+// DeleteAPIKey handles revoking an API key of the authenticated user.
+func (ac *APIKeyController) DeleteAPIKey(c *gin.Context) {
+	id := c.Param("id")
+	userID := c.GetUint(middleware.UserIDKey)
+	err := ac.Service.Delete(id, userID)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
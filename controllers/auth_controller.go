@@ -0,0 +1,157 @@
+// controllers/auth_controller.go
+package controllers
+
+import (
+	"errors"
+	"net/http"
+
+	"crypto-exchange/apperrors"
+	"crypto-exchange/middleware"
+	"crypto-exchange/models"
+	"crypto-exchange/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog"
+)
+
+// Errors of refresh tokens that are not accepted.
+var (
+	errInvalidRefreshToken = apperrors.New(apperrors.ErrUnauthorized, "invalid_refresh_token", "invalid refresh token")
+	errForeignRefreshToken = apperrors.Validation("invalid refresh token",
+		apperrors.FieldError{Field: "refresh_token", Code: "invalid", Message: "must be a refresh token of the authenticated user"})
+)
+
+// AuthController handles login, token refresh and logout requests.
+type AuthController struct {
+	Tokens      *services.TokenService
+	Credentials services.CredentialVerifier
+	Logger      zerolog.Logger
+}
+
+// NewAuthController creates a new instance of AuthController.
+func NewAuthController(tokens *services.TokenService, credentials services.CredentialVerifier, logger zerolog.Logger) *AuthController {
+	return &AuthController{
+		Tokens:      tokens,
+		Credentials: credentials,
+		Logger:      logger,
+	}
+}
+
+// Login handles exchanging an email and password for a token pair.
+func (ac *AuthController) Login(c *gin.Context) {
+	var req models.LoginRequest
+	// Bind JSON input to LoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		ac.Logger.Warn().
+			Err(err).
+			Msg("Invalid login payload")
+		_ = c.Error(bindingError(err))
+		return
+	}
+
+	userID, err := ac.Credentials.VerifyCredentials(req.Email, req.Password)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	tokens, err := ac.Tokens.IssueTokens(userID)
+	if err != nil {
+		ac.Logger.Error().
+			Err(err).
+			Uint("user_id", userID).
+			Msg("Failed to issue tokens")
+		_ = c.Error(err)
+		return
+	}
+
+	ac.Logger.Info().
+		Uint("user_id", userID).
+		Msg("User logged in")
+	c.JSON(http.StatusOK, tokens)
+}
+
+// Refresh handles exchanging a refresh token for a new token pair.
+func (ac *AuthController) Refresh(c *gin.Context) {
+	var req models.RefreshRequest
+	// Bind JSON input to RefreshRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		ac.Logger.Warn().
+			Err(err).
+			Msg("Invalid refresh payload")
+		_ = c.Error(bindingError(err))
+		return
+	}
+
+	tokens, err := ac.Tokens.Refresh(req.RefreshToken)
+	if errors.Is(err, services.ErrInvalidToken) || errors.Is(err, services.ErrTokenRevoked) || errors.Is(err, services.ErrUserNotFound) {
+		ac.Logger.Warn().
+			Err(err).
+			Msg("Rejected refresh token")
+		_ = c.Error(errInvalidRefreshToken)
+		return
+	}
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, tokens)
+}
+
+// Logout handles revoking the access token of the request and, if given, a
+// refresh token of the same user.
+func (ac *AuthController) Logout(c *gin.Context) {
+	var req models.LogoutRequest
+	// The body is optional
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			ac.Logger.Warn().
+				Err(err).
+				Msg("Invalid logout payload")
+			_ = c.Error(bindingError(err))
+			return
+		}
+	}
+	claims := c.MustGet(middleware.TokenClaimsKey).(*services.TokenClaims)
+
+	if req.RefreshToken != "" {
+		refresh, err := ac.Tokens.Verify(req.RefreshToken, services.RefreshTokenType)
+		switch {
+		case errors.Is(err, services.ErrTokenRevoked):
+			// Already logged out
+		case errors.Is(err, services.ErrInvalidToken) || (err == nil && refresh.Subject != claims.Subject):
+			ac.Logger.Warn().
+				Err(err).
+				Msg("Rejected refresh token on logout")
+			_ = c.Error(errForeignRefreshToken)
+			return
+		case err != nil:
+			ac.Logger.Error().
+				Err(err).
+				Msg("Failed to verify refresh token")
+			_ = c.Error(err)
+			return
+		default:
+			if err := ac.Tokens.Revoke(refresh); err != nil {
+				ac.Logger.Error().
+					Err(err).
+					Msg("Failed to revoke refresh token")
+				_ = c.Error(err)
+				return
+			}
+		}
+	}
+
+	if err := ac.Tokens.Revoke(claims); err != nil {
+		ac.Logger.Error().
+			Err(err).
+			Msg("Failed to revoke access token")
+		_ = c.Error(err)
+		return
+	}
+
+	ac.Logger.Info().
+		Str("user_id", claims.Subject).
+		Msg("User logged out")
+	c.Status(http.StatusNoContent)
+}
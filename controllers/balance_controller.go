@@ -0,0 +1,67 @@
+// controllers/balance_controller.go
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+
+	"crypto-exchange/logging"
+	"crypto-exchange/middleware"
+	"crypto-exchange/policy"
+	"crypto-exchange/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog"
+)
+
+// BalanceController handles balance-related HTTP requests.
+type BalanceController struct {
+	Service services.BalanceService
+	Logger  zerolog.Logger
+}
+
+// NewBalanceController creates a new instance of BalanceController.
+func NewBalanceController(service services.BalanceService, logger zerolog.Logger) *BalanceController {
+	return &BalanceController{
+		Service: service,
+		Logger:  logger,
+	}
+}
+
+// GetUserBalances handles fetching the per-asset balances of a user.
+func (bc *BalanceController) GetUserBalances(c *gin.Context) {
+	log := logging.FromContext(c.Request.Context(), bc.Logger)
+	userID, err := strconv.ParseUint(c.Param("user_id"), 10, 64)
+	if err != nil {
+		log.Warn().
+			Str("user_id", c.Param("user_id")).
+			Msg("Invalid user ID")
+		_ = c.Error(paramError("user_id", "must be a positive integer"))
+		return
+	}
+	if uint(userID) != c.GetUint(middleware.UserIDKey) {
+		log.Warn().
+			Uint64("user_id", userID).
+			Uint("authenticated_user_id", c.GetUint(middleware.UserIDKey)).
+			Msg("Balances of another user requested")
+		_ = c.Error(policy.ErrForbidden)
+		return
+	}
+
+	// Retrieve the balances using the service
+	balances, err := bc.Service.GetUserBalances(uint(userID))
+	if err != nil {
+		log.Error().
+			Err(err).
+			Uint64("user_id", userID).
+			Msg("Failed to retrieve balances")
+		_ = c.Error(err)
+		return
+	}
+
+	// Respond with the balances
+	log.Info().
+		Uint64("user_id", userID).
+		Msg("Balances retrieved successfully")
+	c.JSON(http.StatusOK, gin.H{"user_id": userID, "balances": balances})
+}
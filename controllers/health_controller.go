@@ -0,0 +1,44 @@
+// controllers/health_controller.go
+package controllers
+
+import (
+	"net/http"
+
+	"crypto-exchange/models"
+	"crypto-exchange/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog"
+)
+
+// HealthController handles the liveness and readiness probes.
+type HealthController struct {
+	Service services.HealthService
+	Logger  zerolog.Logger
+}
+
+// NewHealthController creates a new instance of HealthController.
+func NewHealthController(service services.HealthService, logger zerolog.Logger) *HealthController {
+	return &HealthController{
+		Service: service,
+		Logger:  logger,
+	}
+}
+
+// Liveness reports that the process is serving requests. It does not check
+// dependencies, so that an outage of a store does not get the service restarted.
+func (hc *HealthController) Liveness(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": models.HealthUp})
+}
+
+// Readiness reports the health of the dependencies. It responds with 503 while
+// a critical dependency is down, so that no traffic is routed to the service;
+// a degraded service stays ready.
+func (hc *HealthController) Readiness(c *gin.Context) {
+	report := hc.Service.Check(c.Request.Context())
+	status := http.StatusOK
+	if report.Status == models.HealthDown {
+		status = http.StatusServiceUnavailable
+	}
+	c.JSON(status, report)
+}
@@ -0,0 +1,57 @@
+// controllers/market_controller.go
+package controllers
+
+import (
+	"net/http"
+
+	"crypto-exchange/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog"
+)
+
+// MarketController handles public market data requests.
+type MarketController struct {
+	Service services.MarketDataService
+	Logger  zerolog.Logger
+}
+
+// NewMarketController creates a new instance of MarketController.
+func NewMarketController(service services.MarketDataService, logger zerolog.Logger) *MarketController {
+	return &MarketController{
+		Service: service,
+		Logger:  logger,
+	}
+}
+
+// ListMarkets handles fetching the ticker of every market.
+func (mc *MarketController) ListMarkets(c *gin.Context) {
+	list, err := mc.Service.ListMarkets()
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, list)
+}
+
+// GetTicker handles fetching the ticker of one market.
+func (mc *MarketController) GetTicker(c *gin.Context) {
+	symbol := c.Param("symbol")
+	ticker, err := mc.Service.GetTicker(symbol)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, ticker)
+}
+
+// GetHistory handles fetching the price history of one market.
+func (mc *MarketController) GetHistory(c *gin.Context) {
+	symbol := c.Param("symbol")
+	history, err := mc.Service.GetHistory(symbol)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, history)
+}
@@ -0,0 +1,73 @@
+// controllers/order_controller.go
+package controllers
+
+import (
+	"net/http"
+
+	"crypto-exchange/middleware"
+	"crypto-exchange/models"
+	"crypto-exchange/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog"
+)
+
+// OrderController handles order-related HTTP requests.
+type OrderController struct {
+	Service services.OrderService
+	Logger  zerolog.Logger
+}
+
+// NewOrderController creates a new instance of OrderController.
+func NewOrderController(service services.OrderService, logger zerolog.Logger) *OrderController {
+	return &OrderController{
+		Service: service,
+		Logger:  logger,
+	}
+}
+
+// PlaceOrder handles placing a new order on the book.
+func (oc *OrderController) PlaceOrder(c *gin.Context) {
+	var req models.PlaceOrderRequest
+	// Bind JSON input to PlaceOrderRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		oc.Logger.Error().
+			Err(err).
+			Msg("Invalid order payload")
+		_ = c.Error(bindingError(err))
+		return
+	}
+	req.UserID = c.GetUint(middleware.UserIDKey)
+
+	// Match the order using the service
+	result, err := oc.Service.PlaceOrder(req)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	// Respond with the order and its trades
+	oc.Logger.Info().
+		Str("order_id", result.Order.ID).
+		Str("status", result.Order.Status).
+		Msg("Order placed successfully")
+	c.JSON(http.StatusCreated, result)
+}
+
+// CancelOrder handles taking an open order off the book.
+func (oc *OrderController) CancelOrder(c *gin.Context) {
+	id := c.Param("id")
+
+	// Cancel the order using the service
+	order, err := oc.Service.CancelOrder(id, c.GetUint(middleware.UserIDKey))
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	// Respond with the cancelled order
+	oc.Logger.Info().
+		Str("order_id", order.ID).
+		Msg("Order cancelled successfully")
+	c.JSON(http.StatusOK, order)
+}
@@ -0,0 +1,38 @@
+// controllers/outbox_controller.go
+package controllers
+
+import (
+	"net/http"
+
+	"crypto-exchange/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog"
+)
+
+// OutboxController handles requests about the Kafka outbox relay.
+type OutboxController struct {
+	Service services.OutboxService
+	Logger  zerolog.Logger
+}
+
+// NewOutboxController creates a new instance of OutboxController.
+func NewOutboxController(service services.OutboxService, logger zerolog.Logger) *OutboxController {
+	return &OutboxController{
+		Service: service,
+		Logger:  logger,
+	}
+}
+
+// GetLag handles reporting how far the outbox relay is behind.
+func (oc *OutboxController) GetLag(c *gin.Context) {
+	lag, err := oc.Service.Lag()
+	if err != nil {
+		oc.Logger.Error().
+			Err(err).
+			Msg("Failed to compute outbox lag")
+		_ = c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, lag)
+}
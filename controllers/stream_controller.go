@@ -0,0 +1,167 @@
+// controllers/stream_controller.go
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"crypto-exchange/config"
+	"crypto-exchange/middleware"
+	"crypto-exchange/models"
+	"crypto-exchange/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/rs/zerolog"
+)
+
+const (
+	// streamWriteWait is the time allowed to write one message to a client.
+	streamWriteWait = 10 * time.Second
+	// streamMaxRequestSize is the largest message accepted from a client.
+	streamMaxRequestSize = 4096
+)
+
+// streamUpgrader upgrades GET /ws requests. Clients authenticate with a token
+// rather than cookies, so cross-origin connections carry no ambient credentials.
+var streamUpgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+	CheckOrigin:     func(*http.Request) bool { return true },
+}
+
+// StreamController handles WebSocket streaming connections.
+type StreamController struct {
+	Hub    *services.StreamHub
+	Logger zerolog.Logger
+	Config config.StreamConfig
+}
+
+// NewStreamController creates a new instance of StreamController.
+func NewStreamController(hub *services.StreamHub, logger zerolog.Logger, cfg config.StreamConfig) *StreamController {
+	return &StreamController{
+		Hub:    hub,
+		Logger: logger,
+		Config: cfg,
+	}
+}
+
+// Stream handles a WebSocket connection of an authenticated user. The client
+// sends subscribe and unsubscribe requests and receives snapshots and updates.
+func (sc *StreamController) Stream(c *gin.Context) {
+	userID := c.GetUint(middleware.UserIDKey)
+
+	// Upgrade replies with an HTTP error itself if the handshake is invalid
+	conn, err := streamUpgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		sc.Logger.Warn().
+			Err(err).
+			Uint("user_id", userID).
+			Msg("Failed to upgrade stream connection")
+		return
+	}
+
+	sub := sc.Hub.Register(userID)
+	defer sc.Hub.Unregister(sub)
+	sc.Logger.Info().
+		Uint("user_id", userID).
+		Msg("Stream connection opened")
+
+	stop := make(chan struct{})
+	go sc.writeLoop(conn, sub, stop)
+	sc.readLoop(conn, sub)
+	close(stop)
+
+	sc.Logger.Info().
+		Uint("user_id", userID).
+		Msg("Stream connection closed")
+}
+
+// readLoop handles the client's requests until the connection fails or the
+// client stops answering pings.
+func (sc *StreamController) readLoop(conn *websocket.Conn, sub *services.StreamSubscriber) {
+	conn.SetReadLimit(streamMaxRequestSize)
+	conn.SetReadDeadline(time.Now().Add(2 * sc.Config.PingInterval))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(2 * sc.Config.PingInterval))
+	})
+
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		var req models.StreamRequest
+		if err := json.Unmarshal(data, &req); err != nil {
+			sc.Hub.Reply(sub, models.StreamMessage{Type: models.StreamError, Error: "Invalid request"})
+			continue
+		}
+
+		switch req.Op {
+		case "subscribe":
+			err := sc.Hub.Subscribe(context.Background(), sub, req.Channel)
+			sc.replyError(sub, req, err)
+		case "unsubscribe":
+			err := sc.Hub.Unsubscribe(sub, req.Channel)
+			sc.replyError(sub, req, err)
+		default:
+			sc.Hub.Reply(sub, models.StreamMessage{Type: models.StreamError, Channel: req.Channel, Error: "Unknown op"})
+		}
+	}
+}
+
+// writeLoop sends the subscriber's messages and keep-alive pings until the
+// read loop stops, a write fails or the hub drops the subscriber, whom it
+// then tells why.
+func (sc *StreamController) writeLoop(conn *websocket.Conn, sub *services.StreamSubscriber, stop <-chan struct{}) {
+	ticker := time.NewTicker(sc.Config.PingInterval)
+	defer ticker.Stop()
+	// Closing the connection also ends the read loop
+	defer conn.Close()
+
+	for {
+		select {
+		case msg := <-sub.Send:
+			conn.SetWriteDeadline(time.Now().Add(streamWriteWait))
+			if err := conn.WriteJSON(msg); err != nil {
+				return
+			}
+		case <-ticker.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(streamWriteWait)); err != nil {
+				return
+			}
+		case <-sub.Done:
+			code := websocket.CloseTryAgainLater
+			if errors.Is(sub.Err(), services.ErrStreamClosed) {
+				code = websocket.CloseGoingAway
+			}
+			closeMsg := websocket.FormatCloseMessage(code, sub.Err().Error())
+			conn.WriteControl(websocket.CloseMessage, closeMsg, time.Now().Add(streamWriteWait))
+			return
+		case <-stop:
+			return
+		}
+	}
+}
+
+// replyError reports a failed subscribe or unsubscribe request to the client.
+func (sc *StreamController) replyError(sub *services.StreamSubscriber, req models.StreamRequest, err error) {
+	if err == nil {
+		return
+	}
+	msg := models.StreamMessage{Type: models.StreamError, Channel: req.Channel}
+	if errors.Is(err, services.ErrUnknownChannel) {
+		msg.Error = "Unknown channel"
+	} else {
+		sc.Logger.Error().
+			Err(err).
+			Uint("user_id", sub.UserID).
+			Str("channel", req.Channel).
+			Msgf("Failed to %s", req.Op)
+		msg.Error = "Failed to " + req.Op
+	}
+	sc.Hub.Reply(sub, msg)
+}
@@ -6,7 +6,11 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/rs/zerolog"
+	"crypto-exchange/logging"
+	"crypto-exchange/metrics"
+	"crypto-exchange/middleware"
 	"crypto-exchange/models"
+	"crypto-exchange/policy"
 	"crypto-exchange/services"
 )
 
@@ -26,29 +30,29 @@ func NewTransactionController(service services.TransactionService, logger zerolo
 
 // CreateTransaction handles the creation of a new transaction.
 func (tc *TransactionController) CreateTransaction(c *gin.Context) {
+	log := logging.FromContext(c.Request.Context(), tc.Logger)
 	var tx models.Transaction
 	// Bind JSON input to Transaction model
 	if err := c.ShouldBindJSON(&tx); err != nil {
-		tc.Logger.Error().
+		log.Error().
 			Err(err).
 			Msg("Invalid transaction payload")
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid payload"})
+		_ = c.Error(bindingError(err))
 		return
 	}
+	// The owner is the authenticated user, whatever the payload says
+	tx.UserID = c.GetUint(middleware.UserIDKey)
 
 	// Create the transaction using the service
-	createdTx, err := tc.Service.CreateTransaction(tx)
+	createdTx, err := tc.Service.CreateTransaction(c.Request.Context(), tx)
 	if err != nil {
-		tc.Logger.Error().
-			Err(err).
-			Str("transaction_id", tx.ID).
-			Msg("Failed to create transaction")
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create transaction"})
+		_ = c.Error(err)
 		return
 	}
 
 	// Respond with the created transaction
-	tc.Logger.Info().
+	metrics.TransactionsCreated.WithLabelValues(createdTx.Type, createdTx.CryptoSymbol, createdTx.Status).Inc()
+	log.Info().
 		Str("transaction_id", createdTx.ID).
 		Msg("Transaction created successfully")
 	c.JSON(http.StatusCreated, createdTx)
@@ -56,27 +60,153 @@ func (tc *TransactionController) CreateTransaction(c *gin.Context) {
 
 // GetTransaction handles fetching a transaction by ID.
 func (tc *TransactionController) GetTransaction(c *gin.Context) {
+	log := logging.FromContext(c.Request.Context(), tc.Logger)
 	id := c.Param("id")
 	if id == "" {
-		tc.Logger.Warn().Msg("Transaction ID is required")
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Transaction ID is required"})
+		log.Warn().Msg("Transaction ID is required")
+		_ = c.Error(paramError("id", "is required"))
 		return
 	}
 
 	// Retrieve the transaction using the service
-	tx, err := tc.Service.GetTransactionByID(id)
+	tx, err := tc.Service.GetTransactionByID(c.Request.Context(), middleware.CurrentActor(c), id)
 	if err != nil {
-		tc.Logger.Error().
-			Err(err).
-			Str("transaction_id", id).
-			Msg("Transaction not found")
-		c.JSON(http.StatusNotFound, gin.H{"error": "Transaction not found"})
+		_ = c.Error(err)
 		return
 	}
 
 	// Respond with the retrieved transaction
-	tc.Logger.Info().
+	log.Info().
 		Str("transaction_id", tx.ID).
 		Msg("Transaction retrieved successfully")
 	c.JSON(http.StatusOK, tx)
-}
\ No newline at end of file
+}
+
+// ListTransactions handles browsing transactions with filters and cursor pagination.
+func (tc *TransactionController) ListTransactions(c *gin.Context) {
+	log := logging.FromContext(c.Request.Context(), tc.Logger)
+	var filter models.TransactionFilter
+	// Bind query parameters to TransactionFilter
+	if err := c.ShouldBindQuery(&filter); err != nil {
+		log.Warn().
+			Err(err).
+			Msg("Invalid transaction filter")
+		_ = c.Error(bindingError(err))
+		return
+	}
+	// Users only browse their own transactions; support may filter by any
+	// user or browse all of them
+	actor := middleware.CurrentActor(c)
+	if !actor.Can(policy.ViewAnyTransaction) {
+		filter.UserID = actor.UserID
+	}
+
+	// Retrieve the page using the service
+	page, err := tc.Service.ListTransactions(c.Request.Context(), actor, filter)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	// Respond with the page
+	log.Info().
+		Int("count", len(page.Transactions)).
+		Msg("Transactions listed successfully")
+	c.JSON(http.StatusOK, page)
+}
+
+// TransitionTransaction handles moving a transaction to a new status.
+func (tc *TransactionController) TransitionTransaction(c *gin.Context) {
+	log := logging.FromContext(c.Request.Context(), tc.Logger)
+	id := c.Param("id")
+	var req models.TransitionRequest
+	// Bind JSON input to TransitionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		log.Error().
+			Err(err).
+			Msg("Invalid transition payload")
+		_ = c.Error(bindingError(err))
+		return
+	}
+
+	tc.transition(c, id, req.Status, req.Reason)
+}
+
+// ForceFailTransaction handles failing a pending or processing transaction of
+// any user, a compliance operation that must give a reason.
+func (tc *TransactionController) ForceFailTransaction(c *gin.Context) {
+	log := logging.FromContext(c.Request.Context(), tc.Logger)
+	id := c.Param("id")
+	var req models.ForceFailRequest
+	// Bind JSON input to ForceFailRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		log.Warn().
+			Err(err).
+			Msg("Invalid force-fail payload")
+		_ = c.Error(bindingError(err))
+		return
+	}
+
+	tc.transition(c, id, models.StatusFailed, req.Reason)
+}
+
+// transition moves a transaction to a new status on behalf of the
+// authenticated user and responds with the result.
+func (tc *TransactionController) transition(c *gin.Context, id, status, reason string) {
+	log := logging.FromContext(c.Request.Context(), tc.Logger)
+	// Apply the transition using the service
+	actor := middleware.CurrentActor(c)
+	tx, err := tc.Service.TransitionTransaction(c.Request.Context(), actor, id, status, reason)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	// Respond with the updated transaction
+	log.Info().
+		Str("transaction_id", tx.ID).
+		Str("status", tx.Status).
+		Msg("Transaction status changed successfully")
+	c.JSON(http.StatusOK, tx)
+}
+
+// StepUpWithdrawal handles confirming a pending withdrawal with a TOTP or recovery code.
+func (tc *TransactionController) StepUpWithdrawal(c *gin.Context) {
+	log := logging.FromContext(c.Request.Context(), tc.Logger)
+	id := c.Param("id")
+	var req models.TwoFactorCodeRequest
+	// Bind JSON input to TwoFactorCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		log.Warn().
+			Err(err).
+			Msg("Invalid step-up payload")
+		_ = c.Error(bindingError(err))
+		return
+	}
+
+	tx, err := tc.Service.StepUpWithdrawal(c.Request.Context(), id, c.GetUint(middleware.UserIDKey), req.Code)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	log.Info().
+		Str("transaction_id", tx.ID).
+		Msg("Withdrawal stepped up successfully")
+	c.JSON(http.StatusOK, tx)
+}
+
+// GetTransactionHistory handles fetching the status history of a transaction.
+func (tc *TransactionController) GetTransactionHistory(c *gin.Context) {
+	id := c.Param("id")
+
+	// Retrieve the history using the service
+	history, err := tc.Service.GetTransactionHistory(c.Request.Context(), middleware.CurrentActor(c), id)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	// Respond with the history
+	c.JSON(http.StatusOK, gin.H{"transaction_id": id, "transitions": history})
+}
@@ -0,0 +1,80 @@
+// controllers/two_factor_controller.go
+package controllers
+
+import (
+	"net/http"
+
+	"crypto-exchange/middleware"
+	"crypto-exchange/models"
+	"crypto-exchange/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog"
+)
+
+// TwoFactorController handles TOTP enrollment requests of the authenticated user.
+type TwoFactorController struct {
+	Service services.TwoFactorService
+	Logger  zerolog.Logger
+}
+
+// NewTwoFactorController creates a new instance of TwoFactorController.
+func NewTwoFactorController(service services.TwoFactorService, logger zerolog.Logger) *TwoFactorController {
+	return &TwoFactorController{
+		Service: service,
+		Logger:  logger,
+	}
+}
+
+// Enroll handles creating a TOTP secret and its provisioning URI.
+func (tc *TwoFactorController) Enroll(c *gin.Context) {
+	userID := c.GetUint(middleware.UserIDKey)
+	enrollment, err := tc.Service.Enroll(userID)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	c.JSON(http.StatusCreated, enrollment)
+}
+
+// Enable handles confirming the enrolled secret with a first code.
+func (tc *TwoFactorController) Enable(c *gin.Context) {
+	var req models.TwoFactorCodeRequest
+	// Bind JSON input to TwoFactorCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		tc.Logger.Warn().
+			Err(err).
+			Msg("Invalid two-factor payload")
+		_ = c.Error(bindingError(err))
+		return
+	}
+
+	userID := c.GetUint(middleware.UserIDKey)
+	codes, err := tc.Service.Enable(userID, req.Code)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, codes)
+}
+
+// Disable handles turning off two-factor authentication with a TOTP or recovery code.
+func (tc *TwoFactorController) Disable(c *gin.Context) {
+	var req models.TwoFactorCodeRequest
+	// Bind JSON input to TwoFactorCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		tc.Logger.Warn().
+			Err(err).
+			Msg("Invalid two-factor payload")
+		_ = c.Error(bindingError(err))
+		return
+	}
+
+	userID := c.GetUint(middleware.UserIDKey)
+	err := tc.Service.Disable(userID, req.Code)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
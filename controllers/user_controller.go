@@ -0,0 +1,130 @@
+// controllers/user_controller.go
+package controllers
+
+import (
+	"net/http"
+
+	"crypto-exchange/middleware"
+	"crypto-exchange/models"
+	"crypto-exchange/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog"
+)
+
+// UserController handles registration and profile requests.
+type UserController struct {
+	Service services.UserService
+	Logger  zerolog.Logger
+}
+
+// NewUserController creates a new instance of UserController.
+func NewUserController(service services.UserService, logger zerolog.Logger) *UserController {
+	return &UserController{
+		Service: service,
+		Logger:  logger,
+	}
+}
+
+// Register handles creating an account and sending its verification email.
+func (uc *UserController) Register(c *gin.Context) {
+	var req models.RegisterRequest
+	// Bind JSON input to RegisterRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		uc.Logger.Warn().
+			Err(err).
+			Msg("Invalid registration payload")
+		_ = c.Error(bindingError(err))
+		return
+	}
+
+	user, err := uc.Service.Register(req)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	uc.Logger.Info().
+		Uint("user_id", user.ID).
+		Msg("User registered successfully")
+	c.JSON(http.StatusCreated, user)
+}
+
+// VerifyEmail handles confirming an email address with the token of a verification email.
+func (uc *UserController) VerifyEmail(c *gin.Context) {
+	var req models.VerifyEmailRequest
+	// Bind JSON input to VerifyEmailRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		uc.Logger.Warn().
+			Err(err).
+			Msg("Invalid email verification payload")
+		_ = c.Error(bindingError(err))
+		return
+	}
+
+	user, err := uc.Service.VerifyEmail(req.Token)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, user)
+}
+
+// ResendVerification handles requesting a new verification email. It answers
+// the same way whether or not the email is registered.
+func (uc *UserController) ResendVerification(c *gin.Context) {
+	var req models.ResendVerificationRequest
+	// Bind JSON input to ResendVerificationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		uc.Logger.Warn().
+			Err(err).
+			Msg("Invalid resend verification payload")
+		_ = c.Error(bindingError(err))
+		return
+	}
+
+	if err := uc.Service.ResendVerification(req.Email); err != nil {
+		uc.Logger.Error().
+			Err(err).
+			Msg("Failed to resend verification email")
+		_ = c.Error(err)
+		return
+	}
+	c.Status(http.StatusAccepted)
+}
+
+// GetProfile handles fetching the profile of the authenticated user.
+func (uc *UserController) GetProfile(c *gin.Context) {
+	userID := c.GetUint(middleware.UserIDKey)
+	user, err := uc.Service.GetUser(userID)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, user)
+}
+
+// UpdateProfile handles changing the profile of the authenticated user.
+func (uc *UserController) UpdateProfile(c *gin.Context) {
+	var req models.UpdateProfileRequest
+	// Bind JSON input to UpdateProfileRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		uc.Logger.Warn().
+			Err(err).
+			Msg("Invalid profile payload")
+		_ = c.Error(bindingError(err))
+		return
+	}
+
+	userID := c.GetUint(middleware.UserIDKey)
+	user, err := uc.Service.UpdateProfile(userID, req)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	uc.Logger.Info().
+		Uint("user_id", userID).
+		Msg("Profile updated successfully")
+	c.JSON(http.StatusOK, user)
+}
@@ -0,0 +1,126 @@
+// controllers/validation.go
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"crypto-exchange/apperrors"
+
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+	"github.com/shopspring/decimal"
+)
+
+// identifierPattern matches the IDs clients may choose for their records.
+var identifierPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// RegisterValidators teaches Gin's validator about the custom types used in request payloads.
+func RegisterValidators() error {
+	v, ok := binding.Validator.Engine().(*validator.Validate)
+	if !ok {
+		return errors.New("unexpected binding validator engine")
+	}
+
+	// Validate decimals by their numeric value so that tags such as
+	// "required,gt=0" work on decimal.Decimal fields. The conversion is only
+	// used for comparisons; the exact value is kept in the model.
+	v.RegisterCustomTypeFunc(func(field reflect.Value) interface{} {
+		if d, ok := field.Interface().(decimal.Decimal); ok {
+			f, _ := d.Float64()
+			return f
+		}
+		return nil
+	}, decimal.Decimal{})
+
+	// Client-chosen IDs are short and free of separators, so that they cannot
+	// collide with other keys built from them
+	if err := v.RegisterValidation("identifier", func(fl validator.FieldLevel) bool {
+		return identifierPattern.MatchString(fl.Field().String())
+	}); err != nil {
+		return err
+	}
+
+	// Name invalid fields as clients send them, not as they are named in Go
+	v.RegisterTagNameFunc(func(field reflect.StructField) string {
+		for _, tag := range []string{"json", "form"} {
+			name, _, _ := strings.Cut(field.Tag.Get(tag), ",")
+			if name == "-" {
+				return ""
+			}
+			if name != "" {
+				return name
+			}
+		}
+		return field.Name
+	})
+	return nil
+}
+
+// bindingError describes why a request could not be bound, listing the
+// invalid fields where they are known.
+func bindingError(err error) error {
+	var invalid validator.ValidationErrors
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &invalid):
+		fields := make([]apperrors.FieldError, len(invalid))
+		for i, fe := range invalid {
+			fields[i] = apperrors.FieldError{Field: fe.Field(), Code: fe.Tag(), Message: fieldMessage(fe)}
+		}
+		return apperrors.Validation("request has invalid fields", fields...)
+	case errors.As(err, &typeErr):
+		return apperrors.Validation("request has invalid fields", apperrors.FieldError{
+			Field:   typeErr.Field,
+			Code:    "type",
+			Message: "must be of type " + typeErr.Type.String(),
+		})
+	}
+	return apperrors.Validation("malformed request")
+}
+
+// fieldMessage describes a failed validation rule to clients.
+func fieldMessage(fe validator.FieldError) string {
+	switch fe.Tag() {
+	case "required", "required_if":
+		return "is required"
+	case "oneof":
+		return "must be one of: " + strings.ReplaceAll(fe.Param(), " ", ", ")
+	case "email":
+		return "must be an email address"
+	case "ip|cidr":
+		return "must be an IP address or CIDR range"
+	case "min":
+		return "must be at least " + fe.Param() + lengthUnit(fe)
+	case "max":
+		return "must be at most " + fe.Param() + lengthUnit(fe)
+	case "gt":
+		return "must be greater than " + fe.Param()
+	case "gte":
+		return "must be at least " + fe.Param()
+	case "identifier":
+		return "must be 1 to 64 letters, digits, underscores or hyphens"
+	}
+	return fmt.Sprintf("failed the %s rule", fe.Tag())
+}
+
+// lengthUnit names what min and max count for fields that are not numbers.
+func lengthUnit(fe validator.FieldError) string {
+	switch fe.Kind() {
+	case reflect.String:
+		return " characters"
+	case reflect.Slice, reflect.Map, reflect.Array:
+		return " items"
+	}
+	return ""
+}
+
+// paramError describes an invalid path parameter.
+func paramError(name, message string) error {
+	return apperrors.Validation("invalid "+strings.ReplaceAll(name, "_", " "),
+		apperrors.FieldError{Field: name, Code: "param", Message: message})
+}
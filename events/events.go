@@ -0,0 +1,104 @@
+// events/events.go
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"crypto-exchange/models"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+)
+
+// Event types.
+const (
+	TransactionCreated       = "transaction.created"
+	TransactionStatusChanged = "transaction.status_changed"
+
+	// DepositConfirmed is consumed from the chain watchers.
+	DepositConfirmed = "deposit.confirmed"
+)
+
+// Envelope wraps every event published to Kafka.
+// Key is the Kafka message key; it is not part of the encoded event.
+type Envelope struct {
+	EventID     string          `json:"event_id"`
+	Type        string          `json:"type"`
+	Version     int             `json:"version"`
+	OccurredAt  time.Time       `json:"occurred_at"`
+	AggregateID string          `json:"aggregate_id"`
+	Payload     json.RawMessage `json:"payload"`
+	Key         string          `json:"-"`
+}
+
+// StatusChanged is the payload of a transaction.status_changed event.
+type StatusChanged struct {
+	TransactionID string             `json:"transaction_id"`
+	UserID        uint               `json:"user_id"`
+	FromStatus    string             `json:"from_status"`
+	ToStatus      string             `json:"to_status"`
+	Reason        string             `json:"reason,omitempty"`
+	Transaction   models.Transaction `json:"transaction"`
+}
+
+// DepositConfirmation is the payload of a deposit.confirmed event.
+type DepositConfirmation struct {
+	TransactionID string          `json:"transaction_id"`
+	UserID        uint            `json:"user_id"`
+	CryptoSymbol  string          `json:"crypto_symbol"`
+	CryptoAmount  decimal.Decimal `json:"crypto_amount"`
+	TxHash        string          `json:"tx_hash"`
+	Confirmations int             `json:"confirmations"`
+}
+
+// New builds an envelope around a payload.
+func New(eventType string, version int, aggregateID, key string, payload interface{}) (Envelope, error) {
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return Envelope{}, fmt.Errorf("failed to encode %s payload: %w", eventType, err)
+	}
+	return Envelope{
+		EventID:     uuid.NewString(),
+		Type:        eventType,
+		Version:     version,
+		OccurredAt:  time.Now().UTC(),
+		AggregateID: aggregateID,
+		Payload:     raw,
+		Key:         key,
+	}, nil
+}
+
+// NewTransactionCreated builds the event published when a transaction is created.
+func NewTransactionCreated(tx models.Transaction) (Envelope, error) {
+	return New(TransactionCreated, 1, tx.ID, UserKey(tx.UserID), tx)
+}
+
+// NewTransactionStatusChanged builds the event published when a transaction changes status.
+func NewTransactionStatusChanged(tx models.Transaction, from, reason string) (Envelope, error) {
+	return New(TransactionStatusChanged, 1, tx.ID, UserKey(tx.UserID), StatusChanged{
+		TransactionID: tx.ID,
+		UserID:        tx.UserID,
+		FromStatus:    from,
+		ToStatus:      tx.Status,
+		Reason:        reason,
+		Transaction:   tx,
+	})
+}
+
+// UserKey returns the Kafka key of events about a user, which keeps each
+// user's events ordered on a single partition.
+func UserKey(userID uint) string {
+	return strconv.FormatUint(uint64(userID), 10)
+}
+
+// Decode parses an encoded envelope. The Kafka key is not restored.
+func Decode(data []byte) (Envelope, error) {
+	var env Envelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		return Envelope{}, fmt.Errorf("failed to decode event envelope: %w", err)
+	}
+	return env, nil
+}
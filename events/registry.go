@@ -0,0 +1,193 @@
+// events/registry.go
+package events
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+// envelopeType names the schema of the envelope itself.
+const envelopeType = "envelope"
+
+// schemaFS holds the JSON Schemas of all events, named <type>.v<version>.json.
+//
+//go:embed schemas/*.json
+var schemaFS embed.FS
+
+// schemaKey identifies one version of an event schema.
+type schemaKey struct {
+	Type    string
+	Version int
+}
+
+// Registry validates events against the JSON Schemas kept in this package.
+type Registry struct {
+	compiled map[schemaKey]*jsonschema.Schema
+	raw      map[schemaKey]schemaDocument
+}
+
+// schemaDocument is the part of a JSON Schema the compatibility checks look at.
+type schemaDocument struct {
+	Properties map[string]struct {
+		Type interface{} `json:"type"`
+	} `json:"properties"`
+	Required []string `json:"required"`
+}
+
+// LoadRegistry compiles the embedded schemas.
+func LoadRegistry() (*Registry, error) {
+	r := &Registry{
+		compiled: make(map[schemaKey]*jsonschema.Schema),
+		raw:      make(map[schemaKey]schemaDocument),
+	}
+
+	files, err := fs.Glob(schemaFS, "schemas/*.json")
+	if err != nil {
+		return nil, err
+	}
+	compiler := jsonschema.NewCompiler()
+	keys := make(map[string]schemaKey, len(files))
+	for _, file := range files {
+		key, err := parseSchemaName(path.Base(file))
+		if err != nil {
+			return nil, err
+		}
+		data, err := schemaFS.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		var doc schemaDocument
+		if err := json.Unmarshal(data, &doc); err != nil {
+			return nil, fmt.Errorf("invalid schema %s: %w", file, err)
+		}
+		if err := compiler.AddResource(path.Base(file), bytes.NewReader(data)); err != nil {
+			return nil, fmt.Errorf("invalid schema %s: %w", file, err)
+		}
+		r.raw[key] = doc
+		keys[path.Base(file)] = key
+	}
+	for name, key := range keys {
+		schema, err := compiler.Compile(name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to compile schema %s: %w", name, err)
+		}
+		r.compiled[key] = schema
+	}
+
+	if _, ok := r.compiled[schemaKey{envelopeType, 1}]; !ok {
+		return nil, fmt.Errorf("missing envelope schema")
+	}
+	return r, nil
+}
+
+// Validate checks an envelope and its payload against their schemas.
+func (r *Registry) Validate(env Envelope) error {
+	data, err := json.Marshal(env)
+	if err != nil {
+		return err
+	}
+	var doc interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return err
+	}
+	if err := r.compiled[schemaKey{envelopeType, 1}].Validate(doc); err != nil {
+		return fmt.Errorf("invalid event envelope: %w", err)
+	}
+
+	schema, ok := r.compiled[schemaKey{env.Type, env.Version}]
+	if !ok {
+		return fmt.Errorf("no schema registered for %s v%d", env.Type, env.Version)
+	}
+	var payload interface{}
+	if err := json.Unmarshal(env.Payload, &payload); err != nil {
+		return err
+	}
+	if err := schema.Validate(payload); err != nil {
+		return fmt.Errorf("invalid %s v%d payload: %w", env.Type, env.Version, err)
+	}
+	return nil
+}
+
+// CheckCompatibility verifies that every event type has contiguous versions
+// starting at 1 and that each version can be read by consumers of the previous
+// one and vice versa: properties may be added, but never removed or retyped,
+// and the set of required properties never changes.
+func (r *Registry) CheckCompatibility() error {
+	versions := make(map[string][]int)
+	for key := range r.raw {
+		versions[key.Type] = append(versions[key.Type], key.Version)
+	}
+
+	for eventType, vs := range versions {
+		sort.Ints(vs)
+		for i, v := range vs {
+			if v != i+1 {
+				return fmt.Errorf("%s: versions must be contiguous from 1, got %v", eventType, vs)
+			}
+			if i == 0 {
+				continue
+			}
+			if err := compatible(r.raw[schemaKey{eventType, v - 1}], r.raw[schemaKey{eventType, v}]); err != nil {
+				return fmt.Errorf("%s v%d is incompatible with v%d: %w", eventType, v, v-1, err)
+			}
+		}
+	}
+
+	for _, eventType := range []string{TransactionCreated, TransactionStatusChanged} {
+		if len(versions[eventType]) == 0 {
+			return fmt.Errorf("no schema registered for %s", eventType)
+		}
+	}
+	return nil
+}
+
+// compatible compares two consecutive versions of a schema.
+func compatible(prev, next schemaDocument) error {
+	for name, prop := range prev.Properties {
+		nextProp, ok := next.Properties[name]
+		if !ok {
+			return fmt.Errorf("property %q was removed", name)
+		}
+		if fmt.Sprint(prop.Type) != fmt.Sprint(nextProp.Type) {
+			return fmt.Errorf("property %q changed type from %v to %v", name, prop.Type, nextProp.Type)
+		}
+	}
+
+	required := make(map[string]bool, len(prev.Required))
+	for _, name := range prev.Required {
+		required[name] = true
+	}
+	for _, name := range next.Required {
+		if !required[name] {
+			return fmt.Errorf("property %q became required", name)
+		}
+		delete(required, name)
+	}
+	for name := range required {
+		return fmt.Errorf("property %q is no longer required", name)
+	}
+	return nil
+}
+
+// parseSchemaName splits a file name like transaction.created.v1.json.
+func parseSchemaName(name string) (schemaKey, error) {
+	base := strings.TrimSuffix(name, ".json")
+	i := strings.LastIndex(base, ".v")
+	if i <= 0 {
+		return schemaKey{}, fmt.Errorf("schema file %s is not named <type>.v<version>.json", name)
+	}
+	version, err := strconv.Atoi(base[i+2:])
+	if err != nil || version < 1 {
+		return schemaKey{}, fmt.Errorf("schema file %s has an invalid version", name)
+	}
+	return schemaKey{Type: base[:i], Version: version}, nil
+}
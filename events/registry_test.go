@@ -0,0 +1,109 @@
+// events/registry_test.go
+package events
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"crypto-exchange/models"
+)
+
+// goldenPayloads decodes the payload of each event type into the type its
+// consumers read it into.
+var goldenPayloads = map[string]func() interface{}{
+	TransactionCreated:       func() interface{} { return &models.Transaction{} },
+	TransactionStatusChanged: func() interface{} { return &StatusChanged{} },
+	DepositConfirmed:         func() interface{} { return &DepositConfirmation{} },
+}
+
+func TestCheckCompatibility(t *testing.T) {
+	r, err := LoadRegistry()
+	if err != nil {
+		t.Fatalf("LoadRegistry: %v", err)
+	}
+	if err := r.CheckCompatibility(); err != nil {
+		t.Fatalf("CheckCompatibility: %v", err)
+	}
+}
+
+// TestGoldenEvents decodes and validates a golden event, kept in
+// testdata/<type>.v<version>.json, for every registered schema version.
+func TestGoldenEvents(t *testing.T) {
+	r, err := LoadRegistry()
+	if err != nil {
+		t.Fatalf("LoadRegistry: %v", err)
+	}
+	for key := range r.raw {
+		if key.Type == envelopeType {
+			continue
+		}
+		name := fmt.Sprintf("%s.v%d", key.Type, key.Version)
+		t.Run(name, func(t *testing.T) {
+			data, err := os.ReadFile(filepath.Join("testdata", name+".json"))
+			if err != nil {
+				t.Fatalf("missing golden event: %v", err)
+			}
+			env, err := Decode(data)
+			if err != nil {
+				t.Fatalf("Decode: %v", err)
+			}
+			if env.Type != key.Type || env.Version != key.Version {
+				t.Fatalf("golden event is %s v%d", env.Type, env.Version)
+			}
+			if err := r.Validate(env); err != nil {
+				t.Fatalf("Validate: %v", err)
+			}
+
+			newPayload, ok := goldenPayloads[key.Type]
+			if !ok {
+				t.Fatalf("no payload type for %s", key.Type)
+			}
+			decoder := json.NewDecoder(bytes.NewReader(env.Payload))
+			decoder.DisallowUnknownFields()
+			if err := decoder.Decode(newPayload()); err != nil {
+				t.Fatalf("decode payload: %v", err)
+			}
+		})
+	}
+}
+
+func TestCompatibleRejectsBreakingChanges(t *testing.T) {
+	prev := schemaDocument{Required: []string{"id"}}
+	prev.Properties = map[string]struct {
+		Type interface{} `json:"type"`
+	}{
+		"id":     {Type: "string"},
+		"amount": {Type: "string"},
+	}
+
+	tests := []struct {
+		name string
+		next string
+		ok   bool
+	}{
+		{"property added", `{"properties": {"id": {"type": "string"}, "amount": {"type": "string"}, "memo": {"type": "string"}}, "required": ["id"]}`, true},
+		{"property removed", `{"properties": {"id": {"type": "string"}}, "required": ["id"]}`, false},
+		{"property retyped", `{"properties": {"id": {"type": "string"}, "amount": {"type": "number"}}, "required": ["id"]}`, false},
+		{"property became required", `{"properties": {"id": {"type": "string"}, "amount": {"type": "string"}}, "required": ["id", "amount"]}`, false},
+		{"property no longer required", `{"properties": {"id": {"type": "string"}, "amount": {"type": "string"}}, "required": []}`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var next schemaDocument
+			if err := json.Unmarshal([]byte(tt.next), &next); err != nil {
+				t.Fatal(err)
+			}
+			err := compatible(prev, next)
+			if tt.ok && err != nil {
+				t.Fatalf("compatible: %v", err)
+			}
+			if !tt.ok && err == nil {
+				t.Fatal("compatible accepted a breaking change")
+			}
+		})
+	}
+}
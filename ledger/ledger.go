@@ -0,0 +1,176 @@
+// ledger/ledger.go
+package ledger
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"crypto-exchange/models"
+
+	"github.com/shopspring/decimal"
+)
+
+var (
+	// ErrUnbalancedEntry is returned when the postings of an entry do not sum to zero per asset.
+	ErrUnbalancedEntry = errors.New("journal entry is not balanced")
+	// ErrNotSettled is returned when a transaction has not moved any funds yet.
+	ErrNotSettled = errors.New("transaction is not settled")
+)
+
+// UserAccountID returns the ID of a user's account for the given asset.
+func UserAccountID(userID uint, asset string) string {
+	return fmt.Sprintf("%s:%d:%s", models.AccountKindUser, userID, asset)
+}
+
+// ExternalAccountID returns the ID of the external clearing account for the given asset.
+func ExternalAccountID(asset string) string {
+	return fmt.Sprintf("%s:%s", models.AccountKindExternal, asset)
+}
+
+// FeesAccountID returns the ID of the fee revenue account for the given asset.
+func FeesAccountID(asset string) string {
+	return fmt.Sprintf("%s:%s", models.AccountKindFees, asset)
+}
+
+// ParseAccountID turns an account ID back into the account it names.
+func ParseAccountID(id string) (models.LedgerAccount, error) {
+	parts := strings.Split(id, ":")
+	switch {
+	case len(parts) == 3 && parts[0] == models.AccountKindUser:
+		userID, err := strconv.ParseUint(parts[1], 10, 64)
+		if err != nil {
+			return models.LedgerAccount{}, fmt.Errorf("invalid user in account %q: %w", id, err)
+		}
+		return models.LedgerAccount{ID: id, Kind: parts[0], UserID: uint(userID), Asset: parts[2]}, nil
+	case len(parts) == 2 && (parts[0] == models.AccountKindExternal || parts[0] == models.AccountKindFees):
+		return models.LedgerAccount{ID: id, Kind: parts[0], Asset: parts[1]}, nil
+	default:
+		return models.LedgerAccount{}, fmt.Errorf("invalid account ID %q", id)
+	}
+}
+
+// Settles reports whether the transaction has moved funds and must be journaled.
+func Settles(tx models.Transaction) bool {
+	return tx.Status == models.StatusCompleted
+}
+
+// EntryForTransaction builds the journal entry that settles a deposit or withdrawal.
+//
+// The transaction moves CryptoAmount of CryptoSymbol between the user's account and
+// the external account; TransactionFee is charged to the user on top of it.
+func EntryForTransaction(tx models.Transaction) (models.JournalEntry, error) {
+	if !Settles(tx) {
+		return models.JournalEntry{}, ErrNotSettled
+	}
+
+	asset := tx.CryptoSymbol
+	user := UserAccountID(tx.UserID, asset)
+	external := ExternalAccountID(asset)
+
+	var from, to string
+	switch tx.Type {
+	case "deposit":
+		from, to = external, user
+	case "withdrawal":
+		from, to = user, external
+	default:
+		return models.JournalEntry{}, fmt.Errorf("unsupported transaction type %q", tx.Type)
+	}
+
+	entryID := tx.ID + ":settle"
+	postings := []models.Posting{
+		{EntryID: entryID, AccountID: from, Asset: asset, Amount: tx.CryptoAmount.Neg()},
+		{EntryID: entryID, AccountID: to, Asset: asset, Amount: tx.CryptoAmount},
+	}
+	if tx.TransactionFee.IsPositive() {
+		postings = append(postings,
+			models.Posting{EntryID: entryID, AccountID: user, Asset: asset, Amount: tx.TransactionFee.Neg()},
+			models.Posting{EntryID: entryID, AccountID: FeesAccountID(asset), Asset: asset, Amount: tx.TransactionFee},
+		)
+	}
+
+	entry := models.JournalEntry{
+		ID:            entryID,
+		TransactionID: tx.ID,
+		Description:   fmt.Sprintf("%s of %s settled", tx.Type, asset),
+		Postings:      postings,
+	}
+	if err := Validate(entry); err != nil {
+		return models.JournalEntry{}, err
+	}
+	return entry, nil
+}
+
+// ReversalEntry builds the journal entry that undoes the settlement of a transaction.
+func ReversalEntry(tx models.Transaction) (models.JournalEntry, error) {
+	settled := tx
+	settled.Status = models.StatusCompleted
+	entry, err := EntryForTransaction(settled)
+	if err != nil {
+		return models.JournalEntry{}, err
+	}
+
+	entry.ID = tx.ID + ":reverse"
+	entry.Description = fmt.Sprintf("%s of %s reversed", tx.Type, tx.CryptoSymbol)
+	for i := range entry.Postings {
+		entry.Postings[i].EntryID = entry.ID
+		entry.Postings[i].Amount = entry.Postings[i].Amount.Neg()
+	}
+	return entry, nil
+}
+
+// EntryForTrade builds the journal entry that settles a trade: the seller
+// delivers Quantity of the base asset to the buyer, who pays QuoteQuantity of
+// the quote asset in return.
+func EntryForTrade(trade models.Trade) (models.JournalEntry, error) {
+	base, quote, ok := models.SplitPair(trade.Pair)
+	if !ok {
+		return models.JournalEntry{}, fmt.Errorf("invalid trading pair %q", trade.Pair)
+	}
+
+	entryID := trade.ID + ":settle"
+	postings := []models.Posting{
+		{EntryID: entryID, AccountID: UserAccountID(trade.SellerID, base), Asset: base, Amount: trade.Quantity.Neg()},
+		{EntryID: entryID, AccountID: UserAccountID(trade.BuyerID, base), Asset: base, Amount: trade.Quantity},
+		{EntryID: entryID, AccountID: UserAccountID(trade.BuyerID, quote), Asset: quote, Amount: trade.QuoteQuantity.Neg()},
+		{EntryID: entryID, AccountID: UserAccountID(trade.SellerID, quote), Asset: quote, Amount: trade.QuoteQuantity},
+	}
+
+	entry := models.JournalEntry{
+		ID:          entryID,
+		TradeID:     trade.ID,
+		Description: fmt.Sprintf("trade on %s settled", trade.Pair),
+		Postings:    postings,
+	}
+	if err := Validate(entry); err != nil {
+		return models.JournalEntry{}, err
+	}
+	return entry, nil
+}
+
+// Validate checks that an entry has postings and that they sum to zero per asset.
+func Validate(entry models.JournalEntry) error {
+	if len(entry.Postings) < 2 {
+		return fmt.Errorf("%w: entry %s needs at least two postings", ErrUnbalancedEntry, entry.ID)
+	}
+
+	sums := make(map[string]decimal.Decimal)
+	for _, p := range entry.Postings {
+		if p.AccountID == "" || p.Asset == "" {
+			return fmt.Errorf("entry %s has a posting without account or asset", entry.ID)
+		}
+		if p.Amount.IsZero() {
+			return fmt.Errorf("entry %s has a zero posting to %s", entry.ID, p.AccountID)
+		}
+		sums[p.Asset] = sums[p.Asset].Add(p.Amount)
+	}
+
+	for asset, sum := range sums {
+		if !sum.IsZero() {
+			return fmt.Errorf("%w: %s postings sum to %s", ErrUnbalancedEntry, asset, sum)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,194 @@
+// lifecycle/lifecycle.go
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"crypto-exchange/config"
+
+	"github.com/rs/zerolog"
+)
+
+// Component is a part of the application that is started and stopped with it,
+// such as the HTTP server, a background worker or a store.
+type Component struct {
+	Name string
+	// Run runs the component until its context is cancelled. It is nil for
+	// components that only need stopping.
+	Run func(ctx context.Context) error
+	// Stop releases the component once Run has returned. It is given a context
+	// that expires with the component's timeout.
+	Stop func(ctx context.Context) error
+	// Timeout bounds stopping the component; zero means the manager's default.
+	Timeout time.Duration
+}
+
+// Manager starts components in the order they were added and stops them in
+// reverse order on SIGINT or SIGTERM, so that each component is stopped
+// before the ones it depends on.
+type Manager struct {
+	Logger      zerolog.Logger
+	StopTimeout time.Duration
+	components  []Component
+}
+
+// running is a started component.
+type running struct {
+	Component
+	cancel context.CancelFunc
+	done   chan struct{} // closed when Run has returned
+}
+
+// New creates a new instance of Manager.
+func New(logger zerolog.Logger, cfg config.ShutdownConfig) *Manager {
+	return &Manager{
+		Logger:      logger,
+		StopTimeout: cfg.StopTimeout,
+	}
+}
+
+// Add registers a component. Components must be added after those they depend on.
+func (m *Manager) Add(c Component) {
+	m.components = append(m.components, c)
+}
+
+// Run starts the components and blocks until a shutdown signal is received,
+// the context is cancelled or a component fails, then stops them. It returns
+// the error of the failed component, if any.
+func (m *Manager) Run(ctx context.Context) error {
+	ctx, stopSignals := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stopSignals()
+
+	failed := make(chan error, len(m.components))
+	started := make([]*running, 0, len(m.components))
+	for _, c := range m.components {
+		started = append(started, m.start(c, failed))
+	}
+
+	var err error
+	select {
+	case <-ctx.Done():
+		m.Logger.Info().Msg("Shutdown signal received, stopping")
+	case err = <-failed:
+		m.Logger.Error().Err(err).Msg("Component failed, stopping")
+	}
+	// A second signal kills the process right away
+	stopSignals()
+
+	for i := len(started) - 1; i >= 0; i-- {
+		m.stop(started[i])
+	}
+	m.Logger.Info().Msg("Shutdown complete")
+	return err
+}
+
+// start runs a component in the background. An error it returns before being
+// stopped is reported on failed.
+func (m *Manager) start(c Component, failed chan<- error) *running {
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &running{Component: c, cancel: cancel, done: make(chan struct{})}
+	if c.Run == nil {
+		close(r.done)
+		return r
+	}
+
+	m.Logger.Debug().Str("component", c.Name).Msg("Starting component")
+	go func() {
+		defer close(r.done)
+		if err := c.Run(ctx); err != nil && ctx.Err() == nil {
+			failed <- fmt.Errorf("%s: %w", c.Name, err)
+		}
+	}()
+	return r
+}
+
+// stop cancels a component, waits for Run to return and calls Stop, giving
+// up once the component's timeout has passed.
+func (m *Manager) stop(r *running) {
+	timeout := r.Timeout
+	if timeout <= 0 {
+		timeout = m.StopTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	start := time.Now()
+	r.cancel()
+	select {
+	case <-r.done:
+	case <-ctx.Done():
+		m.Logger.Warn().
+			Str("component", r.Name).
+			Dur("timeout", timeout).
+			Msg("Component did not stop in time, abandoning it")
+		return
+	}
+	if r.Stop != nil {
+		if err := r.Stop(ctx); err != nil {
+			m.Logger.Error().
+				Err(err).
+				Str("component", r.Name).
+				Msg("Failed to stop component")
+			return
+		}
+	}
+	m.Logger.Info().
+		Str("component", r.Name).
+		Dur("duration", time.Since(start)).
+		Msg("Component stopped")
+}
+
+// Worker returns a component that runs a background loop until it is
+// cancelled, then calls close if it is not nil.
+func Worker(name string, run func(ctx context.Context), close func() error) Component {
+	c := Component{
+		Name: name,
+		Run: func(ctx context.Context) error {
+			run(ctx)
+			return nil
+		},
+	}
+	if close != nil {
+		c.Stop = func(context.Context) error { return close() }
+	}
+	return c
+}
+
+// Closer returns a component that only needs closing, such as a store client.
+func Closer(name string, close func() error) Component {
+	return Component{
+		Name: name,
+		Stop: func(context.Context) error { return close() },
+	}
+}
+
+// HTTPServer returns a component that serves HTTP until it is stopped, then
+// stops accepting connections and waits up to drainTimeout for in-flight
+// requests. Hijacked connections such as WebSockets are not waited for.
+func HTTPServer(name string, srv *http.Server, drainTimeout time.Duration) Component {
+	return Component{
+		Name: name,
+		Run: func(ctx context.Context) error {
+			errs := make(chan error, 1)
+			go func() { errs <- srv.ListenAndServe() }()
+			select {
+			case err := <-errs:
+				if errors.Is(err, http.ErrServerClosed) {
+					return nil
+				}
+				return err
+			case <-ctx.Done():
+				return nil
+			}
+		},
+		Stop:    srv.Shutdown,
+		Timeout: drainTimeout,
+	}
+}
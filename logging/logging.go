@@ -0,0 +1,51 @@
+// logging/logging.go
+package logging
+
+import (
+	"context"
+
+	"github.com/rs/zerolog"
+)
+
+// Context keys of the values set per request.
+type (
+	loggerKey    struct{}
+	requestIDKey struct{}
+)
+
+// WithLogger returns ctx carrying a logger, such as the per-request logger
+// holding the request ID, user ID and route.
+func WithLogger(ctx context.Context, logger zerolog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// UpdateLogger returns ctx with fields added to the logger it carries. ctx is
+// returned as is if it carries no logger.
+func UpdateLogger(ctx context.Context, update func(c zerolog.Context) zerolog.Context) context.Context {
+	logger, ok := ctx.Value(loggerKey{}).(zerolog.Logger)
+	if !ok {
+		return ctx
+	}
+	return WithLogger(ctx, update(logger.With()).Logger())
+}
+
+// FromContext returns the logger carried by ctx, or fallback if there is none.
+// The logger is bound to ctx, so that hooks see the span of the caller.
+func FromContext(ctx context.Context, fallback zerolog.Logger) zerolog.Logger {
+	logger, ok := ctx.Value(loggerKey{}).(zerolog.Logger)
+	if !ok {
+		logger = fallback
+	}
+	return logger.With().Ctx(ctx).Logger()
+}
+
+// WithRequestID returns ctx carrying the ID of the request it handles.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the request ID carried by ctx, or "" if there is none.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
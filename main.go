@@ -2,15 +2,22 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net/http"
 
 	"crypto-exchange/config"
 	"crypto-exchange/controllers"
+	"crypto-exchange/events"
+	"crypto-exchange/lifecycle"
+	"crypto-exchange/matching"
+	"crypto-exchange/metrics"
 	"crypto-exchange/middleware"
+	"crypto-exchange/money"
 	"crypto-exchange/routes"
 	"crypto-exchange/services"
+	"crypto-exchange/tracing"
 
 	"github.com/gin-gonic/gin"
 	"github.com/rs/zerolog"
@@ -23,55 +30,217 @@ func main() {
 		log.Fatalf("Failed to load config: %v", err)
 	}
 
-	// Initialize logger
-	logger := cfg.SetupLogger()
+	// Initialize logger; lines logged with a traced context carry its trace ID
+	logger := cfg.SetupLogger().Hook(tracing.LogHook{})
+
+	// Components are stopped in the reverse order they are added on shutdown
+	app := lifecycle.New(logger, cfg.Shutdown)
+
+	// Export spans to the collector; the provider is stopped last, so that the
+	// spans of the other components are flushed
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.Tracing)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("Failed to initialize tracing")
+	}
+	app.Add(lifecycle.Component{Name: "tracer provider", Stop: shutdownTracing})
 
 	// Initialize database service
 	dbService, err := services.NewDatabaseService(cfg.Database)
 	if err != nil {
 		logger.Fatal().Err(err).Msg("Failed to initialize database service")
 	}
+	app.Add(lifecycle.Closer("database", dbService.Close))
 
-	// Initialize Redis service
+	// Initialize Redis service; without Redis the service runs degraded
 	redisService := services.NewRedisService(cfg.Redis)
-	logger.Info().Msg("Connected to Redis")
+	app.Add(lifecycle.Closer("redis", redisService.Close))
+	pingCtx, cancelPing := context.WithTimeout(context.Background(), cfg.Health.Timeout)
+	if err := redisService.Ping(pingCtx); err != nil {
+		logger.Warn().Err(err).Msg("Redis unavailable, starting degraded")
+	} else {
+		logger.Info().Msg("Connected to Redis")
+	}
+	cancelPing()
 
 	// Initialize Cassandra service
 	cassandraService, err := services.NewCassandraService(cfg.Cassandra)
 	if err != nil {
 		logger.Fatal().Err(err).Msg("Failed to initialize Cassandra service")
 	}
-	defer cassandraService.Close()
+	app.Add(lifecycle.Closer("cassandra", func() error {
+		cassandraService.Close()
+		return nil
+	}))
 	logger.Info().Msg("Connected to Cassandra")
 
 	// Initialize Kafka service
 	kafkaService := services.NewKafkaService(cfg.Kafka)
-	defer kafkaService.Close()
+	app.Add(lifecycle.Closer("kafka writer", kafkaService.Close))
 	logger.Info().Msg("Connected to Kafka")
 
+	// Check the dependencies for the readiness probe
+	healthService := services.NewHealthService(logger, cfg.Health)
+	healthService.Register("postgres", dbService.Ping)
+	healthService.Register("redis", redisService.Ping)
+	healthService.Register("cassandra", cassandraService.Ping)
+	healthService.Register("kafka", kafkaService.Ping)
+
+	// Load per-asset precision and rounding rules
+	assets, err := money.NewRegistry(cfg.Money)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("Invalid money configuration")
+	}
+
+	// Load the event schemas and make sure their versions stay compatible
+	eventRegistry, err := events.LoadRegistry()
+	if err != nil {
+		logger.Fatal().Err(err).Msg("Failed to load event schemas")
+	}
+	if err := eventRegistry.CheckCompatibility(); err != nil {
+		logger.Fatal().Err(err).Msg("Incompatible event schemas")
+	}
+
+	// Create an order book for every configured trading pair
+	engine, err := matching.NewEngine(cfg.Trading, assets)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("Invalid trading configuration")
+	}
+
 	// Choose between mock service or real database service based on environment
 	var txService services.TransactionService
+	var balanceService services.BalanceService
+	var orderService services.OrderService
+	var marketDataService services.MarketDataService
+	var idempotencyService services.IdempotencyService
+	var rateLimiter services.RateLimiter
+	var outboxService services.OutboxService
+	var streamHub *services.StreamHub
+	var tokenRevocationService services.TokenRevocationService
+	var userService services.UserService
+	var twoFactorService services.TwoFactorService
+	var apiKeyService services.APIKeyService
 	if cfg.Environment == "development" {
-		txService = services.NewMockTransactionService()
+		mockTxService := services.NewMockTransactionService(services.NewMockLedgerService(), assets)
+		txService = mockTxService
+		balanceService = services.NewMockBalanceService(mockTxService)
+		orderService = services.NewMockOrderService(mockTxService, engine)
+		marketDataService = services.NewMockMarketDataService(mockTxService, assets, cfg.MarketData)
+		idempotencyService = services.NewMockIdempotencyService(cfg.Idempotency)
+		rateLimiter = services.NewMemoryRateLimiter()
+		outboxService = services.NewMockOutboxService()
+		tokenRevocationService = services.NewMockTokenRevocationService()
+		mockUserService := services.NewMockUserService(mockTxService, services.LogEmailSender{Logger: logger}, cfg.Users)
+		userService = mockUserService
+		twoFactorService = services.NewMockTwoFactorService(mockTxService, mockUserService, cfg.TwoFactor)
+		apiKeyService = services.NewMockAPIKeyService(services.NewMockNonceStore(), cfg.UserAPIKeys)
+
+		// Stream the mock store's transaction events straight to the hub
+		streamHub = services.NewStreamHub(logger, services.NewMockStreamSequencer(), txService, marketDataService, cfg.Stream)
+		mockTxService.OnEvent(func(env events.Envelope) {
+			streamHub.HandleEvent(context.Background(), env)
+		})
+		app.Add(lifecycle.Closer("stream hub", streamHub.Close))
 		logger.Info().Msg("Using MockTransactionService")
 	} else {
 		// Initialize production transaction service with DB, Redis, Cassandra, Kafka
-		txService = services.NewTransactionService(dbService.DB, logger, redisService, cassandraService, kafkaService)
+		ledgerService := services.NewLedgerService(dbService.DB, logger)
+		dbBalanceService := services.NewBalanceService(dbService.DB, logger, ledgerService)
+		balanceService = dbBalanceService
+		dbTwoFactorService := services.NewTwoFactorService(dbService.DB, logger, cfg.TwoFactor)
+		twoFactorService = dbTwoFactorService
+		txService = services.NewTransactionService(dbService.DB, logger, redisService, cassandraService, ledgerService, dbBalanceService, assets, eventRegistry, dbTwoFactorService)
+		idempotencyService = services.NewIdempotencyService(dbService.DB, logger, redisService, cfg.Idempotency)
+		rateLimiter = services.NewRateLimiter(redisService, logger)
+		tokenRevocationService = services.NewTokenRevocationService(redisService)
+		userService = services.NewUserService(dbService.DB, logger, services.LogEmailSender{Logger: logger}, cfg.Users)
+		apiKeyService, err = services.NewAPIKeyService(dbService.DB, logger, services.NewNonceStore(redisService), cfg.UserAPIKeys)
+		if err != nil {
+			logger.Fatal().Err(err).Msg("Failed to initialize API key service")
+		}
+
+		// Match orders on the one node holding the matching lock; it puts the
+		// open orders back on the books before accepting new ones
+		dbOrderService := services.NewOrderService(dbService.DB, logger, ledgerService, dbBalanceService, engine)
+		orderService = dbOrderService
+		if cfg.Trading.Matching {
+			app.Add(lifecycle.Worker("matching engine", dbOrderService.Run, nil))
+		}
+
+		// Relay transaction events from the outbox to Kafka in the background
+		outboxRelay := services.NewOutboxRelay(dbService.DB, logger, kafkaService, cfg.Outbox)
+		outboxService = outboxRelay
+		app.Add(lifecycle.Worker("outbox relay", outboxRelay.Run, nil))
+
+		// Rebuild the market data snapshots served from Redis in the background
+		dbMarketDataService := services.NewMarketDataService(dbService.DB, logger, redisService, assets, cfg.MarketData)
+		marketDataService = dbMarketDataService
+		app.Add(lifecycle.Worker("market data", dbMarketDataService.Run, nil))
+
+		// Complete deposits confirmed by the chain watchers
+		kafkaConsumer := services.NewKafkaConsumer(cfg.Kafka, logger, eventRegistry)
+		kafkaConsumer.Handle(events.DepositConfirmed, services.NewDepositConfirmedHandler(txService, logger))
+		depositConsumer := lifecycle.Worker("deposit consumer", kafkaConsumer.Run, kafkaConsumer.Close)
+		depositConsumer.Timeout = cfg.Shutdown.DrainTimeout
+		app.Add(depositConsumer)
+
+		// Project the transaction events into the history read model in Cassandra
+		historyConsumer := services.NewHistoryConsumer(cfg.Kafka, logger, eventRegistry)
+		historyProjector := services.NewHistoryProjector(cassandraService, logger)
+		historyConsumer.Handle(events.TransactionCreated, historyProjector)
+		historyConsumer.Handle(events.TransactionStatusChanged, historyProjector)
+		historyWorker := lifecycle.Worker("history consumer", historyConsumer.Run, historyConsumer.Close)
+		historyWorker.Timeout = cfg.Shutdown.DrainTimeout
+		app.Add(historyWorker)
+
+		// Stream the transaction events on Kafka to this replica's WebSocket subscribers
+		streamHub = services.NewStreamHub(logger, services.NewRedisStreamSequencer(redisService), txService, marketDataService, cfg.Stream)
+		app.Add(lifecycle.Closer("stream hub", streamHub.Close))
+		streamConsumer := services.NewStreamConsumer(cfg.Kafka, logger, eventRegistry)
+		streamConsumer.Handle(events.TransactionCreated, streamHub.HandleEvent)
+		streamConsumer.Handle(events.TransactionStatusChanged, streamHub.HandleEvent)
+		app.Add(lifecycle.Worker("stream consumer", streamConsumer.Run, streamConsumer.Close))
 		logger.Info().Msg("Using TransactionService with PostgreSQL, Redis, Cassandra, Kafka")
 	}
 
+	// Issue and verify access and refresh tokens
+	tokenService := services.NewTokenService(cfg.JWT, tokenRevocationService, userService)
+
 	// Initialize controllers
+	authController := controllers.NewAuthController(tokenService, userService, logger)
+	userController := controllers.NewUserController(userService, logger)
+	twoFactorController := controllers.NewTwoFactorController(twoFactorService, logger)
+	apiKeyController := controllers.NewAPIKeyController(apiKeyService, logger)
+	adminController := controllers.NewAdminController(userService, logger)
 	txController := controllers.NewTransactionController(txService, logger)
+	balanceController := controllers.NewBalanceController(balanceService, logger)
+	orderController := controllers.NewOrderController(orderService, logger)
+	marketController := controllers.NewMarketController(marketDataService, logger)
+	outboxController := controllers.NewOutboxController(outboxService, logger)
+	streamController := controllers.NewStreamController(streamHub, logger, cfg.Stream)
+	healthController := controllers.NewHealthController(healthService, logger)
+
+	// Register validators for custom payload types
+	if err := controllers.RegisterValidators(); err != nil {
+		logger.Fatal().Err(err).Msg("Failed to register validators")
+	}
 
 	// Initialize Gin router
 	router := gin.New()
 
+	// Only trust X-Forwarded-For from our own proxies; API key IP allowlists rely on it
+	if err := router.SetTrustedProxies(cfg.Server.TrustedProxies); err != nil {
+		logger.Fatal().Err(err).Msg("Invalid trusted proxies")
+	}
+
 	// Apply middleware
 	router.Use(gin.Recovery())
+	router.Use(middleware.Tracing())
+	router.Use(middleware.RequestID(logger))
 	router.Use(middleware.Logger(logger))
+	router.Use(middleware.Errors())
 
-	// Setup routes
-	routes.SetupRoutes(router, txController, logger)
+	// Setup routes; authentication, rate limits and idempotency apply per route group
+	routes.SetupRoutes(router, tokenService, idempotencyService, rateLimiter, cfg.RateLimit, authController, userController, twoFactorController, apiKeyService, apiKeyController, adminController, txController, balanceController, orderController, marketController, outboxController, streamController, healthController, logger)
 
 	// Configure server settings
 	serverAddr := fmt.Sprintf("%s:%d", cfg.Server.Host, cfg.Server.Port)
@@ -84,16 +253,28 @@ func main() {
 		MaxHeaderBytes: 1 << 20, // 1 MB
 	}
 
+	// Serve the Prometheus metrics on their own port; it stops after the API,
+	// so that the drain can be watched
+	if cfg.Metrics.Enabled {
+		metricsMux := http.NewServeMux()
+		metricsMux.Handle("/metrics", metrics.Handler())
+		metricsSrv := &http.Server{
+			Addr:              fmt.Sprintf("%s:%d", cfg.Metrics.Host, cfg.Metrics.Port),
+			Handler:           metricsMux,
+			ReadHeaderTimeout: cfg.Server.ReadTimeout,
+		}
+		app.Add(lifecycle.HTTPServer("metrics server", metricsSrv, cfg.Shutdown.StopTimeout))
+	}
+	app.Add(lifecycle.HTTPServer("http server", srv, cfg.Shutdown.DrainTimeout))
+
 	logger.Info().
 		Str("address", serverAddr).
 		Msg("Starting server")
 
-	// Start server
-	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+	// Serve until SIGINT or SIGTERM, then drain requests and stop the components
+	if err := app.Run(context.Background()); err != nil {
 		logger.Fatal().
 			Err(err).
 			Msg("Server failed")
 	}
-
-	// Implement graceful shutdown if needed
 }
\ No newline at end of file
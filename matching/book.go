@@ -0,0 +1,232 @@
+// matching/book.go
+package matching
+
+import (
+	"sort"
+	"sync"
+
+	"crypto-exchange/models"
+
+	"github.com/shopspring/decimal"
+)
+
+// Fill is the part of a taker order matched against one maker order.
+type Fill struct {
+	Maker    models.Order // the maker order after the fill
+	Price    decimal.Decimal
+	Quantity decimal.Decimal
+}
+
+// Match is the outcome of matching an order against the book.
+type Match struct {
+	Taker models.Order // the taker order with its filled quantity and final status
+	Fills []Fill
+	// SelfTrade is set when the taker reached a resting order of its own user;
+	// the taker's unfilled part is then cancelled rather than traded or rested.
+	SelfTrade bool
+	// Cancelled holds the resting orders the taker passed whose remainder is
+	// worth less than one unit of the quote asset, and so can never trade.
+	Cancelled []models.Order
+}
+
+// level holds the orders resting at one price, oldest first.
+type level struct {
+	price  decimal.Decimal
+	orders []*models.Order
+}
+
+// Book is the price-time priority order book of a single trading pair.
+// Bids are kept best (highest) price first and asks best (lowest) price first.
+type Book struct {
+	Pair   string
+	market Market
+	bids   []*level
+	asks   []*level
+	orders map[string]*models.Order
+	mutex  sync.Mutex
+}
+
+// NewBook creates an empty order book for a market.
+func NewBook(market Market) *Book {
+	return &Book{
+		Pair:   market.Symbol,
+		market: market,
+		orders: make(map[string]*models.Order),
+	}
+}
+
+// match computes how an order would execute against the book without changing it.
+func (b *Book) match(taker models.Order) (Match, error) {
+	remaining := taker.Remaining()
+	var fills []Fill
+	var cancelled []models.Order
+	selfTrade, dust := false, false
+levels:
+	for _, lvl := range *b.levels(opposite(taker.Side)) {
+		if !remaining.IsPositive() || !crosses(taker, lvl.price) {
+			break
+		}
+		if taker.PostOnly {
+			return Match{}, ErrWouldTakeLiquidity
+		}
+		for _, maker := range lvl.orders {
+			if !remaining.IsPositive() {
+				break levels
+			}
+			// A user never trades with themselves: the taker stops here
+			if maker.UserID == taker.UserID {
+				selfTrade = true
+				break levels
+			}
+			// Dust makers would block every taker; they are cancelled instead
+			if b.isDust(lvl.price, maker.Remaining()) {
+				dropped := *maker
+				dropped.Status = models.OrderStatusCancelled
+				cancelled = append(cancelled, dropped)
+				continue
+			}
+			qty := decimal.Min(remaining, maker.Remaining())
+			if b.isDust(lvl.price, qty) {
+				// The taker's remainder is too small to trade
+				dust = true
+				break levels
+			}
+			filled := *maker
+			filled.FilledQuantity = filled.FilledQuantity.Add(qty)
+			filled.Status = restingStatus(filled)
+			fills = append(fills, Fill{Maker: filled, Price: lvl.price, Quantity: qty})
+			remaining = remaining.Sub(qty)
+		}
+	}
+
+	if taker.TimeInForce == models.TimeInForceFOK && remaining.IsPositive() {
+		return Match{}, ErrCannotFill
+	}
+
+	taker.FilledQuantity = taker.Quantity.Sub(remaining)
+	switch {
+	case !remaining.IsPositive():
+		taker.Status = models.OrderStatusFilled
+	case selfTrade, dust:
+		// Resting the rest would cross the book
+		taker.Status = models.OrderStatusCancelled
+	case taker.Type == models.OrderTypeLimit && taker.TimeInForce == models.TimeInForceGTC:
+		taker.Status = restingStatus(taker)
+	default:
+		// Market and IOC orders never rest; the unfilled part is cancelled
+		taker.Status = models.OrderStatusCancelled
+	}
+	return Match{Taker: taker, Fills: fills, SelfTrade: selfTrade, Cancelled: cancelled}, nil
+}
+
+// apply executes a match computed by match on the unchanged book.
+func (b *Book) apply(m Match) {
+	for _, f := range m.Fills {
+		maker := b.orders[f.Maker.ID]
+		*maker = f.Maker
+		if !maker.IsOpen() {
+			b.remove(maker)
+		}
+	}
+	for _, c := range m.Cancelled {
+		if maker, ok := b.orders[c.ID]; ok {
+			b.remove(maker)
+		}
+	}
+	if m.Taker.IsOpen() {
+		b.add(m.Taker)
+	}
+}
+
+// add rests an order at the back of its price level.
+func (b *Book) add(order models.Order) {
+	levels := b.levels(order.Side)
+	i, found := b.search(order.Side, order.Price)
+	if !found {
+		*levels = append(*levels, nil)
+		copy((*levels)[i+1:], (*levels)[i:])
+		(*levels)[i] = &level{price: order.Price}
+	}
+	lvl := (*levels)[i]
+	lvl.orders = append(lvl.orders, &order)
+	b.orders[order.ID] = &order
+}
+
+// remove takes a resting order off the book.
+func (b *Book) remove(order *models.Order) {
+	levels := b.levels(order.Side)
+	i, found := b.search(order.Side, order.Price)
+	if !found {
+		return
+	}
+	lvl := (*levels)[i]
+	for j, o := range lvl.orders {
+		if o.ID == order.ID {
+			lvl.orders = append(lvl.orders[:j], lvl.orders[j+1:]...)
+			break
+		}
+	}
+	if len(lvl.orders) == 0 {
+		*levels = append((*levels)[:i], (*levels)[i+1:]...)
+	}
+	delete(b.orders, order.ID)
+}
+
+// search returns the index of the level at price on one side of the book, or
+// the index at which it would be inserted.
+func (b *Book) search(side string, price decimal.Decimal) (int, bool) {
+	levels := *b.levels(side)
+	i := sort.Search(len(levels), func(i int) bool {
+		if side == models.SideBuy {
+			return levels[i].price.LessThanOrEqual(price)
+		}
+		return levels[i].price.GreaterThanOrEqual(price)
+	})
+	return i, i < len(levels) && levels[i].price.Equal(price)
+}
+
+// levels returns the price levels of one side of the book.
+func (b *Book) levels(side string) *[]*level {
+	if side == models.SideBuy {
+		return &b.bids
+	}
+	return &b.asks
+}
+
+// opposite returns the side an order of the given side trades against.
+func opposite(side string) string {
+	if side == models.SideBuy {
+		return models.SideSell
+	}
+	return models.SideBuy
+}
+
+// crosses reports whether an order can trade at the given price.
+func crosses(taker models.Order, price decimal.Decimal) bool {
+	switch {
+	case taker.Type == models.OrderTypeMarket:
+		return true
+	case taker.Side == models.SideBuy:
+		return price.LessThanOrEqual(taker.Price)
+	default:
+		return price.GreaterThanOrEqual(taker.Price)
+	}
+}
+
+// isDust reports whether a quantity at a price is worth less than one unit of
+// the quote asset, the smallest trade that can be settled.
+func (b *Book) isDust(price, quantity decimal.Decimal) bool {
+	return !price.Mul(quantity).RoundDown(b.market.Quote.Precision).IsPositive()
+}
+
+// restingStatus returns the status of an order on the book after fills.
+func restingStatus(o models.Order) string {
+	switch {
+	case !o.Remaining().IsPositive():
+		return models.OrderStatusFilled
+	case o.FilledQuantity.IsPositive():
+		return models.OrderStatusPartiallyFilled
+	default:
+		return models.OrderStatusOpen
+	}
+}
@@ -0,0 +1,230 @@
+// matching/book_test.go
+package matching
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"crypto-exchange/models"
+	"crypto-exchange/money"
+
+	"github.com/shopspring/decimal"
+)
+
+var testMarket = Market{
+	Symbol: "BTC-USDT",
+	Base:   money.Asset{Symbol: "BTC", Precision: 8},
+	Quote:  money.Asset{Symbol: "USDT", Precision: 2},
+}
+
+// order builds an order of user 1 unless the options say otherwise.
+func order(id, side, price, quantity string, opts ...func(*models.Order)) models.Order {
+	o := models.Order{
+		ID:          id,
+		UserID:      1,
+		Pair:        testMarket.Symbol,
+		Side:        side,
+		Type:        models.OrderTypeLimit,
+		TimeInForce: models.TimeInForceGTC,
+		Price:       decimal.RequireFromString(price),
+		Quantity:    decimal.RequireFromString(quantity),
+		Status:      models.OrderStatusOpen,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+func user(id uint) func(*models.Order) {
+	return func(o *models.Order) { o.UserID = id }
+}
+
+func tif(policy string) func(*models.Order) {
+	return func(o *models.Order) { o.TimeInForce = policy }
+}
+
+func market() func(*models.Order) {
+	return func(o *models.Order) { o.Type, o.Price = models.OrderTypeMarket, decimal.Zero }
+}
+
+func postOnly() func(*models.Order) {
+	return func(o *models.Order) { o.PostOnly = true }
+}
+
+func TestBookMatch(t *testing.T) {
+	tests := []struct {
+		name      string
+		resting   []models.Order
+		taker     models.Order
+		err       error
+		fills     []string // maker ID@price:quantity, in order
+		status    string
+		cancelled []string
+		selfTrade bool
+		book      []string // IDs left resting on the opposite side, best first
+	}{
+		{
+			name: "best price first",
+			resting: []models.Order{
+				order("a", models.SideSell, "101", "1", user(2)),
+				order("b", models.SideSell, "100", "1", user(2)),
+			},
+			taker:  order("t", models.SideBuy, "101", "1.5"),
+			fills:  []string{"b@100:1", "a@101:0.5"},
+			status: models.OrderStatusFilled,
+			book:   []string{"a"},
+		},
+		{
+			name: "oldest first within a price",
+			resting: []models.Order{
+				order("a", models.SideBuy, "100", "1", user(2)),
+				order("b", models.SideBuy, "100", "1", user(3)),
+			},
+			taker:  order("t", models.SideSell, "100", "1", tif(models.TimeInForceIOC)),
+			fills:  []string{"a@100:1"},
+			status: models.OrderStatusFilled,
+			book:   []string{"b"},
+		},
+		{
+			name:    "limit price stops the taker",
+			resting: []models.Order{order("a", models.SideSell, "100", "1", user(2)), order("b", models.SideSell, "102", "1", user(2))},
+			taker:   order("t", models.SideBuy, "101", "2"),
+			fills:   []string{"a@100:1"},
+			status:  models.OrderStatusPartiallyFilled,
+			book:    []string{"b"},
+		},
+		{
+			name:    "market order sweeps the book",
+			resting: []models.Order{order("a", models.SideSell, "100", "1", user(2)), order("b", models.SideSell, "200", "1", user(2))},
+			taker:   order("t", models.SideBuy, "0", "3", market()),
+			fills:   []string{"a@100:1", "b@200:1"},
+			status:  models.OrderStatusCancelled,
+		},
+		{
+			name:    "ioc cancels the rest",
+			resting: []models.Order{order("a", models.SideSell, "100", "1", user(2))},
+			taker:   order("t", models.SideBuy, "100", "2", tif(models.TimeInForceIOC)),
+			fills:   []string{"a@100:1"},
+			status:  models.OrderStatusCancelled,
+		},
+		{
+			name:    "fok fills completely",
+			resting: []models.Order{order("a", models.SideSell, "100", "1", user(2)), order("b", models.SideSell, "100", "1", user(3))},
+			taker:   order("t", models.SideBuy, "100", "2", tif(models.TimeInForceFOK)),
+			fills:   []string{"a@100:1", "b@100:1"},
+			status:  models.OrderStatusFilled,
+		},
+		{
+			name:    "fok that cannot fill",
+			resting: []models.Order{order("a", models.SideSell, "100", "1", user(2))},
+			taker:   order("t", models.SideBuy, "100", "2", tif(models.TimeInForceFOK)),
+			err:     ErrCannotFill,
+			book:    []string{"a"},
+		},
+		{
+			name:    "post-only that would take",
+			resting: []models.Order{order("a", models.SideSell, "100", "1", user(2))},
+			taker:   order("t", models.SideBuy, "100", "1", postOnly()),
+			err:     ErrWouldTakeLiquidity,
+			book:    []string{"a"},
+		},
+		{
+			name:    "post-only that rests",
+			resting: []models.Order{order("a", models.SideSell, "100", "1", user(2))},
+			taker:   order("t", models.SideBuy, "99", "1", postOnly()),
+			status:  models.OrderStatusOpen,
+			book:    []string{"a"},
+		},
+		{
+			name: "self-trade cancels the taker",
+			resting: []models.Order{
+				order("a", models.SideSell, "100", "1", user(2)),
+				order("b", models.SideSell, "100", "1"),
+				order("c", models.SideSell, "100", "1", user(2)),
+			},
+			taker:     order("t", models.SideBuy, "100", "3"),
+			fills:     []string{"a@100:1"},
+			status:    models.OrderStatusCancelled,
+			selfTrade: true,
+			book:      []string{"b", "c"},
+		},
+		{
+			name: "dust makers are cancelled",
+			resting: []models.Order{
+				order("a", models.SideSell, "10", "0.0001", user(2)),
+				order("b", models.SideSell, "10", "1", user(3)),
+			},
+			taker:     order("t", models.SideBuy, "10", "1"),
+			fills:     []string{"b@10:1"},
+			status:    models.OrderStatusFilled,
+			cancelled: []string{"a"},
+		},
+		{
+			name:    "dust taker remainder is cancelled",
+			resting: []models.Order{order("a", models.SideSell, "10", "1", user(2)), order("b", models.SideSell, "10", "1", user(3))},
+			taker:   order("t", models.SideBuy, "10", "1.0001"),
+			fills:   []string{"a@10:1"},
+			status:  models.OrderStatusCancelled,
+			book:    []string{"b"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			book := NewBook(testMarket)
+			for _, o := range tt.resting {
+				book.add(o)
+			}
+			m, err := book.match(tt.taker)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("match: got %v, want %v", err, tt.err)
+				}
+			} else {
+				if err != nil {
+					t.Fatalf("match: %v", err)
+				}
+				book.apply(m)
+				if m.Taker.Status != tt.status {
+					t.Errorf("taker status = %s, want %s", m.Taker.Status, tt.status)
+				}
+				if m.SelfTrade != tt.selfTrade {
+					t.Errorf("self trade = %v, want %v", m.SelfTrade, tt.selfTrade)
+				}
+				var fills []string
+				for _, f := range m.Fills {
+					fills = append(fills, fmt.Sprintf("%s@%s:%s", f.Maker.ID, f.Price, f.Quantity))
+				}
+				assertIDs(t, "fills", fills, tt.fills)
+				var cancelled []string
+				for _, o := range m.Cancelled {
+					if o.Status != models.OrderStatusCancelled {
+						t.Errorf("cancelled order %s has status %s", o.ID, o.Status)
+					}
+					cancelled = append(cancelled, o.ID)
+				}
+				assertIDs(t, "cancelled", cancelled, tt.cancelled)
+			}
+
+			var resting []string
+			for _, lvl := range *book.levels(opposite(tt.taker.Side)) {
+				for _, o := range lvl.orders {
+					resting = append(resting, o.ID)
+				}
+			}
+			assertIDs(t, "book", resting, tt.book)
+			wantResting := tt.err == nil && (tt.status == models.OrderStatusOpen || tt.status == models.OrderStatusPartiallyFilled)
+			if _, ok := book.orders[tt.taker.ID]; ok != wantResting {
+				t.Errorf("taker resting = %v, want %v", ok, wantResting)
+			}
+		})
+	}
+}
+
+func assertIDs(t *testing.T, what string, got, want []string) {
+	t.Helper()
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("%s = %v, want %v", what, got, want)
+	}
+}
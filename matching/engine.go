@@ -0,0 +1,152 @@
+// matching/engine.go
+package matching
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"crypto-exchange/apperrors"
+	"crypto-exchange/config"
+	"crypto-exchange/models"
+	"crypto-exchange/money"
+)
+
+var (
+	// ErrUnknownPair is returned for pairs that have no order book.
+	ErrUnknownPair = apperrors.New(apperrors.ErrValidation, "unknown_pair", "unknown trading pair")
+	// ErrWouldTakeLiquidity is returned when a post-only order would match on arrival.
+	ErrWouldTakeLiquidity = apperrors.New(apperrors.ErrUnprocessable, "would_take_liquidity", "post-only order would take liquidity")
+	// ErrCannotFill is returned when a fill-or-kill order cannot be filled completely.
+	ErrCannotFill = apperrors.New(apperrors.ErrUnprocessable, "cannot_fill", "fill-or-kill order cannot be filled")
+	// ErrOrderNotOnBook is returned when an order to cancel is not resting on the book.
+	ErrOrderNotOnBook = errors.New("order is not on the book")
+)
+
+// Market is a trading pair served by the engine.
+type Market struct {
+	Symbol string
+	Base   money.Asset
+	Quote  money.Asset
+}
+
+// Engine keeps an in-memory order book per trading pair. Orders of a pair are
+// matched one at a time; different pairs are matched concurrently.
+type Engine struct {
+	markets map[string]Market
+	books   map[string]*Book
+}
+
+// NewEngine creates an engine with an empty book for every configured pair.
+func NewEngine(cfg config.TradingConfig, assets *money.Registry) (*Engine, error) {
+	e := &Engine{
+		markets: make(map[string]Market, len(cfg.Pairs)),
+		books:   make(map[string]*Book, len(cfg.Pairs)),
+	}
+	for _, p := range cfg.Pairs {
+		base, err := assets.Asset(p.Base)
+		if err != nil {
+			return nil, err
+		}
+		quote, err := assets.Asset(p.Quote)
+		if err != nil {
+			return nil, err
+		}
+		symbol := models.PairSymbol(base.Symbol, quote.Symbol)
+		if _, exists := e.markets[symbol]; exists {
+			return nil, fmt.Errorf("trading pair %s configured twice", symbol)
+		}
+		e.markets[symbol] = Market{Symbol: symbol, Base: base, Quote: quote}
+		e.books[symbol] = NewBook(e.markets[symbol])
+	}
+	return e, nil
+}
+
+// Market returns a trading pair by symbol.
+func (e *Engine) Market(pair string) (Market, error) {
+	m, ok := e.markets[strings.ToUpper(pair)]
+	if !ok {
+		return Market{}, fmt.Errorf("%w: %s", ErrUnknownPair, pair)
+	}
+	return m, nil
+}
+
+// Submit matches an order against its book. The match is passed to settle
+// before the book changes; if settle fails, the book is left untouched and
+// its error is returned.
+func (e *Engine) Submit(order models.Order, settle func(Match) error) (Match, error) {
+	book, err := e.book(order.Pair)
+	if err != nil {
+		return Match{}, err
+	}
+	book.mutex.Lock()
+	defer book.mutex.Unlock()
+
+	m, err := book.match(order)
+	if err != nil {
+		return Match{}, err
+	}
+	if err := settle(m); err != nil {
+		return Match{}, err
+	}
+	book.apply(m)
+	return m, nil
+}
+
+// Cancel takes a resting order off its book. The cancelled order is passed to
+// persist first; if persist fails, the order stays on the book.
+func (e *Engine) Cancel(pair, id string, persist func(models.Order) error) (models.Order, error) {
+	book, err := e.book(pair)
+	if err != nil {
+		return models.Order{}, err
+	}
+	book.mutex.Lock()
+	defer book.mutex.Unlock()
+
+	order, ok := book.orders[id]
+	if !ok {
+		return models.Order{}, ErrOrderNotOnBook
+	}
+	cancelled := *order
+	cancelled.Status = models.OrderStatusCancelled
+	if err := persist(cancelled); err != nil {
+		return models.Order{}, err
+	}
+	book.remove(order)
+	return cancelled, nil
+}
+
+// Restore puts an open order back on its book, e.g. after a restart. Orders
+// must be restored oldest first to keep their time priority.
+func (e *Engine) Restore(order models.Order) error {
+	book, err := e.book(order.Pair)
+	if err != nil {
+		return err
+	}
+	if !order.IsOpen() {
+		return fmt.Errorf("order %s is %s and cannot rest on the book", order.ID, order.Status)
+	}
+	book.mutex.Lock()
+	defer book.mutex.Unlock()
+	book.add(order)
+	return nil
+}
+
+// Reset empties every book, e.g. when this node stops owning them.
+func (e *Engine) Reset() {
+	for _, book := range e.books {
+		book.mutex.Lock()
+		book.bids, book.asks = nil, nil
+		book.orders = make(map[string]*models.Order)
+		book.mutex.Unlock()
+	}
+}
+
+// book returns the order book of a pair.
+func (e *Engine) book(pair string) (*Book, error) {
+	book, ok := e.books[pair]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownPair, pair)
+	}
+	return book, nil
+}
@@ -0,0 +1,93 @@
+// metrics/metrics.go
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// namespace prefixes the names of all metrics of the exchange.
+const namespace = "crypto_exchange"
+
+// Results of a cache lookup.
+const (
+	CacheHit   = "hit"
+	CacheMiss  = "miss"
+	CacheError = "error"
+)
+
+var (
+	// HTTPRequests counts handled requests by method, route and status code.
+	HTTPRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests handled, by method, route and status code.",
+	}, []string{"method", "route", "status"})
+
+	// HTTPRequestDuration observes how long requests take by method and route.
+	HTTPRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Time taken to handle HTTP requests, by method and route.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route"})
+
+	// DependencyDuration observes calls to the stores and Kafka.
+	DependencyDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "dependency_request_duration_seconds",
+		Help:      "Time taken by calls to PostgreSQL, Redis, Cassandra and Kafka, by dependency and operation.",
+		Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+	}, []string{"dependency", "operation"})
+
+	// DependencyErrors counts failed calls to the stores and Kafka.
+	DependencyErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "dependency_errors_total",
+		Help:      "Failed calls to PostgreSQL, Redis, Cassandra and Kafka, by dependency and operation.",
+	}, []string{"dependency", "operation"})
+
+	// CacheRequests counts cache lookups by cache and result; the hit ratio is
+	// the rate of hits over the rate of all lookups.
+	CacheRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cache_requests_total",
+		Help:      "Cache lookups, by cache and result (hit, miss or error).",
+	}, []string{"cache", "result"})
+
+	// TransactionsCreated counts created transactions by type, symbol and initial status.
+	TransactionsCreated = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "transactions_created_total",
+		Help:      "Transactions created, by type, crypto symbol and initial status.",
+	}, []string{"type", "symbol", "status"})
+)
+
+// ObserveHTTPRequest records a handled request. Requests that matched no route
+// are recorded under the route "unmatched", so that unknown paths cannot
+// create new series.
+func ObserveHTTPRequest(method, route string, status int, d time.Duration) {
+	if route == "" {
+		route = "unmatched"
+	}
+	HTTPRequests.WithLabelValues(method, route, strconv.Itoa(status)).Inc()
+	HTTPRequestDuration.WithLabelValues(method, route).Observe(d.Seconds())
+}
+
+// ObserveDependency records a call to a dependency and whether it failed.
+func ObserveDependency(dependency, operation string, d time.Duration, err error) {
+	DependencyDuration.WithLabelValues(dependency, operation).Observe(d.Seconds())
+	if err != nil {
+		DependencyErrors.WithLabelValues(dependency, operation).Inc()
+	}
+}
+
+// Handler serves the metrics in the Prometheus text format.
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
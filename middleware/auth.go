@@ -0,0 +1,220 @@
+// middleware/auth.go
+package middleware
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"crypto-exchange/apperrors"
+	"crypto-exchange/models"
+	"crypto-exchange/policy"
+	"crypto-exchange/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog"
+)
+
+// Gin context keys set by Authenticate.
+const (
+	// UserIDKey holds the ID of the authenticated user.
+	UserIDKey = "user_id"
+	// ActorKey holds the policy.Actor of the authenticated user.
+	ActorKey = "actor"
+	// TokenClaimsKey holds the *services.TokenClaims of the access token.
+	TokenClaimsKey = "token_claims"
+	// APIKeyKey holds the models.APIKey a request was signed with.
+	APIKeyKey = "api_key"
+)
+
+// Headers of requests signed with an API key.
+const (
+	APIKeyHeader       = "X-API-Key"
+	APITimestampHeader = "X-API-Timestamp"
+	APINonceHeader     = "X-API-Nonce"
+	APISignatureHeader = "X-API-Signature"
+)
+
+// Errors of requests that fail authentication.
+var (
+	errMissingAccessToken        = apperrors.New(apperrors.ErrUnauthorized, "missing_access_token", "missing access token")
+	errAuthenticationUnavailable = apperrors.New(apperrors.ErrUnavailable, "authentication_unavailable", "authentication unavailable")
+	errInvalidBody               = apperrors.Validation("invalid request body")
+	errBodyTooLarge              = apperrors.New(apperrors.ErrTooLarge, "request_body_too_large", "request body is too large")
+)
+
+// maxBodySize caps the bodies read by middleware, to verify signed requests or
+// fingerprint idempotent ones.
+const maxBodySize = 1 << 20 // 1 MB
+
+// accessTokenParam is the query parameter carrying the token on WebSocket
+// handshakes, where browsers cannot send an Authorization header.
+const accessTokenParam = "access_token"
+
+// Authenticate is a Gin middleware that requires a valid, unrevoked access
+// token of an active user and stores the user, with their current role, in
+// the context.
+func Authenticate(tokens *services.TokenService, log zerolog.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tokenString := bearerToken(c.Request)
+		if tokenString == "" {
+			abortWithError(c, errMissingAccessToken)
+			return
+		}
+
+		claims, actor, err := tokens.Authenticate(tokenString)
+		switch {
+		case errors.Is(err, services.ErrInvalidToken) || errors.Is(err, services.ErrTokenRevoked) || errors.Is(err, services.ErrUserNotActive):
+			log.Warn().Err(err).Msg("Rejected access token")
+			abortWithError(c, err)
+			return
+		case err != nil:
+			log.Error().Err(err).Msg("Failed to verify access token")
+			abortWithError(c, errAuthenticationUnavailable)
+			return
+		}
+
+		c.Set(UserIDKey, actor.UserID)
+		c.Set(ActorKey, actor)
+		setRequestUser(c, actor.UserID)
+		c.Set(TokenClaimsKey, claims)
+		c.Next()
+	}
+}
+
+// AuthenticateSigned is a Gin middleware that accepts requests signed with an
+// API key as well as requests with an access token, and stores the user either
+// was issued to in the context. Routes behind it should check the key's scope
+// with RequireScope. API keys act with the user role whatever the user's role
+// is, so that staff permissions are only ever used interactively.
+func AuthenticateSigned(keys services.APIKeyService, tokens *services.TokenService, log zerolog.Logger) gin.HandlerFunc {
+	bearer := Authenticate(tokens, log)
+	return func(c *gin.Context) {
+		keyID := c.GetHeader(APIKeyHeader)
+		if keyID == "" {
+			bearer(c)
+			return
+		}
+
+		// The signature covers the body, which handlers read again later
+		body, err := readBody(c)
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+
+		key, err := keys.Verify(services.SignedRequest{
+			KeyID:     keyID,
+			Timestamp: c.GetHeader(APITimestampHeader),
+			Nonce:     c.GetHeader(APINonceHeader),
+			Signature: c.GetHeader(APISignatureHeader),
+			Method:    c.Request.Method,
+			Path:      c.Request.URL.RequestURI(),
+			Body:      body,
+			ClientIP:  c.ClientIP(),
+		})
+		switch {
+		case errors.Is(err, services.ErrInvalidSignature) || errors.Is(err, services.ErrNonceReused):
+			log.Warn().Err(err).Str("api_key_id", keyID).Msg("Rejected signed request")
+			abortWithError(c, err)
+			return
+		case errors.Is(err, services.ErrIPNotAllowed):
+			log.Warn().Err(err).Str("api_key_id", keyID).Msg("Rejected signed request")
+			abortWithError(c, err)
+			return
+		case err != nil:
+			log.Error().Err(err).Str("api_key_id", keyID).Msg("Failed to verify signed request")
+			abortWithError(c, errAuthenticationUnavailable)
+			return
+		}
+
+		// Keys of frozen and closed accounts stop working with the account
+		_, err = tokens.ActiveUser(key.UserID)
+		switch {
+		case errors.Is(err, services.ErrUserNotActive):
+			log.Warn().Err(err).Str("api_key_id", keyID).Msg("Rejected signed request")
+			abortWithError(c, err)
+			return
+		case errors.Is(err, services.ErrUserNotFound):
+			log.Warn().Err(err).Str("api_key_id", keyID).Msg("Rejected signed request")
+			abortWithError(c, services.ErrInvalidSignature)
+			return
+		case err != nil:
+			log.Error().Err(err).Str("api_key_id", keyID).Msg("Failed to look up API key user")
+			abortWithError(c, errAuthenticationUnavailable)
+			return
+		}
+
+		c.Set(UserIDKey, key.UserID)
+		c.Set(ActorKey, policy.Actor{UserID: key.UserID, Role: models.RoleUser})
+		setRequestUser(c, key.UserID)
+		c.Set(APIKeyKey, key)
+		c.Next()
+	}
+}
+
+// RequireScope is a Gin middleware that rejects requests signed with an API
+// key lacking a scope. Requests with an access token pass.
+func RequireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if value, ok := c.Get(APIKeyKey); ok && !value.(models.APIKey).HasScope(scope) {
+			abortWithError(c, apperrors.New(apperrors.ErrForbidden, "missing_scope", "API key lacks the "+scope+" scope"))
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequirePermission is a Gin middleware that rejects requests of users whose
+// role lacks a permission. The services check the same policy again.
+func RequirePermission(p policy.Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !CurrentActor(c).Can(p) {
+			abortWithError(c, policy.ErrForbidden)
+			return
+		}
+		c.Next()
+	}
+}
+
+// CurrentActor returns the authenticated user and their role. Unauthenticated
+// requests get an actor without permissions.
+func CurrentActor(c *gin.Context) policy.Actor {
+	value, _ := c.Get(ActorKey)
+	actor, _ := value.(policy.Actor)
+	return actor
+}
+
+// bearerToken returns the token of the Authorization header, or of the
+// access_token query parameter of a WebSocket handshake. Other requests may
+// not pass it in the URL, where it would end up in access logs.
+func bearerToken(r *http.Request) string {
+	if header := r.Header.Get("Authorization"); header != "" {
+		scheme, token, ok := strings.Cut(header, " ")
+		if !ok || !strings.EqualFold(scheme, "Bearer") {
+			return ""
+		}
+		return strings.TrimSpace(token)
+	}
+	if strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		return r.URL.Query().Get(accessTokenParam)
+	}
+	return ""
+}
+
+// readBody reads the body of a request of at most maxBodySize bytes and puts
+// it back for the handlers.
+func readBody(c *gin.Context) ([]byte, error) {
+	body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxBodySize))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return nil, errBodyTooLarge
+		}
+		return nil, errInvalidBody
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
@@ -0,0 +1,41 @@
+// middleware/errors.go
+package middleware
+
+import (
+	"crypto-exchange/apperrors"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ProblemContentType is the media type of error responses (RFC 7807).
+const ProblemContentType = "application/problem+json"
+
+// Errors is a Gin middleware that renders the error handed to c.Error by a
+// handler or middleware as problem details, so that handlers do not write
+// error responses themselves. Errors of no kind in package apperrors are
+// answered with a 500 that says nothing about the cause; Logger logs them.
+func Errors() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+		renderError(c)
+	}
+}
+
+// abortWithError stops the chain and leaves the error to the Errors middleware.
+func abortWithError(c *gin.Context, err error) {
+	c.Abort()
+	_ = c.Error(err)
+}
+
+// renderError writes the last error of the request unless a response was
+// already written.
+func renderError(c *gin.Context) {
+	if len(c.Errors) == 0 || c.Writer.Written() {
+		return
+	}
+	problem := apperrors.ToProblem(c.Errors.Last().Err)
+	problem.Instance = c.Request.URL.Path
+	problem.RequestID = c.GetString(RequestIDKey)
+	c.Header("Content-Type", ProblemContentType)
+	c.JSON(problem.Status, problem)
+}
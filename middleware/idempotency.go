@@ -0,0 +1,146 @@
+// middleware/idempotency.go
+package middleware
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+
+	"crypto-exchange/apperrors"
+	"crypto-exchange/models"
+	"crypto-exchange/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog"
+)
+
+const (
+	// IdempotencyKeyHeader is the request header carrying the client's idempotency key.
+	IdempotencyKeyHeader = "Idempotency-Key"
+	// IdempotentReplayedHeader marks responses replayed from a stored record.
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+
+	maxIdempotencyKeyLength = 255
+)
+
+// Errors of requests with an Idempotency-Key.
+var (
+	errIdempotencyKeyTooLong = apperrors.Validation("Idempotency-Key is too long",
+		apperrors.FieldError{Field: IdempotencyKeyHeader, Code: "max", Message: "Idempotency-Key is too long"})
+	errIdempotencyUnavailable   = apperrors.New(apperrors.ErrUnavailable, "idempotency_unavailable", "idempotency store unavailable")
+	errIdempotencyKeyReused     = apperrors.New(apperrors.ErrConflict, "idempotency_key_reused", "Idempotency-Key was already used with a different request")
+	errIdempotencyKeyInProgress = apperrors.New(apperrors.ErrConflict, "idempotency_key_in_progress", "a request with this Idempotency-Key is still being processed")
+)
+
+// Idempotency is a Gin middleware that makes mutating requests sent with an
+// Idempotency-Key safe to retry. The first response is stored and replayed
+// byte-for-byte on retries; reusing a key with a different request is a conflict.
+// It must run after Authenticate, which scopes keys to the user.
+func Idempotency(store services.IdempotencyService, log zerolog.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(IdempotencyKeyHeader)
+		if key == "" || !isMutating(c.Request.Method) {
+			c.Next()
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			abortWithError(c, errIdempotencyKeyTooLong)
+			return
+		}
+		// Scope keys to the user, so that nobody is replayed another user's response
+		if userID, ok := c.Get(UserIDKey); ok {
+			key = fmt.Sprintf("%d:%s", userID, key)
+		}
+
+		// Fingerprint the request so that a reused key with another payload is detected
+		body, err := readBody(c)
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+		fingerprint := requestFingerprint(c.Request.Method, c.Request.URL.RequestURI(), body)
+
+		existing, err := store.Reserve(key, fingerprint)
+		if err != nil {
+			log.Error().Err(err).Str("idempotency_key", key).Msg("Failed to reserve idempotency key")
+			abortWithError(c, errIdempotencyUnavailable)
+			return
+		}
+		if existing != nil {
+			switch {
+			case existing.Fingerprint != fingerprint:
+				abortWithError(c, errIdempotencyKeyReused)
+			case !existing.Completed():
+				abortWithError(c, errIdempotencyKeyInProgress)
+			default:
+				log.Info().Str("idempotency_key", key).Msg("Replaying idempotent response")
+				c.Header(IdempotentReplayedHeader, "true")
+				c.Data(existing.StatusCode, existing.ContentType, existing.Body)
+				c.Abort()
+			}
+			return
+		}
+
+		// Capture the response while it is written
+		writer := &recordingWriter{ResponseWriter: c.Writer}
+		c.Writer = writer
+		c.Next()
+		// Errors are rendered here, so that their responses are stored too
+		renderError(c)
+
+		// Server errors are not stored so the client can retry them
+		status := c.Writer.Status()
+		if status >= http.StatusInternalServerError {
+			if err := store.Release(key); err != nil {
+				log.Error().Err(err).Str("idempotency_key", key).Msg("Failed to release idempotency key")
+			}
+			return
+		}
+
+		record := models.IdempotencyRecord{
+			Key:         key,
+			Fingerprint: fingerprint,
+			StatusCode:  status,
+			ContentType: c.Writer.Header().Get("Content-Type"),
+			Body:        writer.body.Bytes(),
+		}
+		if err := store.Complete(record); err != nil {
+			log.Error().Err(err).Str("idempotency_key", key).Msg("Failed to store idempotent response")
+		}
+	}
+}
+
+// isMutating reports whether requests with the given method change state.
+func isMutating(method string) bool {
+	switch method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// requestFingerprint hashes the parts of a request that must match on retries.
+func requestFingerprint(method, uri string, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(method + " " + uri + "\n"))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// recordingWriter copies everything written to the response into a buffer.
+type recordingWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *recordingWriter) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *recordingWriter) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
@@ -4,11 +4,17 @@ package middleware
 import (
 	"time"
 
+	"crypto-exchange/logging"
+	"crypto-exchange/metrics"
+
 	"github.com/gin-gonic/gin"
 	"github.com/rs/zerolog"
 )
 
-// Logger is a Gin middleware that logs HTTP requests using zerolog.
+// Logger is a Gin middleware that logs HTTP requests using zerolog and
+// records them in the HTTP request metrics. Requests are logged with the
+// request's logger set by RequestID, if any, together with the error handed
+// to c.Error: server errors at error level and client errors at warn level.
 func Logger(log zerolog.Logger) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		startTime := time.Now()
@@ -22,11 +28,25 @@ func Logger(log zerolog.Logger) gin.HandlerFunc {
 		// Get status code
 		statusCode := c.Writer.Status()
 
+		// Record the request by route template, not by path, to bound the series
+		metrics.ObserveHTTPRequest(c.Request.Method, c.FullPath(), statusCode, latency)
+
 		// Log the details of the request
-		log.Info().
+		requestLog := logging.FromContext(c.Request.Context(), log)
+		event := requestLog.Info()
+		switch {
+		case statusCode >= 500:
+			event = requestLog.Error()
+		case statusCode >= 400:
+			event = requestLog.Warn()
+		}
+		if len(c.Errors) > 0 {
+			event = event.Err(c.Errors.Last().Err)
+		}
+		event.
 			Int("status", statusCode).
-			String("method", c.Request.Method).
-			String("path", c.Request.URL.Path).
+			Str("method", c.Request.Method).
+			Str("path", c.Request.URL.Path).
 			Dur("latency", latency).
 			Msg("Handled request")
 	}
@@ -0,0 +1,94 @@
+// middleware/rate_limit.go
+package middleware
+
+import (
+	"math"
+	"strconv"
+	"time"
+
+	"crypto-exchange/apperrors"
+	"crypto-exchange/config"
+	"crypto-exchange/models"
+	"crypto-exchange/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog"
+)
+
+// Rate limit response headers, after the IETF RateLimit header fields draft.
+const (
+	RateLimitLimitHeader     = "RateLimit-Limit"
+	RateLimitRemainingHeader = "RateLimit-Remaining"
+	RateLimitResetHeader     = "RateLimit-Reset"
+)
+
+// errRateLimited is returned for requests over their limit.
+var errRateLimited = apperrors.New(apperrors.ErrRateLimited, "rate_limit_exceeded", "rate limit exceeded")
+
+// RateLimit is a Gin middleware that limits requests under the rule of a
+// route group. Requests signed with an API key are counted per key, with the
+// limit scaled by the key's tier; other authenticated requests per user, and
+// requests before authentication per client IP. Groups without a rule are not
+// limited. If Redis fails, the limiter falls back to counting in memory;
+// should that fail as well, requests are let through.
+func RateLimit(limiter services.RateLimiter, cfg config.RateLimitConfig, group string, log zerolog.Logger) gin.HandlerFunc {
+	rule, ok := cfg.Groups[group]
+	if !cfg.Enabled || !ok {
+		return func(c *gin.Context) { c.Next() }
+	}
+	return func(c *gin.Context) {
+		key, scaled := rateLimitKey(c, cfg, group, rule)
+		result, err := limiter.Allow(c.Request.Context(), key, scaled)
+		if err != nil {
+			log.Error().Err(err).Str("rate_limit_key", key).Msg("Failed to apply rate limit")
+			c.Next()
+			return
+		}
+
+		setRateLimitHeaders(c, result)
+		if !result.Allowed {
+			c.Header("Retry-After", strconv.Itoa(ceilSeconds(result.RetryAfter)))
+			log.Warn().
+				Str("rate_limit_key", key).
+				Str("path", c.FullPath()).
+				Msg("Rate limit exceeded")
+			abortWithError(c, errRateLimited)
+			return
+		}
+		c.Next()
+	}
+}
+
+// rateLimitKey returns the bucket a request is counted in and the rule that
+// applies to it.
+func rateLimitKey(c *gin.Context, cfg config.RateLimitConfig, group string, rule config.RateLimitRule) (string, config.RateLimitRule) {
+	if value, ok := c.Get(APIKeyKey); ok {
+		key := value.(models.APIKey)
+		if multiplier, ok := cfg.APIKeyTiers[key.Tier]; ok {
+			rule.Requests = int(math.Max(1, math.Round(float64(rule.Requests)*multiplier)))
+		}
+		return group + ":key:" + key.ID, rule
+	}
+	if userID := c.GetUint(UserIDKey); userID != 0 {
+		return group + ":user:" + strconv.FormatUint(uint64(userID), 10), rule
+	}
+	return group + ":ip:" + c.ClientIP(), rule
+}
+
+// setRateLimitHeaders reports the state of a limit. When several limits apply
+// to a route, the one with the fewest remaining requests is reported.
+func setRateLimitHeaders(c *gin.Context, result services.RateLimitResult) {
+	if current := c.Writer.Header().Get(RateLimitRemainingHeader); current != "" {
+		if remaining, err := strconv.Atoi(current); err == nil && remaining < result.Remaining {
+			return
+		}
+	}
+	c.Header(RateLimitLimitHeader, strconv.Itoa(result.Limit))
+	c.Header(RateLimitRemainingHeader, strconv.Itoa(result.Remaining))
+	c.Header(RateLimitResetHeader, strconv.Itoa(ceilSeconds(result.ResetAfter)))
+}
+
+// ceilSeconds rounds a duration up to whole seconds.
+func ceilSeconds(d time.Duration) int {
+	return int((d + time.Second - 1) / time.Second)
+}
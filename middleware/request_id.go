@@ -0,0 +1,69 @@
+// middleware/request_id.go
+package middleware
+
+import (
+	"crypto-exchange/logging"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+)
+
+// RequestIDHeader carries the ID of a request, both ways.
+const RequestIDHeader = "X-Request-ID"
+
+// RequestIDKey is the Gin context key holding the request ID.
+const RequestIDKey = "request_id"
+
+// maxRequestIDLength caps the request IDs accepted from callers.
+const maxRequestIDLength = 128
+
+// RequestID is a Gin middleware that gives every request an ID and a logger.
+// The caller's X-Request-ID is kept if it is sane, so that a request can be
+// followed through the proxies in front of us; otherwise a UUID is generated.
+// The ID is echoed in the response, and the request's context carries a
+// logger holding the request ID and route, to which Authenticate adds the
+// user ID.
+func RequestID(log zerolog.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(RequestIDHeader)
+		if !validRequestID(id) {
+			id = uuid.NewString()
+		}
+		c.Set(RequestIDKey, id)
+		c.Header(RequestIDHeader, id)
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		requestLog := log.With().
+			Str("request_id", id).
+			Str("route", route).
+			Logger()
+		ctx := logging.WithRequestID(c.Request.Context(), id)
+		c.Request = c.Request.WithContext(logging.WithLogger(ctx, requestLog))
+		c.Next()
+	}
+}
+
+// validRequestID reports whether a request ID from a caller is short and
+// printable, so that it cannot forge log lines.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// setRequestUser adds the authenticated user to the request's logger.
+func setRequestUser(c *gin.Context, userID uint) {
+	c.Request = c.Request.WithContext(logging.UpdateLogger(c.Request.Context(), func(l zerolog.Context) zerolog.Context {
+		return l.Uint("user_id", userID)
+	}))
+}
@@ -0,0 +1,51 @@
+// models/api_key.go
+package models
+
+// API key scopes. Read covers every GET endpoint open to API keys, trade
+// placing and cancelling orders, and withdraw creating and confirming transactions.
+const (
+	APIKeyScopeRead     = "read"
+	APIKeyScopeTrade    = "trade"
+	APIKeyScopeWithdraw = "withdraw"
+)
+
+// APIKeyTierStandard is the rate limit tier of new API keys. Operators move
+// keys to other tiers named in the rate limit configuration.
+const APIKeyTierStandard = "standard"
+
+// APIKey is a key a user's programs sign requests with. The secret is stored
+// encrypted and shown only when the key is created.
+type APIKey struct {
+	ID              string   `json:"id" gorm:"primaryKey;size:40"`
+	UserID          uint     `json:"user_id" gorm:"index"`
+	Label           string   `json:"label" gorm:"size:100"`
+	Scopes          []string `json:"scopes" gorm:"serializer:json"`
+	AllowedIPs      []string `json:"allowed_ips" gorm:"serializer:json"` // IPs or CIDRs; empty allows any
+	Tier            string   `json:"tier" gorm:"size:20;default:standard"`
+	EncryptedSecret string   `json:"-"`
+	LastUsedAt      *int64   `json:"last_used_at,omitempty"`
+	CreatedAt       int64    `json:"created_at"`
+}
+
+// HasScope reports whether the key was granted a scope.
+func (k APIKey) HasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// CreateAPIKeyRequest is the payload of POST /api-keys.
+type CreateAPIKeyRequest struct {
+	Label      string   `json:"label" binding:"max=100"`
+	Scopes     []string `json:"scopes" binding:"required,min=1,dive,oneof=read trade withdraw"`
+	AllowedIPs []string `json:"allowed_ips" binding:"max=20,dive,ip|cidr"`
+}
+
+// CreatedAPIKey is returned once when an API key is created, with its secret.
+type CreatedAPIKey struct {
+	APIKey
+	Secret string `json:"secret"`
+}
@@ -0,0 +1,30 @@
+// models/auth.go
+package models
+
+// LoginRequest is the payload for logging in.
+type LoginRequest struct {
+	Email    string `json:"email" binding:"required,email"`
+	Password string `json:"password" binding:"required"`
+}
+
+// RefreshRequest is the payload for exchanging a refresh token, and
+// optionally for revoking it on logout.
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+// LogoutRequest is the payload for logging out. The access token of the
+// request is always revoked; the refresh token only if given.
+type LogoutRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// TokenPair is returned by login and refresh. ExpiresIn and
+// RefreshExpiresIn are in seconds.
+type TokenPair struct {
+	AccessToken      string `json:"access_token"`
+	RefreshToken     string `json:"refresh_token"`
+	TokenType        string `json:"token_type"`
+	ExpiresIn        int64  `json:"expires_in"`
+	RefreshExpiresIn int64  `json:"refresh_expires_in"`
+}
@@ -0,0 +1,13 @@
+// models/balance.go
+package models
+
+import "github.com/shopspring/decimal"
+
+// Balance is a user's position in a single asset.
+// Total is the settled ledger balance; Held is reserved by pending and processing withdrawals.
+type Balance struct {
+	CryptoSymbol string          `json:"crypto_symbol"`
+	Available    decimal.Decimal `json:"available"`
+	Held         decimal.Decimal `json:"held"`
+	Total        decimal.Decimal `json:"total"`
+}
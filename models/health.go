@@ -0,0 +1,26 @@
+// models/health.go
+package models
+
+// Health statuses. A service is down when a critical dependency is, and
+// degraded when only optional ones are.
+const (
+	HealthUp       = "up"
+	HealthDegraded = "degraded"
+	HealthDown     = "down"
+)
+
+// DependencyHealth is the result of checking one dependency.
+type DependencyHealth struct {
+	Name      string  `json:"name"`
+	Status    string  `json:"status"` // up or down
+	Critical  bool    `json:"critical"`
+	LatencyMS float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// HealthReport describes the health of the service and its dependencies.
+type HealthReport struct {
+	Status       string             `json:"status"`
+	CheckedAt    int64              `json:"checked_at"` // unix milliseconds
+	Dependencies []DependencyHealth `json:"dependencies"`
+}
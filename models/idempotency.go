@@ -0,0 +1,20 @@
+// models/idempotency.go
+package models
+
+// IdempotencyRecord is the stored outcome of a request sent with an Idempotency-Key.
+// A record with a zero StatusCode is still being processed. Key is prefixed with
+// the ID of the user who sent it.
+type IdempotencyRecord struct {
+	Key         string `json:"key" gorm:"primaryKey;size:300"`
+	Fingerprint string `json:"fingerprint"`
+	StatusCode  int    `json:"status_code"`
+	ContentType string `json:"content_type"`
+	Body        []byte `json:"body"`
+	CreatedAt   int64  `json:"created_at"`
+	ExpiresAt   int64  `json:"expires_at" gorm:"index"`
+}
+
+// Completed reports whether the response of the request has been stored.
+func (r IdempotencyRecord) Completed() bool {
+	return r.StatusCode != 0
+}
@@ -0,0 +1,51 @@
+// models/ledger.go
+package models
+
+import "github.com/shopspring/decimal"
+
+// Ledger account kinds.
+const (
+	// AccountKindUser holds a customer's funds for a single asset.
+	AccountKindUser = "user"
+	// AccountKindExternal mirrors funds outside the exchange (chain, bank).
+	AccountKindExternal = "external"
+	// AccountKindFees collects the fees charged on transactions.
+	AccountKindFees = "fees"
+)
+
+// LedgerAccount is a single-asset account in the double-entry ledger.
+type LedgerAccount struct {
+	ID        string `json:"id" gorm:"primaryKey"` // e.g., user:42:BTC
+	Kind      string `json:"kind" gorm:"index"`
+	UserID    uint   `json:"user_id,omitempty" gorm:"index"`
+	Asset     string `json:"asset" gorm:"index"`
+	CreatedAt int64  `json:"created_at"`
+}
+
+// JournalEntry groups the postings recorded for one business event, either a
+// transaction or a trade.
+type JournalEntry struct {
+	ID            string    `json:"id" gorm:"primaryKey"`
+	TransactionID string    `json:"transaction_id,omitempty" gorm:"index"`
+	TradeID       string    `json:"trade_id,omitempty" gorm:"index"`
+	Description   string    `json:"description"`
+	Postings      []Posting `json:"postings" gorm:"foreignKey:EntryID"`
+	CreatedAt     int64     `json:"created_at"`
+}
+
+// Posting moves Amount of Asset into (positive) or out of (negative) an account.
+// The postings of a journal entry always sum to zero per asset.
+type Posting struct {
+	ID        uint            `json:"id" gorm:"primaryKey"`
+	EntryID   string          `json:"entry_id" gorm:"index"`
+	AccountID string          `json:"account_id" gorm:"index"`
+	Asset     string          `json:"asset"`
+	Amount    decimal.Decimal `json:"amount" gorm:"type:numeric(38,18)"`
+}
+
+// LedgerBalance is the summed postings of one account.
+type LedgerBalance struct {
+	AccountID string          `json:"account_id"`
+	Asset     string          `json:"asset"`
+	Balance   decimal.Decimal `json:"balance"`
+}
@@ -0,0 +1,47 @@
+// models/market.go
+package models
+
+import "github.com/shopspring/decimal"
+
+// Ticker summarizes the completed transactions of one CryptoSymbol. Prices are
+// implied by Amount / CryptoAmount and quoted in the fiat currency.
+type Ticker struct {
+	Symbol         string              `json:"symbol"`
+	QuoteCurrency  string              `json:"quote_currency"`
+	LastPrice      decimal.NullDecimal `json:"last_price"`
+	LastAt         int64               `json:"last_at,omitempty"`
+	High24h        decimal.NullDecimal `json:"high_24h"`
+	Low24h         decimal.NullDecimal `json:"low_24h"`
+	Volume24h      decimal.Decimal     `json:"volume_24h"`       // in CryptoSymbol
+	QuoteVolume24h decimal.Decimal     `json:"quote_volume_24h"` // in QuoteCurrency
+	Count24h       int64               `json:"count_24h"`
+	AsOf           int64               `json:"as_of"`
+}
+
+// Candle aggregates the completed transactions of one interval.
+type Candle struct {
+	StartTime   int64           `json:"start_time"`
+	Open        decimal.Decimal `json:"open"`
+	High        decimal.Decimal `json:"high"`
+	Low         decimal.Decimal `json:"low"`
+	Close       decimal.Decimal `json:"close"`
+	Volume      decimal.Decimal `json:"volume"`
+	QuoteVolume decimal.Decimal `json:"quote_volume"`
+	Count       int64           `json:"count"`
+}
+
+// PriceHistory lists the candles of a CryptoSymbol, oldest first. Intervals
+// without completed transactions are omitted.
+type PriceHistory struct {
+	Symbol        string   `json:"symbol"`
+	QuoteCurrency string   `json:"quote_currency"`
+	Interval      string   `json:"interval"`
+	Candles       []Candle `json:"candles"`
+	AsOf          int64    `json:"as_of"`
+}
+
+// MarketList is the ticker of every market.
+type MarketList struct {
+	Markets []Ticker `json:"markets"`
+	AsOf    int64    `json:"as_of"`
+}
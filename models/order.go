@@ -0,0 +1,111 @@
+// models/order.go
+package models
+
+import (
+	"strings"
+
+	"github.com/shopspring/decimal"
+)
+
+// Order sides.
+const (
+	SideBuy  = "buy"
+	SideSell = "sell"
+)
+
+// Order types.
+const (
+	OrderTypeLimit  = "limit"
+	OrderTypeMarket = "market"
+)
+
+// Time-in-force policies.
+const (
+	// TimeInForceGTC rests the unfilled part of a limit order on the book.
+	TimeInForceGTC = "gtc"
+	// TimeInForceIOC fills what it can immediately and cancels the rest.
+	TimeInForceIOC = "ioc"
+	// TimeInForceFOK fills the whole order immediately or nothing at all.
+	TimeInForceFOK = "fok"
+)
+
+// Order statuses.
+const (
+	OrderStatusOpen            = "open"
+	OrderStatusPartiallyFilled = "partially_filled"
+	OrderStatusFilled          = "filled"
+	OrderStatusCancelled       = "cancelled"
+)
+
+// Order is a request to buy or sell Quantity of the base asset of Pair.
+// Prices are in the quote asset, e.g. USDT per BTC for BTC-USDT.
+type Order struct {
+	ID             string          `json:"id" gorm:"primaryKey"`
+	UserID         uint            `json:"user_id" gorm:"index"`
+	Pair           string          `json:"pair" gorm:"index"` // e.g., BTC-USDT
+	Side           string          `json:"side"`
+	Type           string          `json:"type"`
+	TimeInForce    string          `json:"time_in_force"`
+	PostOnly       bool            `json:"post_only"`
+	Price          decimal.Decimal `json:"price" gorm:"type:numeric(38,18)"`
+	Quantity       decimal.Decimal `json:"quantity" gorm:"type:numeric(38,18)"`
+	FilledQuantity decimal.Decimal `json:"filled_quantity" gorm:"type:numeric(38,18)"`
+	Status         string          `json:"status" gorm:"index"`
+	CreatedAt      int64           `json:"created_at" gorm:"autoCreateTime:milli;index"`
+	UpdatedAt      int64           `json:"updated_at" gorm:"autoUpdateTime:milli"`
+}
+
+// Remaining returns the quantity that is not filled yet.
+func (o Order) Remaining() decimal.Decimal {
+	return o.Quantity.Sub(o.FilledQuantity)
+}
+
+// IsOpen reports whether the order rests on the book.
+func (o Order) IsOpen() bool {
+	return o.Status == OrderStatusOpen || o.Status == OrderStatusPartiallyFilled
+}
+
+// PlaceOrderRequest is the payload for placing an order.
+type PlaceOrderRequest struct {
+	UserID      uint            `json:"-"` // set from the access token
+	Pair        string          `json:"pair" binding:"required"`
+	Side        string          `json:"side" binding:"required,oneof=buy sell"`
+	Type        string          `json:"type" binding:"required,oneof=limit market"`
+	TimeInForce string          `json:"time_in_force" binding:"omitempty,oneof=gtc ioc fok"`
+	PostOnly    bool            `json:"post_only"`
+	Price       decimal.Decimal `json:"price" binding:"required_if=Type limit,gte=0"`
+	Quantity    decimal.Decimal `json:"quantity" binding:"required,gt=0"`
+}
+
+// Trade is a match between a resting (maker) order and an incoming (taker) order.
+// The buyer pays QuoteQuantity of the quote asset for Quantity of the base asset.
+type Trade struct {
+	ID            string          `json:"id" gorm:"primaryKey"`
+	Pair          string          `json:"pair" gorm:"index"`
+	Price         decimal.Decimal `json:"price" gorm:"type:numeric(38,18)"`
+	Quantity      decimal.Decimal `json:"quantity" gorm:"type:numeric(38,18)"`
+	QuoteQuantity decimal.Decimal `json:"quote_quantity" gorm:"type:numeric(38,18)"`
+	TakerSide     string          `json:"taker_side"`
+	MakerOrderID  string          `json:"maker_order_id" gorm:"index"`
+	TakerOrderID  string          `json:"taker_order_id" gorm:"index"`
+	BuyerID       uint            `json:"buyer_id" gorm:"index"`
+	SellerID      uint            `json:"seller_id" gorm:"index"`
+	CreatedAt     int64           `json:"created_at" gorm:"autoCreateTime:milli;index"`
+}
+
+// OrderResult is an order together with the trades it produced when placed.
+type OrderResult struct {
+	Order  Order   `json:"order"`
+	Trades []Trade `json:"trades"`
+}
+
+// PairSymbol returns the symbol of the pair trading base against quote.
+func PairSymbol(base, quote string) string {
+	return base + "-" + quote
+}
+
+// SplitPair returns the base and quote assets of a pair symbol.
+func SplitPair(pair string) (base, quote string, ok bool) {
+	base, quote, ok = strings.Cut(pair, "-")
+	return base, quote, ok && base != "" && quote != ""
+}
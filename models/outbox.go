@@ -0,0 +1,21 @@
+// models/outbox.go
+package models
+
+// OutboxMessage is an event written in the same DB transaction as the change it
+// describes, and published to Kafka afterwards by the outbox relay.
+type OutboxMessage struct {
+	ID           uint   `json:"id" gorm:"primaryKey"`
+	Key          string `json:"key"`
+	Payload      []byte `json:"payload"`
+	TraceContext []byte `json:"-"` // W3C trace context of the change, as JSON headers
+	Attempts     int    `json:"attempts"`
+	LastError    string `json:"last_error,omitempty"`
+	PublishedAt  *int64 `json:"published_at,omitempty" gorm:"index"`
+	CreatedAt    int64  `json:"created_at" gorm:"autoCreateTime:milli"`
+}
+
+// OutboxLag describes how far the outbox relay is behind.
+type OutboxLag struct {
+	Pending          int64   `json:"pending"`
+	OldestAgeSeconds float64 `json:"oldest_age_seconds"`
+}
@@ -0,0 +1,43 @@
+// models/stream.go
+package models
+
+import "github.com/shopspring/decimal"
+
+// Stream message types sent to WebSocket clients.
+const (
+	StreamSnapshot     = "snapshot"
+	StreamUpdate       = "update"
+	StreamUnsubscribed = "unsubscribed"
+	StreamError        = "error"
+)
+
+// StreamRequest is a message sent by a WebSocket client, e.g.
+// {"op": "subscribe", "channel": "market:BTC"}.
+type StreamRequest struct {
+	Op      string `json:"op"` // subscribe or unsubscribe
+	Channel string `json:"channel"`
+}
+
+// StreamMessage is a message sent to a WebSocket client. The updates of a
+// channel carry consecutive sequence numbers, starting after the Seq of its
+// snapshot; a gap means updates were missed and the client should subscribe
+// again to receive a fresh snapshot.
+type StreamMessage struct {
+	Type    string      `json:"type"`
+	Channel string      `json:"channel,omitempty"`
+	Seq     int64       `json:"seq,omitempty"`
+	Event   string      `json:"event,omitempty"`
+	Data    interface{} `json:"data,omitempty"`
+	Error   string      `json:"error,omitempty"`
+}
+
+// MarketActivity is the update sent on a market channel when a transaction of
+// its symbol is created or changes status. It leaves out whose transaction it is.
+type MarketActivity struct {
+	Symbol       string          `json:"symbol"`
+	Type         string          `json:"type"`
+	Status       string          `json:"status"`
+	Amount       decimal.Decimal `json:"amount"`
+	CryptoAmount decimal.Decimal `json:"crypto_amount"`
+	UpdatedAt    int64           `json:"updated_at"`
+}
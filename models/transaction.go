@@ -1,18 +1,23 @@
 // models/transaction.go
 package models
 
+import "github.com/shopspring/decimal"
+
+// Transaction is a deposit or withdrawal of CryptoAmount of CryptoSymbol.
+// Amounts are exact decimals, encoded as JSON strings and stored as NUMERIC.
 type Transaction struct {
-    ID     uint  `json:"id" gorm:"primaryKey" binding:"required"`
-    UserID uint `json:"user_id" binding:"required"`
-    Amount float64 `json:"amount" binding:"required,gt=0"`
-    Type   string  `json:"type" binding:"required,oneof=deposit withdrawal"`
-    Status string  `json:"status" binding:"required,oneof=pending completed failed"`
-    CryptoType string `json:"crypto_type" binding:"required"`
-    TransactionID  string   `json:"transaction_id" binding:"required"`
-    CryptoAmount   float64 `json:"crypto_amount" binding:"required,gt=0"`
-    CryptoSymbol   string  `json:"crypto_symbol" binding:"required"` // e.g., BTC, ETH
-    TransactionFee float64 `json:"transaction_fee" binding:"required,gt=0"`
-    CreatedAt   int64   `json:"created_at"`
-    UpdatedAt   int64   `json:"updated_at"`
-    DeletedAt   int64   `json:"deleted_at,omitempty"`
-}
\ No newline at end of file
+	ID             string          `json:"id" gorm:"primaryKey" binding:"required,identifier"`
+	UserID         uint            `json:"user_id" gorm:"index"` // set from the access token
+	Amount         decimal.Decimal `json:"amount" gorm:"type:numeric(38,18)" binding:"required,gt=0"`
+	Type           string          `json:"type" binding:"required,oneof=deposit withdrawal"`
+	Status         string          `json:"status" binding:"required,eq=pending"`
+	CryptoType     string          `json:"crypto_type" binding:"required"`
+	TransactionID  string          `json:"transaction_id" binding:"required"`
+	CryptoAmount   decimal.Decimal `json:"crypto_amount" gorm:"type:numeric(38,18)" binding:"required,gt=0"`
+	CryptoSymbol   string          `json:"crypto_symbol" binding:"required"` // e.g., BTC, ETH
+	TransactionFee decimal.Decimal `json:"transaction_fee" gorm:"type:numeric(38,18)" binding:"required,gt=0"`
+	CreatedAt      int64           `json:"created_at" gorm:"index"` // set by the store, like the other timestamps
+	UpdatedAt      int64           `json:"updated_at"`
+	DeletedAt      int64           `json:"deleted_at,omitempty"`
+	StepUpAt       *int64          `json:"step_up_at,omitempty"` // when a withdrawal passed the two-factor step-up
+}
@@ -0,0 +1,34 @@
+// models/transaction_list.go
+package models
+
+// TransactionFilter holds the query parameters of GET /transactions.
+// Empty fields do not filter. Amounts are decimal strings compared against Amount;
+// CreatedFrom is inclusive and CreatedTo exclusive, both in Unix seconds.
+// Source selects where a user's transactions are read from: the primary
+// store by default, or the history read model, which may lag behind it.
+type TransactionFilter struct {
+	UserID       uint   `form:"user_id"`
+	Type         string `form:"type" binding:"omitempty,oneof=deposit withdrawal"`
+	Status       string `form:"status" binding:"omitempty,oneof=pending processing completed failed cancelled reversed"`
+	CryptoSymbol string `form:"crypto_symbol"`
+	MinAmount    string `form:"min_amount"`
+	MaxAmount    string `form:"max_amount"`
+	CreatedFrom  int64  `form:"created_from" binding:"omitempty,min=0"`
+	CreatedTo    int64  `form:"created_to" binding:"omitempty,min=0"`
+	Cursor       string `form:"cursor"`
+	Limit        int    `form:"limit" binding:"omitempty,min=1,max=100"`
+	Source       string `form:"source" binding:"omitempty,oneof=primary history"`
+}
+
+// Sources of transaction listings.
+const (
+	SourcePrimary = "primary"
+	SourceHistory = "history"
+)
+
+// TransactionPage is one page of transactions, newest first.
+// NextCursor is empty on the last page.
+type TransactionPage struct {
+	Transactions []Transaction `json:"transactions"`
+	NextCursor   string        `json:"next_cursor,omitempty"`
+}
@@ -0,0 +1,59 @@
+// models/transaction_status.go
+package models
+
+// Transaction statuses.
+const (
+	StatusPending    = "pending"
+	StatusProcessing = "processing"
+	StatusCompleted  = "completed"
+	StatusFailed     = "failed"
+	StatusCancelled  = "cancelled"
+	StatusReversed   = "reversed"
+)
+
+// transitions lists the statuses each status may move to.
+var transitions = map[string][]string{
+	StatusPending:    {StatusProcessing, StatusFailed, StatusCancelled},
+	StatusProcessing: {StatusCompleted, StatusFailed},
+	StatusCompleted:  {StatusReversed},
+}
+
+// CanTransition reports whether a transaction may move from one status to another.
+func CanTransition(from, to string) bool {
+	for _, next := range transitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// IsHolding reports whether a withdrawal in the given status reserves funds.
+func IsHolding(status string) bool {
+	return status == StatusPending || status == StatusProcessing
+}
+
+// TransactionTransition is an audit row recording one status change of a transaction.
+// The row written on creation has an empty FromStatus and the owner as actor.
+// Changes made by the system itself have no actor ID.
+type TransactionTransition struct {
+	ID            uint   `json:"id" gorm:"primaryKey"`
+	TransactionID string `json:"transaction_id" gorm:"index"`
+	FromStatus    string `json:"from_status"`
+	ToStatus      string `json:"to_status"`
+	Reason        string `json:"reason,omitempty"`
+	ActorID       uint   `json:"actor_id,omitempty"`
+	ActorRole     string `json:"actor_role,omitempty" gorm:"size:20"`
+	CreatedAt     int64  `json:"created_at"`
+}
+
+// TransitionRequest is the payload of POST /transactions/:id/transitions.
+type TransitionRequest struct {
+	Status string `json:"status" binding:"required,oneof=processing completed failed cancelled reversed"`
+	Reason string `json:"reason"`
+}
+
+// ForceFailRequest is the payload of POST /admin/transactions/:id/fail.
+type ForceFailRequest struct {
+	Reason string `json:"reason" binding:"required,max=500"`
+}
@@ -0,0 +1,39 @@
+// models/two_factor.go
+package models
+
+// TwoFactor is the TOTP secret of a user. It takes effect once EnabledAt is
+// set by confirming a first code.
+type TwoFactor struct {
+	UserID       uint   `json:"user_id" gorm:"primaryKey"`
+	Secret       string `json:"-"` // base32
+	EnabledAt    *int64 `json:"enabled_at,omitempty"`
+	LastUsedStep int64  `json:"-"` // codes of this step or earlier cannot be reused
+	CreatedAt    int64  `json:"created_at"`
+	UpdatedAt    int64  `json:"updated_at"`
+}
+
+// RecoveryCode is a one-time code standing in for a TOTP code. Only the
+// SHA-256 hash of the code is stored.
+type RecoveryCode struct {
+	ID       uint   `json:"id" gorm:"primaryKey"`
+	UserID   uint   `json:"user_id" gorm:"index"`
+	CodeHash string `json:"-" gorm:"size:64"`
+	UsedAt   *int64 `json:"used_at,omitempty"`
+}
+
+// TwoFactorEnrollment is returned when starting TOTP enrollment. The
+// provisioning URI is meant to be shown as a QR code.
+type TwoFactorEnrollment struct {
+	Secret          string `json:"secret"`
+	ProvisioningURI string `json:"provisioning_uri"`
+}
+
+// TwoFactorCodeRequest carries a TOTP or recovery code.
+type TwoFactorCodeRequest struct {
+	Code string `json:"code" binding:"required,max=32"`
+}
+
+// RecoveryCodes is returned once when two-factor authentication is enabled.
+type RecoveryCodes struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
@@ -0,0 +1,79 @@
+// models/user.go
+package models
+
+// Account statuses. Only active users can log in and move funds; frozen
+// accounts can be reactivated, closed ones cannot.
+const (
+	UserStatusActive = "active"
+	UserStatusFrozen = "frozen"
+	UserStatusClosed = "closed"
+)
+
+// Roles. Users only reach their own resources; staff roles are granted
+// permissions over other users' by the policy package.
+const (
+	RoleUser       = "user"
+	RoleSupport    = "support"
+	RoleCompliance = "compliance"
+	RoleAdmin      = "admin"
+)
+
+// User is an account holder. Emails are stored lower-cased.
+type User struct {
+	ID              uint   `json:"id" gorm:"primaryKey"`
+	Email           string `json:"email" gorm:"uniqueIndex;size:254"`
+	PasswordHash    string `json:"-"`
+	FullName        string `json:"full_name" gorm:"size:100"`
+	Status          string `json:"status" gorm:"index"`
+	Role            string `json:"role" gorm:"size:20;default:user"`
+	EmailVerifiedAt *int64 `json:"email_verified_at,omitempty"`
+	CreatedAt       int64  `json:"created_at"`
+	UpdatedAt       int64  `json:"updated_at"`
+}
+
+// EmailVerified reports whether the user confirmed their email address.
+func (u User) EmailVerified() bool {
+	return u.EmailVerifiedAt != nil
+}
+
+// EmailVerification is a pending email verification. Only the SHA-256 hash of
+// the token sent to the user is stored.
+type EmailVerification struct {
+	TokenHash string `json:"-" gorm:"primaryKey;size:64"`
+	UserID    uint   `json:"user_id" gorm:"index"`
+	ExpiresAt int64  `json:"expires_at"`
+	CreatedAt int64  `json:"created_at"`
+}
+
+// RegisterRequest is the payload for creating an account.
+type RegisterRequest struct {
+	Email    string `json:"email" binding:"required,email,max=254"`
+	Password string `json:"password" binding:"required,min=12,max=128"`
+	FullName string `json:"full_name" binding:"max=100"`
+}
+
+// VerifyEmailRequest is the payload for confirming an email address.
+type VerifyEmailRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+// ResendVerificationRequest is the payload for requesting a new verification email.
+type ResendVerificationRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+// UpdateProfileRequest is the payload for changing a user's profile. Omitted
+// fields are left unchanged.
+type UpdateProfileRequest struct {
+	FullName *string `json:"full_name" binding:"omitempty,max=100"`
+}
+
+// SetUserStatusRequest is the payload for activating, freezing or closing an account.
+type SetUserStatusRequest struct {
+	Status string `json:"status" binding:"required,oneof=active frozen closed"`
+}
+
+// SetUserRoleRequest is the payload for changing a user's role.
+type SetUserRoleRequest struct {
+	Role string `json:"role" binding:"required,oneof=user support compliance admin"`
+}
@@ -0,0 +1,109 @@
+// money/money.go
+package money
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"crypto-exchange/apperrors"
+	"crypto-exchange/config"
+
+	"github.com/shopspring/decimal"
+)
+
+// ErrUnknownAsset is returned for symbols that have no configured precision.
+var ErrUnknownAsset = apperrors.New(apperrors.ErrValidation, "unknown_asset", "unknown asset")
+
+// Rounding modes accepted in the money configuration.
+const (
+	RoundHalfEven = "half_even"
+	RoundHalfUp   = "half_up"
+	RoundDown     = "down"
+	RoundUp       = "up"
+	RoundFloor    = "floor"
+	RoundCeil     = "ceil"
+)
+
+// Asset describes how amounts of a single asset are stored.
+type Asset struct {
+	Symbol    string
+	Precision int32
+	Rounding  string
+}
+
+// Round rounds an amount to the asset's precision using its rounding mode.
+func (a Asset) Round(d decimal.Decimal) decimal.Decimal {
+	switch a.Rounding {
+	case RoundHalfUp:
+		return d.Round(a.Precision)
+	case RoundDown:
+		return d.RoundDown(a.Precision)
+	case RoundUp:
+		return d.RoundUp(a.Precision)
+	case RoundFloor:
+		return d.RoundFloor(a.Precision)
+	case RoundCeil:
+		return d.RoundCeil(a.Precision)
+	default:
+		return d.RoundBank(a.Precision)
+	}
+}
+
+// Registry holds the configured assets keyed by symbol.
+type Registry struct {
+	assets map[string]Asset
+	fiat   string
+}
+
+// NewRegistry builds a Registry from the money configuration.
+func NewRegistry(cfg config.MoneyConfig) (*Registry, error) {
+	r := &Registry{
+		assets: make(map[string]Asset, len(cfg.Assets)),
+		fiat:   strings.ToUpper(cfg.FiatCurrency),
+	}
+	for _, a := range cfg.Assets {
+		symbol := strings.ToUpper(a.Symbol)
+		if _, exists := r.assets[symbol]; exists {
+			return nil, fmt.Errorf("asset %s configured twice", symbol)
+		}
+		r.assets[symbol] = Asset{Symbol: symbol, Precision: a.Precision, Rounding: a.Rounding}
+	}
+	if _, ok := r.assets[r.fiat]; !ok {
+		return nil, fmt.Errorf("fiat currency %s has no asset configuration", r.fiat)
+	}
+	return r, nil
+}
+
+// Asset returns the configuration of an asset.
+func (r *Registry) Asset(symbol string) (Asset, error) {
+	a, ok := r.assets[strings.ToUpper(symbol)]
+	if !ok {
+		return Asset{}, fmt.Errorf("%w: %s", ErrUnknownAsset, symbol)
+	}
+	return a, nil
+}
+
+// Fiat returns the configuration of the currency transaction amounts are quoted in.
+func (r *Registry) Fiat() Asset {
+	return r.assets[r.fiat]
+}
+
+// Symbols returns the symbols of all configured assets in alphabetical order.
+func (r *Registry) Symbols() []string {
+	symbols := make([]string, 0, len(r.assets))
+	for symbol := range r.assets {
+		symbols = append(symbols, symbol)
+	}
+	sort.Strings(symbols)
+	return symbols
+}
+
+// Round rounds an amount of the given asset to its configured precision.
+func (r *Registry) Round(symbol string, d decimal.Decimal) (decimal.Decimal, error) {
+	a, err := r.Asset(symbol)
+	if err != nil {
+		return decimal.Decimal{}, err
+	}
+	return a.Round(d), nil
+}
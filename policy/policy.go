@@ -0,0 +1,96 @@
+// policy/policy.go
+package policy
+
+import (
+	"crypto-exchange/apperrors"
+	"crypto-exchange/models"
+)
+
+// ErrForbidden is returned when an actor's role does not permit an operation.
+var ErrForbidden = apperrors.New(apperrors.ErrForbidden, "insufficient_permissions", "forbidden")
+
+// Permission names an operation on resources of other users. Every user may
+// act on their own resources within the limits the services set.
+type Permission string
+
+// Permissions granted to staff roles.
+const (
+	// ViewAnyTransaction allows reading the transactions and history of any user.
+	ViewAnyTransaction Permission = "transactions:view_any"
+	// ForceFailTransaction allows failing a pending or processing transaction of any user.
+	ForceFailTransaction Permission = "transactions:force_fail"
+	// TransitionAnyTransaction allows any status change of any transaction.
+	TransitionAnyTransaction Permission = "transactions:transition_any"
+	// ViewUsers allows reading the accounts of other users.
+	ViewUsers Permission = "users:view"
+	// ManageUserStatus allows freezing, reactivating and closing accounts.
+	ManageUserStatus Permission = "users:manage_status"
+	// ManageRoles allows changing the role of a user.
+	ManageRoles Permission = "users:manage_roles"
+	// ViewOperations allows reading the exchange's internal state, such as the outbox lag.
+	ViewOperations Permission = "operations:view"
+)
+
+// roleSystem is the role of the System actor. It cannot be assigned to users.
+const roleSystem = "system"
+
+// rolePermissions are the permissions of each role. Admins and the system
+// have every permission; users and unknown roles have none.
+var rolePermissions = map[string][]Permission{
+	models.RoleSupport:    {ViewAnyTransaction, ViewUsers},
+	models.RoleCompliance: {ForceFailTransaction, ViewUsers, ManageUserStatus},
+}
+
+// Actor is whoever performs an operation: the authenticated user and their role.
+type Actor struct {
+	UserID uint
+	Role   string
+}
+
+// System is the actor of operations the exchange performs itself, such as
+// completing deposits confirmed on chain.
+var System = Actor{Role: roleSystem}
+
+// Can reports whether the actor's role has a permission.
+func (a Actor) Can(p Permission) bool {
+	if a.Role == models.RoleAdmin || a.Role == roleSystem {
+		return true
+	}
+	for _, granted := range rolePermissions[a.Role] {
+		if granted == p {
+			return true
+		}
+	}
+	return false
+}
+
+// Owns reports whether the actor is the given user.
+func (a Actor) Owns(userID uint) bool {
+	return a.UserID != 0 && a.UserID == userID
+}
+
+// CanViewTransaction reports whether the actor may read a transaction and its history.
+func CanViewTransaction(a Actor, tx models.Transaction) bool {
+	return a.Owns(tx.UserID) || a.Can(ViewAnyTransaction)
+}
+
+// CanListTransactions reports whether the actor may list the transactions of
+// a user, or of all users when userID is 0.
+func CanListTransactions(a Actor, userID uint) bool {
+	return a.Owns(userID) || a.Can(ViewAnyTransaction)
+}
+
+// CanTransition reports whether the actor may move a transaction to a status.
+// Owners may only cancel their transactions; whether the change is legal at
+// all is up to the state machine.
+func CanTransition(a Actor, tx models.Transaction, to string) bool {
+	switch {
+	case a.Can(TransitionAnyTransaction):
+		return true
+	case to == models.StatusFailed && a.Can(ForceFailTransaction):
+		return true
+	case to == models.StatusCancelled && a.Owns(tx.UserID):
+		return true
+	}
+	return false
+}
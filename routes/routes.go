@@ -4,14 +4,92 @@ package routes
 import (
     "github.com/gin-gonic/gin"
     "github.com/rs/zerolog"
+    "crypto-exchange/config"
     "crypto-exchange/controllers"
+    "crypto-exchange/middleware"
+    "crypto-exchange/models"
+    "crypto-exchange/policy"
+    "crypto-exchange/services"
 )
 
 // SetupRoutes initializes all the routes for the application.
-func SetupRoutes(router *gin.Engine, txController *controllers.TransactionController, logger zerolog.Logger) {
+func SetupRoutes(router *gin.Engine, tokenService *services.TokenService, idempotencyService services.IdempotencyService, rateLimiter services.RateLimiter, rateLimitConfig config.RateLimitConfig, authController *controllers.AuthController, userController *controllers.UserController, twoFactorController *controllers.TwoFactorController, apiKeyService services.APIKeyService, apiKeyController *controllers.APIKeyController, adminController *controllers.AdminController, txController *controllers.TransactionController, balanceController *controllers.BalanceController, orderController *controllers.OrderController, marketController *controllers.MarketController, outboxController *controllers.OutboxController, streamController *controllers.StreamController, healthController *controllers.HealthController, logger zerolog.Logger) {
+    // Define probe routes; they are neither authenticated nor rate limited
+    router.GET("/healthz", healthController.Liveness)
+    router.GET("/readyz", healthController.Readiness)
+
+    // Rate limits are configured per group; public routes are limited per client IP
+    rateLimit := func(group string) gin.HandlerFunc {
+        return middleware.RateLimit(rateLimiter, rateLimitConfig, group, logger)
+    }
+
+    // Define public authentication and registration routes
+    auth := router.Group("/", rateLimit("auth"))
+    auth.POST("/auth/login", authController.Login)
+    auth.POST("/auth/refresh", authController.Refresh)
+    auth.POST("/users", userController.Register)
+    auth.POST("/users/verify-email", userController.VerifyEmail)
+    auth.POST("/users/verify-email/resend", userController.ResendVerification)
+
+    // Define public market data routes
+    public := router.Group("/", rateLimit("public"))
+    public.GET("/markets", marketController.ListMarkets)
+    public.GET("/markets/:symbol/ticker", marketController.GetTicker)
+    public.GET("/markets/:symbol/history", marketController.GetHistory)
+
+    // Every other route requires an access token, or for some a signed API key
+    idempotency := middleware.Idempotency(idempotencyService, logger)
+    authenticated := rateLimit("authenticated")
+    api := router.Group("/", middleware.Authenticate(tokenService, logger), authenticated, idempotency)
+    signed := router.Group("/", middleware.AuthenticateSigned(apiKeyService, tokenService, logger), authenticated, idempotency)
+    read := middleware.RequireScope(models.APIKeyScopeRead)
+    trade := middleware.RequireScope(models.APIKeyScopeTrade)
+    withdraw := middleware.RequireScope(models.APIKeyScopeWithdraw)
+    api.POST("/auth/logout", authController.Logout)
+
     // Define transaction routes
-    router.POST("/transactions", txController.CreateTransaction)
-    router.GET("/transactions/:id", txController.GetTransaction)
+    signed.POST("/transactions", withdraw, rateLimit("transactions"), txController.CreateTransaction)
+    signed.GET("/transactions", read, txController.ListTransactions)
+    signed.GET("/transactions/:id", read, txController.GetTransaction)
+    api.POST("/transactions/:id/transitions", txController.TransitionTransaction)
+    signed.GET("/transactions/:id/transitions", read, txController.GetTransactionHistory)
+    signed.POST("/transactions/:id/step-up", withdraw, txController.StepUpWithdrawal)
+
+    // Define profile routes
+    signed.GET("/users/me", read, userController.GetProfile)
+    api.PATCH("/users/me", userController.UpdateProfile)
+    api.POST("/users/me/2fa", twoFactorController.Enroll)
+    api.POST("/users/me/2fa/enable", twoFactorController.Enable)
+    api.POST("/users/me/2fa/disable", twoFactorController.Disable)
+
+    // Define API key routes
+    api.POST("/api-keys", apiKeyController.CreateAPIKey)
+    api.GET("/api-keys", apiKeyController.ListAPIKeys)
+    api.DELETE("/api-keys/:id", apiKeyController.DeleteAPIKey)
+
+    // Define balance routes
+    signed.GET("/users/:user_id/balances", read, balanceController.GetUserBalances)
+
+    // Define order routes
+    orders := rateLimit("orders")
+    signed.POST("/orders", trade, orders, orderController.PlaceOrder)
+    signed.DELETE("/orders/:id", trade, orders, orderController.CancelOrder)
+
+    // Define streaming routes
+    signed.GET("/ws", read, streamController.Stream)
+
+    // Define staff routes; the services check the same permissions again
+    admin := api.Group("/admin")
+    viewAny := middleware.RequirePermission(policy.ViewAnyTransaction)
+    admin.GET("/transactions", viewAny, txController.ListTransactions)
+    admin.GET("/transactions/:id", viewAny, txController.GetTransaction)
+    admin.GET("/transactions/:id/transitions", viewAny, txController.GetTransactionHistory)
+    admin.POST("/transactions/:id/transitions", middleware.RequirePermission(policy.TransitionAnyTransaction), txController.TransitionTransaction)
+    admin.POST("/transactions/:id/fail", middleware.RequirePermission(policy.ForceFailTransaction), txController.ForceFailTransaction)
+    admin.GET("/users/:user_id", middleware.RequirePermission(policy.ViewUsers), adminController.GetUser)
+    admin.PUT("/users/:user_id/status", middleware.RequirePermission(policy.ManageUserStatus), adminController.SetUserStatus)
+    admin.PUT("/users/:user_id/role", middleware.RequirePermission(policy.ManageRoles), adminController.SetUserRole)
+    admin.GET("/outbox/lag", middleware.RequirePermission(policy.ViewOperations), outboxController.GetLag)
 
     // Add more routes as needed
 }
\ No newline at end of file
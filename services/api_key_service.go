@@ -0,0 +1,457 @@
+// services/api_key_service.go
+package services
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"crypto-exchange/apperrors"
+	"crypto-exchange/config"
+	"crypto-exchange/models"
+
+	"github.com/rs/zerolog"
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrAPIKeyNotFound is returned when a user has no API key with the requested ID.
+	ErrAPIKeyNotFound = apperrors.New(apperrors.ErrNotFound, "api_key_not_found", "API key not found")
+	// ErrTooManyAPIKeys is returned when a user already holds the configured number of keys.
+	ErrTooManyAPIKeys = apperrors.New(apperrors.ErrConflict, "api_key_limit_reached", "too many API keys")
+	// ErrInvalidSignature is returned for signed requests with an unknown key, a
+	// wrong signature or a timestamp outside the allowed window.
+	ErrInvalidSignature = apperrors.New(apperrors.ErrUnauthorized, "invalid_signature", "invalid request signature")
+	// ErrNonceReused is returned when a signed request is replayed.
+	ErrNonceReused = apperrors.New(apperrors.ErrUnauthorized, "nonce_reused", "nonce already used")
+	// ErrIPNotAllowed is returned when a key is used from an IP outside its allowlist.
+	ErrIPNotAllowed = apperrors.New(apperrors.ErrForbidden, "ip_not_allowed", "IP address not allowed for API key")
+)
+
+// apiKeyNonceKeyPrefix prefixes the Redis keys of used nonces.
+const apiKeyNonceKeyPrefix = "apikey:nonce:"
+
+// lastUsedResolution limits how often the last use of a key is written.
+const lastUsedResolution = time.Minute
+
+// SignedRequest is a request signed with an API key. The signature is the hex
+// HMAC-SHA256, keyed with the key's secret, of
+//
+//	timestamp + "\n" + nonce + "\n" + method + "\n" + path + "\n" + body
+//
+// where timestamp is in Unix seconds and path includes the query string.
+type SignedRequest struct {
+	KeyID     string
+	Timestamp string
+	Nonce     string
+	Signature string
+	Method    string
+	Path      string
+	Body      []byte
+	ClientIP  string
+}
+
+// payload returns the bytes a request's signature covers.
+func (r SignedRequest) payload() []byte {
+	head := strings.Join([]string{r.Timestamp, r.Nonce, r.Method, r.Path}, "\n") + "\n"
+	return append([]byte(head), r.Body...)
+}
+
+// NonceStore remembers the nonces of signed requests until their timestamps
+// fall out of the allowed window.
+type NonceStore interface {
+	// Use records a nonce of a key and reports whether it was not used before.
+	Use(keyID, nonce string, ttl time.Duration) (bool, error)
+}
+
+// APIKeyService defines the methods for managing and verifying API keys.
+type APIKeyService interface {
+	Create(userID uint, req models.CreateAPIKeyRequest) (models.CreatedAPIKey, error)
+	List(userID uint) ([]models.APIKey, error)
+	Delete(id string, userID uint) error
+	// Verify checks the signature, timestamp, nonce and client IP of a request
+	// and returns the key it was signed with.
+	Verify(req SignedRequest) (models.APIKey, error)
+}
+
+// APIKeyServiceDB stores API keys in PostgreSQL, their secrets encrypted with AES-GCM.
+type APIKeyServiceDB struct {
+	DB     *gorm.DB
+	Logger zerolog.Logger
+	Nonces NonceStore
+	Config config.UserAPIKeysConfig
+	aead   cipher.AEAD
+}
+
+// NewAPIKeyService initializes a new APIKeyServiceDB.
+func NewAPIKeyService(db *gorm.DB, logger zerolog.Logger, nonces NonceStore, cfg config.UserAPIKeysConfig) (*APIKeyServiceDB, error) {
+	key, err := base64.StdEncoding.DecodeString(cfg.EncryptionKey)
+	if err != nil || len(key) != 32 {
+		return nil, errors.New("user_api_keys.encryption_key must be a base64 encoded 32-byte key")
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &APIKeyServiceDB{
+		DB:     db,
+		Logger: logger,
+		Nonces: nonces,
+		Config: cfg,
+		aead:   aead,
+	}, nil
+}
+
+// Create issues a new API key for a user.
+func (s *APIKeyServiceDB) Create(userID uint, req models.CreateAPIKeyRequest) (models.CreatedAPIKey, error) {
+	var count int64
+	if err := s.DB.Model(&models.APIKey{}).Where("user_id = ?", userID).Count(&count).Error; err != nil {
+		s.Logger.Error().Err(err).Uint("user_id", userID).Msg("Failed to count API keys in PostgreSQL")
+		return models.CreatedAPIKey{}, err
+	}
+	if count >= int64(s.Config.MaxPerUser) {
+		return models.CreatedAPIKey{}, ErrTooManyAPIKeys
+	}
+
+	key, secret, err := newAPIKey(userID, req)
+	if err != nil {
+		return models.CreatedAPIKey{}, err
+	}
+	if key.EncryptedSecret, err = s.seal(secret); err != nil {
+		return models.CreatedAPIKey{}, err
+	}
+	if err := s.DB.Create(&key).Error; err != nil {
+		s.Logger.Error().Err(err).Uint("user_id", userID).Msg("Failed to create API key in PostgreSQL")
+		return models.CreatedAPIKey{}, err
+	}
+
+	s.Logger.Info().Uint("user_id", userID).Str("api_key_id", key.ID).Strs("scopes", key.Scopes).Msg("API key created")
+	return models.CreatedAPIKey{APIKey: key, Secret: secret}, nil
+}
+
+// List returns the API keys of a user, oldest first.
+func (s *APIKeyServiceDB) List(userID uint) ([]models.APIKey, error) {
+	keys := make([]models.APIKey, 0)
+	if err := s.DB.Where("user_id = ?", userID).Order("created_at, id").Find(&keys).Error; err != nil {
+		s.Logger.Error().Err(err).Uint("user_id", userID).Msg("Failed to list API keys from PostgreSQL")
+		return nil, err
+	}
+	return keys, nil
+}
+
+// Delete revokes an API key of a user.
+func (s *APIKeyServiceDB) Delete(id string, userID uint) error {
+	result := s.DB.Where("id = ? AND user_id = ?", id, userID).Delete(&models.APIKey{})
+	if result.Error != nil {
+		s.Logger.Error().Err(result.Error).Str("api_key_id", id).Msg("Failed to delete API key from PostgreSQL")
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrAPIKeyNotFound
+	}
+	s.Logger.Info().Uint("user_id", userID).Str("api_key_id", id).Msg("API key deleted")
+	return nil
+}
+
+// Verify checks a signed request and returns the key it was signed with.
+func (s *APIKeyServiceDB) Verify(req SignedRequest) (models.APIKey, error) {
+	now := time.Now()
+	if err := checkTimestamp(req.Timestamp, now, s.Config.TimestampWindow); err != nil {
+		return models.APIKey{}, err
+	}
+	var key models.APIKey
+	err := s.DB.First(&key, "id = ?", req.KeyID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return models.APIKey{}, fmt.Errorf("%w: unknown key", ErrInvalidSignature)
+	}
+	if err != nil {
+		s.Logger.Error().Err(err).Str("api_key_id", req.KeyID).Msg("Failed to retrieve API key from PostgreSQL")
+		return models.APIKey{}, err
+	}
+	secret, err := s.open(key.EncryptedSecret)
+	if err != nil {
+		return models.APIKey{}, err
+	}
+	if err := verifySignedRequest(key, secret, req, s.Nonces, s.Config.TimestampWindow); err != nil {
+		return models.APIKey{}, err
+	}
+
+	if key.LastUsedAt == nil || now.Sub(time.Unix(*key.LastUsedAt, 0)) >= lastUsedResolution {
+		used := now.Unix()
+		key.LastUsedAt = &used
+		if err := s.DB.Model(&key).Update("last_used_at", used).Error; err != nil {
+			s.Logger.Error().Err(err).Str("api_key_id", key.ID).Msg("Failed to record API key use")
+		}
+	}
+	return key, nil
+}
+
+// seal encrypts a secret, prefixing the ciphertext with its nonce.
+func (s *APIKeyServiceDB) seal(secret string) (string, error) {
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	return base64.StdEncoding.EncodeToString(s.aead.Seal(nonce, nonce, []byte(secret), nil)), nil
+}
+
+// open decrypts a secret sealed by seal.
+func (s *APIKeyServiceDB) open(sealed string) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil || len(data) < s.aead.NonceSize() {
+		return "", errors.New("malformed API key secret")
+	}
+	nonce, ciphertext := data[:s.aead.NonceSize()], data[s.aead.NonceSize():]
+	secret, err := s.aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt API key secret: %w", err)
+	}
+	return string(secret), nil
+}
+
+// NonceStoreRedis stores used nonces in Redis, each expiring once its
+// request's timestamp is out of the window.
+type NonceStoreRedis struct {
+	RedisService *RedisService
+}
+
+// NewNonceStore initializes a new NonceStoreRedis.
+func NewNonceStore(redisSvc *RedisService) *NonceStoreRedis {
+	return &NonceStoreRedis{RedisService: redisSvc}
+}
+
+// Use records a nonce of a key and reports whether it was not used before.
+func (s *NonceStoreRedis) Use(keyID, nonce string, ttl time.Duration) (bool, error) {
+	return s.RedisService.SetNX(context.Background(), apiKeyNonceKeyPrefix+keyID+":"+nonce, 1, ttl)
+}
+
+// MockNonceStore stores used nonces in memory.
+type MockNonceStore struct {
+	used  map[string]time.Time
+	mutex sync.Mutex
+}
+
+// NewMockNonceStore creates a new instance of MockNonceStore.
+func NewMockNonceStore() *MockNonceStore {
+	return &MockNonceStore{used: make(map[string]time.Time)}
+}
+
+// Use records a nonce of a key and reports whether it was not used before.
+func (s *MockNonceStore) Use(keyID, nonce string, ttl time.Duration) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	key := keyID + ":" + nonce
+	if expiresAt, exists := s.used[key]; exists && time.Now().Before(expiresAt) {
+		return false, nil
+	}
+	s.used[key] = time.Now().Add(ttl)
+	return true, nil
+}
+
+// MockAPIKeyService is a mock implementation of APIKeyService. Secrets are
+// kept in memory unencrypted.
+type MockAPIKeyService struct {
+	keys    map[string]models.APIKey
+	secrets map[string]string
+	nonces  NonceStore
+	config  config.UserAPIKeysConfig
+	mutex   sync.RWMutex
+}
+
+// NewMockAPIKeyService creates a new instance of MockAPIKeyService.
+func NewMockAPIKeyService(nonces NonceStore, cfg config.UserAPIKeysConfig) *MockAPIKeyService {
+	return &MockAPIKeyService{
+		keys:    make(map[string]models.APIKey),
+		secrets: make(map[string]string),
+		nonces:  nonces,
+		config:  cfg,
+	}
+}
+
+// Create issues a new API key for a user.
+func (s *MockAPIKeyService) Create(userID uint, req models.CreateAPIKeyRequest) (models.CreatedAPIKey, error) {
+	key, secret, err := newAPIKey(userID, req)
+	if err != nil {
+		return models.CreatedAPIKey{}, err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	count := 0
+	for _, k := range s.keys {
+		if k.UserID == userID {
+			count++
+		}
+	}
+	if count >= s.config.MaxPerUser {
+		return models.CreatedAPIKey{}, ErrTooManyAPIKeys
+	}
+	key.CreatedAt = time.Now().Unix()
+	s.keys[key.ID] = key
+	s.secrets[key.ID] = secret
+	return models.CreatedAPIKey{APIKey: key, Secret: secret}, nil
+}
+
+// List returns the API keys of a user, oldest first.
+func (s *MockAPIKeyService) List(userID uint) ([]models.APIKey, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	keys := make([]models.APIKey, 0)
+	for _, k := range s.keys {
+		if k.UserID == userID {
+			keys = append(keys, k)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].CreatedAt != keys[j].CreatedAt {
+			return keys[i].CreatedAt < keys[j].CreatedAt
+		}
+		return keys[i].ID < keys[j].ID
+	})
+	return keys, nil
+}
+
+// Delete revokes an API key of a user.
+func (s *MockAPIKeyService) Delete(id string, userID uint) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	key, exists := s.keys[id]
+	if !exists || key.UserID != userID {
+		return ErrAPIKeyNotFound
+	}
+	delete(s.keys, id)
+	delete(s.secrets, id)
+	return nil
+}
+
+// Verify checks a signed request and returns the key it was signed with.
+func (s *MockAPIKeyService) Verify(req SignedRequest) (models.APIKey, error) {
+	now := time.Now()
+	if err := checkTimestamp(req.Timestamp, now, s.config.TimestampWindow); err != nil {
+		return models.APIKey{}, err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	key, exists := s.keys[req.KeyID]
+	if !exists {
+		return models.APIKey{}, fmt.Errorf("%w: unknown key", ErrInvalidSignature)
+	}
+	if err := verifySignedRequest(key, s.secrets[key.ID], req, s.nonces, s.config.TimestampWindow); err != nil {
+		return models.APIKey{}, err
+	}
+	used := now.Unix()
+	key.LastUsedAt = &used
+	s.keys[key.ID] = key
+	return key, nil
+}
+
+// newAPIKey creates an API key with a random ID and secret.
+func newAPIKey(userID uint, req models.CreateAPIKeyRequest) (models.APIKey, string, error) {
+	b := make([]byte, 12+32)
+	if _, err := rand.Read(b); err != nil {
+		return models.APIKey{}, "", fmt.Errorf("failed to generate API key: %w", err)
+	}
+	allowedIPs := req.AllowedIPs
+	if allowedIPs == nil {
+		allowedIPs = []string{}
+	}
+	return models.APIKey{
+		ID:         "ak_" + hex.EncodeToString(b[:12]),
+		UserID:     userID,
+		Label:      strings.TrimSpace(req.Label),
+		Scopes:     uniqueScopes(req.Scopes),
+		AllowedIPs: allowedIPs,
+		Tier:       models.APIKeyTierStandard,
+	}, base64.RawURLEncoding.EncodeToString(b[12:]), nil
+}
+
+// uniqueScopes sorts scopes and drops duplicates.
+func uniqueScopes(scopes []string) []string {
+	sorted := append([]string(nil), scopes...)
+	sort.Strings(sorted)
+	unique := sorted[:0]
+	for i, scope := range sorted {
+		if i == 0 || scope != sorted[i-1] {
+			unique = append(unique, scope)
+		}
+	}
+	return unique
+}
+
+// checkTimestamp returns ErrInvalidSignature unless a Unix timestamp is within
+// window of now.
+func checkTimestamp(timestamp string, now time.Time, window time.Duration) error {
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%w: malformed timestamp", ErrInvalidSignature)
+	}
+	skew := now.Sub(time.Unix(ts, 0))
+	if skew > window || skew < -window {
+		return fmt.Errorf("%w: timestamp outside the allowed window", ErrInvalidSignature)
+	}
+	return nil
+}
+
+// verifySignedRequest checks the signature, client IP and nonce of a request
+// signed with a key. The nonce is used up last, so that requests failing the
+// other checks cannot burn it.
+func verifySignedRequest(key models.APIKey, secret string, req SignedRequest, nonces NonceStore, window time.Duration) error {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(req.payload())
+	signature, err := hex.DecodeString(req.Signature)
+	if err != nil || !hmac.Equal(signature, mac.Sum(nil)) {
+		return fmt.Errorf("%w: signature mismatch", ErrInvalidSignature)
+	}
+	if !ipAllowed(key.AllowedIPs, req.ClientIP) {
+		return fmt.Errorf("%w: %s", ErrIPNotAllowed, req.ClientIP)
+	}
+
+	// Timestamps are accepted up to window on either side of now
+	first, err := nonces.Use(key.ID, req.Nonce, 2*window)
+	if err != nil {
+		return err
+	}
+	if !first {
+		return ErrNonceReused
+	}
+	return nil
+}
+
+// ipAllowed reports whether an IP matches an allowlist of IPs and CIDRs. An
+// empty allowlist allows every IP.
+func ipAllowed(allowlist []string, clientIP string) bool {
+	if len(allowlist) == 0 {
+		return true
+	}
+	ip := net.ParseIP(clientIP)
+	if ip == nil {
+		return false
+	}
+	for _, entry := range allowlist {
+		if _, network, err := net.ParseCIDR(entry); err == nil {
+			if network.Contains(ip) {
+				return true
+			}
+		} else if allowed := net.ParseIP(entry); allowed != nil && allowed.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
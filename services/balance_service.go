@@ -0,0 +1,204 @@
+// services/balance_service.go
+package services
+
+import (
+	"sort"
+
+	"crypto-exchange/apperrors"
+	"crypto-exchange/ledger"
+	"crypto-exchange/models"
+
+	"github.com/rs/zerolog"
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+// ErrInsufficientFunds is returned when a withdrawal or order exceeds the available balance.
+var ErrInsufficientFunds = apperrors.New(apperrors.ErrInsufficientFunds, "insufficient_funds", "insufficient funds")
+
+// BalanceService defines the methods for reading user balances.
+type BalanceService interface {
+	GetUserBalances(userID uint) ([]models.Balance, error)
+}
+
+// BalanceServiceDB derives balances from the ledger, in-flight withdrawals and
+// open orders in PostgreSQL.
+type BalanceServiceDB struct {
+	DB     *gorm.DB
+	Logger zerolog.Logger
+	Ledger *LedgerServiceDB
+}
+
+// NewBalanceService initializes a new BalanceServiceDB.
+func NewBalanceService(db *gorm.DB, logger zerolog.Logger, ledgerSvc *LedgerServiceDB) *BalanceServiceDB {
+	return &BalanceServiceDB{
+		DB:     db,
+		Logger: logger,
+		Ledger: ledgerSvc,
+	}
+}
+
+// GetUserBalances returns the available, held and total amounts per asset for a user.
+func (s *BalanceServiceDB) GetUserBalances(userID uint) ([]models.Balance, error) {
+	return s.UserBalances(s.DB, userID)
+}
+
+// UserBalances computes a user's balances using the given DB handle.
+func (s *BalanceServiceDB) UserBalances(db *gorm.DB, userID uint) ([]models.Balance, error) {
+	settled, err := s.Ledger.UserBalances(db, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var holds []struct {
+		CryptoSymbol string
+		Held         decimal.Decimal
+	}
+	err = db.Model(&models.Transaction{}).
+		Select("crypto_symbol, SUM(crypto_amount + transaction_fee) AS held").
+		Where("user_id = ? AND type = ? AND status IN ?", userID, "withdrawal", []string{models.StatusPending, models.StatusProcessing}).
+		Group("crypto_symbol").
+		Scan(&holds).Error
+	if err != nil {
+		s.Logger.Error().Err(err).Uint("user_id", userID).Msg("Failed to sum pending withdrawals")
+		return nil, err
+	}
+
+	// Open orders hold the quote asset for buys and the base asset for sells
+	var orderHolds []struct {
+		CryptoSymbol string
+		Held         decimal.Decimal
+	}
+	err = db.Model(&models.Order{}).
+		Select("CASE WHEN side = ? THEN split_part(pair, '-', 2) ELSE split_part(pair, '-', 1) END AS crypto_symbol, "+
+			"SUM(CASE WHEN side = ? THEN price * (quantity - filled_quantity) ELSE quantity - filled_quantity END) AS held",
+			models.SideBuy, models.SideBuy).
+		Where("user_id = ? AND status IN ?", userID, []string{models.OrderStatusOpen, models.OrderStatusPartiallyFilled}).
+		Group("1").
+		Scan(&orderHolds).Error
+	if err != nil {
+		s.Logger.Error().Err(err).Uint("user_id", userID).Msg("Failed to sum open orders")
+		return nil, err
+	}
+
+	held := make(map[string]decimal.Decimal, len(holds)+len(orderHolds))
+	for _, h := range append(holds, orderHolds...) {
+		held[h.CryptoSymbol] = held[h.CryptoSymbol].Add(h.Held)
+	}
+	return buildBalances(settled, held), nil
+}
+
+// ReserveFunds locks the user's account for the withdrawal's asset and checks that
+// its available balance covers the amount plus fee. It must run inside the DB
+// transaction that writes the withdrawal so concurrent withdrawals cannot double-spend.
+func (s *BalanceServiceDB) ReserveFunds(db *gorm.DB, tx models.Transaction) error {
+	if err := s.Ledger.LockAccount(db, ledger.UserAccountID(tx.UserID, tx.CryptoSymbol)); err != nil {
+		return err
+	}
+	balances, err := s.UserBalances(db, tx.UserID)
+	if err != nil {
+		return err
+	}
+	return checkAvailable(balances, tx)
+}
+
+// ReserveAmount locks a user's account for an asset and checks that its
+// available balance covers amount, like ReserveFunds does for withdrawals.
+func (s *BalanceServiceDB) ReserveAmount(db *gorm.DB, userID uint, asset string, amount decimal.Decimal) error {
+	if err := s.Ledger.LockAccount(db, ledger.UserAccountID(userID, asset)); err != nil {
+		return err
+	}
+	balances, err := s.UserBalances(db, userID)
+	if err != nil {
+		return err
+	}
+	return checkAvailableAmount(balances, asset, amount)
+}
+
+// MockBalanceService derives balances from the in-memory mock services.
+type MockBalanceService struct {
+	transactions *MockTransactionService
+}
+
+// NewMockBalanceService creates a new instance of MockBalanceService.
+func NewMockBalanceService(txService *MockTransactionService) BalanceService {
+	return &MockBalanceService{
+		transactions: txService,
+	}
+}
+
+// GetUserBalances returns the available, held and total amounts per asset for a user.
+func (s *MockBalanceService) GetUserBalances(userID uint) ([]models.Balance, error) {
+	s.transactions.mutex.RLock()
+	defer s.transactions.mutex.RUnlock()
+	return s.transactions.userBalances(userID)
+}
+
+// buildBalances combines settled ledger balances with the amounts held by
+// in-flight withdrawals and open orders.
+func buildBalances(settled []models.LedgerBalance, held map[string]decimal.Decimal) []models.Balance {
+	totals := make(map[string]decimal.Decimal, len(settled))
+	for _, b := range settled {
+		totals[b.Asset] = totals[b.Asset].Add(b.Balance)
+	}
+	for asset := range held {
+		if _, ok := totals[asset]; !ok {
+			totals[asset] = decimal.Zero
+		}
+	}
+
+	balances := make([]models.Balance, 0, len(totals))
+	for asset, total := range totals {
+		balances = append(balances, models.Balance{
+			CryptoSymbol: asset,
+			Available:    total.Sub(held[asset]),
+			Held:         held[asset],
+			Total:        total,
+		})
+	}
+	sort.Slice(balances, func(i, j int) bool { return balances[i].CryptoSymbol < balances[j].CryptoSymbol })
+	return balances
+}
+
+// reservesFunds reports whether a new transaction must be covered by the available balance.
+func reservesFunds(tx models.Transaction) bool {
+	return tx.Type == "withdrawal" && (models.IsHolding(tx.Status) || tx.Status == models.StatusCompleted)
+}
+
+// checkAvailable returns ErrInsufficientFunds if a withdrawal is not covered by the
+// available balance of its asset.
+func checkAvailable(balances []models.Balance, tx models.Transaction) error {
+	return checkAvailableAmount(balances, tx.CryptoSymbol, tx.CryptoAmount.Add(tx.TransactionFee))
+}
+
+// reversalDebit returns what reversing a transaction takes from its user's
+// account, e.g. a reversed deposit, or zero if the reversal credits the user.
+func reversalDebit(tx models.Transaction, entry models.JournalEntry) decimal.Decimal {
+	if tx.Status != models.StatusReversed {
+		return decimal.Zero
+	}
+	account := ledger.UserAccountID(tx.UserID, tx.CryptoSymbol)
+	net := decimal.Zero
+	for _, p := range entry.Postings {
+		if p.AccountID == account {
+			net = net.Add(p.Amount)
+		}
+	}
+	return decimal.Max(net.Neg(), decimal.Zero)
+}
+
+// checkAvailableAmount returns ErrInsufficientFunds if amount is not covered by
+// the available balance of asset.
+func checkAvailableAmount(balances []models.Balance, asset string, amount decimal.Decimal) error {
+	available := decimal.Zero
+	for _, b := range balances {
+		if b.CryptoSymbol == asset {
+			available = b.Available
+			break
+		}
+	}
+	if available.LessThan(amount) {
+		return ErrInsufficientFunds
+	}
+	return nil
+}
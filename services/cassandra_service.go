@@ -2,12 +2,52 @@
 package services
 
 import (
+	"context"
 	"crypto-exchange/config"
+	"crypto-exchange/metrics"
+	"crypto-exchange/models"
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/gocql/gocql"
+	"github.com/shopspring/decimal"
+	"gopkg.in/inf.v0"
 )
 
+// historySchema creates the transaction history read model. Each partition
+// holds one month of a user's transactions, newest first, so that a page of a
+// user's history is a slice of one or a few partitions. The months index lists
+// the months in which a user has transactions, newest first.
+var historySchema = []string{`
+	CREATE TABLE IF NOT EXISTS transaction_history (
+		user_id bigint,
+		month text,
+		created_at timestamp,
+		id text,
+		type text,
+		status text,
+		amount decimal,
+		crypto_type text,
+		transaction_id text,
+		crypto_amount decimal,
+		crypto_symbol text,
+		transaction_fee decimal,
+		updated_at timestamp,
+		step_up_at timestamp,
+		PRIMARY KEY ((user_id, month), created_at, id)
+	) WITH CLUSTERING ORDER BY (created_at DESC, id DESC)
+`, `
+	CREATE TABLE IF NOT EXISTS transaction_history_months (
+		user_id bigint,
+		month text,
+		PRIMARY KEY (user_id, month)
+	) WITH CLUSTERING ORDER BY (month DESC)
+`}
+
+// historyMonthLayout formats the month of a history partition.
+const historyMonthLayout = "2006-01"
+
 // CassandraService encapsulates the Cassandra session.
 type CassandraService struct {
 	Session *gocql.Session
@@ -19,24 +59,19 @@ func NewCassandraService(cfg config.CassandraConfig) (*CassandraService, error)
 	cluster.Port = cfg.Port
 	cluster.Keyspace = cfg.Keyspace
 	cluster.Consistency = gocql.Quorum
+	cluster.QueryObserver = cassandraMetricsObserver{}
 
 	session, err := cluster.CreateSession()
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to Cassandra: %v", err)
 	}
 
-	// Create the "transactions" table if it doesn't exist.
-	err = session.Query(`
-		CREATE TABLE IF NOT EXISTS transactions (
-			id text PRIMARY KEY,
-			amount double,
-			type text,
-			status text
-		)
-	`).Exec()
-	if err != nil {
-		session.Close()
-		return nil, fmt.Errorf("failed to create transactions table: %v", err)
+	// Create the transaction history read model if it doesn't exist
+	for _, stmt := range historySchema {
+		if err := session.Query(stmt).Exec(); err != nil {
+			session.Close()
+			return nil, fmt.Errorf("failed to create transaction history tables: %v", err)
+		}
 	}
 
 	return &CassandraService{
@@ -49,19 +84,154 @@ func (c *CassandraService) Close() {
 	c.Session.Close()
 }
 
-// InsertTransaction inserts a new transaction into Cassandra.
-func (c *CassandraService) InsertTransaction(tx Transaction) error {
-	return c.Session.Query(`
-		INSERT INTO transactions (id, amount, type, status)
-		VALUES (?, ?, ?, ?)
-	`, tx.ID, tx.Amount, tx.Type, tx.Status).Exec()
+// cassandraMetricsObserver observes the latency and errors of every query
+// attempt, by statement kind (select, insert, ...).
+type cassandraMetricsObserver struct{}
+
+// ObserveQuery observes one attempt of a query.
+func (cassandraMetricsObserver) ObserveQuery(ctx context.Context, q gocql.ObservedQuery) {
+	operation := "query"
+	if fields := strings.Fields(q.Statement); len(fields) > 0 {
+		operation = strings.ToLower(fields[0])
+	}
+	metrics.ObserveDependency("cassandra", operation, q.End.Sub(q.Start), q.Err)
+}
+
+// Ping checks that Cassandra answers queries.
+func (c *CassandraService) Ping(ctx context.Context) error {
+	return c.Session.Query(`SELECT release_version FROM system.local`).WithContext(ctx).Exec()
 }
 
-// GetTransaction retrieves a transaction by ID from Cassandra.
-func (c *CassandraService) GetTransaction(id string) (Transaction, error) {
-	var tx Transaction
+// UpsertHistory writes the state of a transaction to the history read model.
+// The write is timestamped with version, the time of the event it projects,
+// so that a replayed older event does not overwrite a newer state.
+func (c *CassandraService) UpsertHistory(ctx context.Context, tx models.Transaction, version time.Time) error {
+	month := historyMonth(tx.CreatedAt)
+	// Index the month first, so that readers never miss a written row
 	err := c.Session.Query(`
-		SELECT id, amount, type, status FROM transactions WHERE id = ?
-	`, id).Consistency(gocql.One).Scan(&tx.ID, &tx.Amount, &tx.Type, &tx.Status)
-	return tx, err
-}
\ No newline at end of file
+		INSERT INTO transaction_history_months (user_id, month) VALUES (?, ?)
+	`, int64(tx.UserID), month).WithContext(ctx).Exec()
+	if err != nil {
+		return err
+	}
+
+	var stepUpAt *time.Time
+	if tx.StepUpAt != nil {
+		t := time.Unix(*tx.StepUpAt, 0)
+		stepUpAt = &t
+	}
+	return c.Session.Query(`
+		INSERT INTO transaction_history (user_id, month, created_at, id, type, status, amount, crypto_type,
+			transaction_id, crypto_amount, crypto_symbol, transaction_fee, updated_at, step_up_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		USING TIMESTAMP ?
+	`, int64(tx.UserID), month, time.Unix(tx.CreatedAt, 0), tx.ID, tx.Type, tx.Status, toCQLDecimal(tx.Amount), tx.CryptoType,
+		tx.TransactionID, toCQLDecimal(tx.CryptoAmount), tx.CryptoSymbol, toCQLDecimal(tx.TransactionFee),
+		time.Unix(tx.UpdatedAt, 0), stepUpAt, version.UnixMicro()).WithContext(ctx).Exec()
+}
+
+// GetUserHistory returns a page of a user's transactions from the history read
+// model, newest first. The months of the user are read newest first until the
+// page is full; filters other than the creation time are applied as rows are
+// read.
+func (c *CassandraService) GetUserHistory(ctx context.Context, q listQuery) (models.TransactionPage, error) {
+	// Rows from (from, "") included up to (to, toID) excluded, in clustering order
+	from := time.Unix(q.CreatedFrom, 0)
+	var to *time.Time
+	var toID string
+	switch {
+	case q.after != nil:
+		t := time.Unix(q.after.CreatedAt, 0)
+		to, toID = &t, q.after.ID
+	case q.CreatedTo != 0:
+		t := time.Unix(q.CreatedTo, 0)
+		to = &t
+	}
+
+	lastMonth := "9999-12"
+	if to != nil {
+		lastMonth = to.UTC().Format(historyMonthLayout)
+	}
+	var months []string
+	iter := c.Session.Query(`
+		SELECT month FROM transaction_history_months WHERE user_id = ? AND month >= ? AND month <= ?
+	`, int64(q.UserID), historyMonth(q.CreatedFrom), lastMonth).WithContext(ctx).Iter()
+	var month string
+	for iter.Scan(&month) {
+		months = append(months, month)
+	}
+	if err := iter.Close(); err != nil {
+		return models.TransactionPage{}, err
+	}
+
+	stmt := `
+		SELECT id, created_at, type, status, amount, crypto_type, transaction_id, crypto_amount,
+			crypto_symbol, transaction_fee, updated_at, step_up_at
+		FROM transaction_history WHERE user_id = ? AND month = ? AND (created_at, id) >= (?, ?)`
+	if to != nil {
+		stmt += ` AND (created_at, id) < (?, ?)`
+	}
+
+	// Fetch one extra row to know whether there is a next page
+	txs := make([]models.Transaction, 0, q.limit+1)
+	for _, month := range months {
+		args := []interface{}{int64(q.UserID), month, from, ""}
+		if to != nil {
+			args = append(args, *to, toID)
+		}
+		iter := c.Session.Query(stmt, args...).WithContext(ctx).PageSize(q.limit + 1).Iter()
+		for len(txs) <= q.limit {
+			tx, ok := scanHistory(iter)
+			if !ok {
+				break
+			}
+			tx.UserID = q.UserID
+			if q.matches(tx) {
+				txs = append(txs, tx)
+			}
+		}
+		if err := iter.Close(); err != nil {
+			return models.TransactionPage{}, err
+		}
+		if len(txs) > q.limit {
+			break
+		}
+	}
+	return newPage(txs, q.limit), nil
+}
+
+// scanHistory reads the next transaction_history row of iter.
+func scanHistory(iter *gocql.Iter) (models.Transaction, bool) {
+	var tx models.Transaction
+	var createdAt, updatedAt, stepUpAt time.Time
+	var amount, cryptoAmount, fee inf.Dec
+	if !iter.Scan(&tx.ID, &createdAt, &tx.Type, &tx.Status, &amount, &tx.CryptoType, &tx.TransactionID,
+		&cryptoAmount, &tx.CryptoSymbol, &fee, &updatedAt, &stepUpAt) {
+		return models.Transaction{}, false
+	}
+	tx.CreatedAt = createdAt.Unix()
+	tx.UpdatedAt = updatedAt.Unix()
+	if !stepUpAt.IsZero() {
+		ts := stepUpAt.Unix()
+		tx.StepUpAt = &ts
+	}
+	tx.Amount = fromCQLDecimal(&amount)
+	tx.CryptoAmount = fromCQLDecimal(&cryptoAmount)
+	tx.TransactionFee = fromCQLDecimal(&fee)
+	return tx, true
+}
+
+// historyMonth returns the month partition of a creation time in Unix seconds.
+func historyMonth(createdAt int64) string {
+	return time.Unix(createdAt, 0).UTC().Format(historyMonthLayout)
+}
+
+// toCQLDecimal converts an exact decimal into the type gocql uses for CQL decimals.
+func toCQLDecimal(d decimal.Decimal) *inf.Dec {
+	return inf.NewDecBig(d.Coefficient(), inf.Scale(-d.Exponent()))
+}
+
+// fromCQLDecimal converts a CQL decimal back into an exact decimal.
+func fromCQLDecimal(d *inf.Dec) decimal.Decimal {
+	return decimal.NewFromBigInt(d.UnscaledBig(), -int32(d.Scale()))
+}
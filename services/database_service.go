@@ -2,10 +2,14 @@
 package services
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"time"
 
 	"crypto-exchange/models"
 	"crypto-exchange/config"
+	"crypto-exchange/metrics"
 
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
@@ -33,8 +37,26 @@ func NewDatabaseService(cfg config.DatabaseConfig) (*DatabaseService, error) {
 		if err != nil {
 			return nil, err
 		}
-		// Automigrate the Transaction model to ensure the table exists.
-		if err := db.AutoMigrate(&models.Transaction{}); err != nil {
+		// Automigrate the models to ensure the tables exist.
+		if err := db.AutoMigrate(
+			&models.Transaction{},
+			&models.TransactionTransition{},
+			&models.LedgerAccount{},
+			&models.JournalEntry{},
+			&models.Posting{},
+			&models.IdempotencyRecord{},
+			&models.OutboxMessage{},
+			&models.Order{},
+			&models.Trade{},
+			&models.User{},
+			&models.EmailVerification{},
+			&models.TwoFactor{},
+			&models.RecoveryCode{},
+			&models.APIKey{},
+		); err != nil {
+			return nil, err
+		}
+		if err := instrumentGORM(db); err != nil {
 			return nil, err
 		}
 		return &DatabaseService{DB: db}, nil
@@ -44,4 +66,65 @@ func NewDatabaseService(cfg config.DatabaseConfig) (*DatabaseService, error) {
 	default:
 		return nil, fmt.Errorf("unsupported database type: %s", cfg.Type)
 	}
+}
+
+// Close terminates the database connection pool.
+func (d *DatabaseService) Close() error {
+	sqlDB, err := d.DB.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.Close()
+}
+
+// Ping checks that the database is reachable.
+func (d *DatabaseService) Ping(ctx context.Context) error {
+	sqlDB, err := d.DB.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.PingContext(ctx)
+}
+
+// gormStartKey holds the start time of a statement in its GORM instance.
+const gormStartKey = "metrics:start"
+
+// instrumentGORM registers callbacks that observe the latency and errors of
+// every statement GORM runs, by operation.
+func instrumentGORM(db *gorm.DB) error {
+	cb := db.Callback()
+	operations := []struct {
+		name          string
+		before, after func(string, func(*gorm.DB)) error
+	}{
+		{"create", cb.Create().Before("*").Register, cb.Create().After("*").Register},
+		{"query", cb.Query().Before("*").Register, cb.Query().After("*").Register},
+		{"update", cb.Update().Before("*").Register, cb.Update().After("*").Register},
+		{"delete", cb.Delete().Before("*").Register, cb.Delete().After("*").Register},
+		{"row", cb.Row().Before("*").Register, cb.Row().After("*").Register},
+		{"raw", cb.Raw().Before("*").Register, cb.Raw().After("*").Register},
+	}
+	for _, op := range operations {
+		name := op.name
+		if err := op.before("metrics:before_"+name, func(db *gorm.DB) {
+			db.InstanceSet(gormStartKey, time.Now())
+		}); err != nil {
+			return err
+		}
+		if err := op.after("metrics:after_"+name, func(db *gorm.DB) {
+			start, ok := db.InstanceGet(gormStartKey)
+			if !ok {
+				return
+			}
+			// A missing record is an answer, not a failure of the database
+			err := db.Error
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				err = nil
+			}
+			metrics.ObserveDependency("postgres", name, time.Since(start.(time.Time)), err)
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
 }
\ No newline at end of file
@@ -0,0 +1,77 @@
+// services/deposit_handler.go
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"crypto-exchange/events"
+	"crypto-exchange/logging"
+	"crypto-exchange/models"
+	"crypto-exchange/policy"
+
+	"github.com/rs/zerolog"
+)
+
+// NewDepositConfirmedHandler returns the handler of deposit.confirmed events,
+// which completes the pending deposit confirmed by the chain watchers.
+// Confirmations of already completed deposits are ignored.
+func NewDepositConfirmedHandler(txService TransactionService, logger zerolog.Logger) EventHandler {
+	return func(ctx context.Context, env events.Envelope) error {
+		var confirmation events.DepositConfirmation
+		if err := json.Unmarshal(env.Payload, &confirmation); err != nil {
+			return fmt.Errorf("%w: %v", ErrNonRetryable, err)
+		}
+
+		// The deposit may not be visible yet, so a missing transaction is retried
+		tx, err := txService.GetTransactionByID(ctx, policy.System, confirmation.TransactionID)
+		if err != nil {
+			return err
+		}
+		if err := matchDeposit(tx, confirmation); err != nil {
+			return err
+		}
+
+		log := logging.FromContext(ctx, logger)
+		reason := fmt.Sprintf("confirmed on chain in %s (%d confirmations)", confirmation.TxHash, confirmation.Confirmations)
+		switch tx.Status {
+		case models.StatusCompleted:
+			log.Debug().Str("transaction_id", tx.ID).Msg("Deposit already completed")
+			return nil
+		case models.StatusPending:
+			if _, err := txService.TransitionTransaction(ctx, policy.System, tx.ID, models.StatusProcessing, reason); err != nil && !errors.Is(err, ErrInvalidTransition) {
+				return err
+			}
+		case models.StatusProcessing:
+		default:
+			return fmt.Errorf("%w: deposit %s is %s", ErrNonRetryable, tx.ID, tx.Status)
+		}
+
+		if _, err := txService.TransitionTransaction(ctx, policy.System, tx.ID, models.StatusCompleted, reason); err != nil {
+			if errors.Is(err, ErrInvalidTransition) {
+				return fmt.Errorf("%w: %v", ErrNonRetryable, err)
+			}
+			return err
+		}
+		log.Info().Str("transaction_id", tx.ID).Str("tx_hash", confirmation.TxHash).Msg("Deposit confirmed")
+		return nil
+	}
+}
+
+// matchDeposit checks that a confirmation describes the given deposit.
+func matchDeposit(tx models.Transaction, confirmation events.DepositConfirmation) error {
+	switch {
+	case tx.Type != "deposit":
+		return fmt.Errorf("%w: transaction %s is not a deposit", ErrNonRetryable, tx.ID)
+	case tx.UserID != confirmation.UserID:
+		return fmt.Errorf("%w: deposit %s belongs to another user", ErrNonRetryable, tx.ID)
+	case !strings.EqualFold(tx.CryptoSymbol, confirmation.CryptoSymbol):
+		return fmt.Errorf("%w: deposit %s is in %s, not %s", ErrNonRetryable, tx.ID, tx.CryptoSymbol, confirmation.CryptoSymbol)
+	case !tx.CryptoAmount.Equal(confirmation.CryptoAmount):
+		return fmt.Errorf("%w: deposit %s is for %s, not %s", ErrNonRetryable, tx.ID, tx.CryptoAmount, confirmation.CryptoAmount)
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+// services/health_service.go
+package services
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"crypto-exchange/config"
+	"crypto-exchange/models"
+
+	"github.com/rs/zerolog"
+)
+
+// HealthService reports the health of the service's dependencies.
+type HealthService interface {
+	Check(ctx context.Context) models.HealthReport
+}
+
+// healthCheck pings one dependency.
+type healthCheck struct {
+	name     string
+	critical bool
+	ping     func(ctx context.Context) error
+}
+
+// DependencyHealthService pings the registered dependencies concurrently,
+// each with its own timeout. Changes of a dependency's status are logged.
+type DependencyHealthService struct {
+	Logger   zerolog.Logger
+	Config   config.HealthConfig
+	checks   []healthCheck
+	last     models.HealthReport
+	lastAt   time.Time
+	statuses map[string]string // last logged status, by dependency
+	mutex    sync.Mutex
+}
+
+// NewHealthService initializes a new DependencyHealthService.
+func NewHealthService(logger zerolog.Logger, cfg config.HealthConfig) *DependencyHealthService {
+	return &DependencyHealthService{
+		Logger:   logger,
+		Config:   cfg,
+		statuses: make(map[string]string),
+	}
+}
+
+// Register adds a dependency check. The dependency is critical if the
+// configuration names it so. It must be called before Check.
+func (s *DependencyHealthService) Register(name string, ping func(ctx context.Context) error) {
+	critical := false
+	for _, c := range s.Config.Critical {
+		if c == name {
+			critical = true
+		}
+	}
+	s.checks = append(s.checks, healthCheck{name: name, critical: critical, ping: ping})
+}
+
+// Check pings every dependency, unless they were checked within the cache TTL.
+func (s *DependencyHealthService) Check(ctx context.Context) models.HealthReport {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if !s.lastAt.IsZero() && time.Since(s.lastAt) < s.Config.CacheTTL {
+		return s.last
+	}
+
+	results := make([]models.DependencyHealth, len(s.checks))
+	var wg sync.WaitGroup
+	for i, check := range s.checks {
+		wg.Add(1)
+		go func(i int, check healthCheck) {
+			defer wg.Done()
+			results[i] = s.ping(ctx, check)
+		}(i, check)
+	}
+	wg.Wait()
+
+	report := models.HealthReport{
+		Status:       models.HealthUp,
+		CheckedAt:    time.Now().UnixMilli(),
+		Dependencies: results,
+	}
+	for _, result := range results {
+		s.logChange(result)
+		switch {
+		case result.Status == models.HealthUp:
+		case result.Critical:
+			report.Status = models.HealthDown
+		case report.Status == models.HealthUp:
+			report.Status = models.HealthDegraded
+		}
+	}
+	s.last, s.lastAt = report, time.Now()
+	return report
+}
+
+// ping checks one dependency within the configured timeout.
+func (s *DependencyHealthService) ping(ctx context.Context, check healthCheck) models.DependencyHealth {
+	ctx, cancel := context.WithTimeout(ctx, s.Config.Timeout)
+	defer cancel()
+
+	start := time.Now()
+	err := check.ping(ctx)
+	result := models.DependencyHealth{
+		Name:      check.name,
+		Status:    models.HealthUp,
+		Critical:  check.critical,
+		LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+	}
+	if err != nil {
+		result.Status = models.HealthDown
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// logChange logs a dependency going down or coming back. The caller must hold s.mutex.
+func (s *DependencyHealthService) logChange(result models.DependencyHealth) {
+	previous, seen := s.statuses[result.Name]
+	s.statuses[result.Name] = result.Status
+	if previous == result.Status || (!seen && result.Status == models.HealthUp) {
+		return
+	}
+	if result.Status == models.HealthUp {
+		s.Logger.Info().Str("dependency", result.Name).Msg("Dependency recovered")
+		return
+	}
+	log := s.Logger.Warn()
+	if result.Critical {
+		log = s.Logger.Error()
+	}
+	log.Str("dependency", result.Name).
+		Str("error", result.Error).
+		Bool("critical", result.Critical).
+		Msg("Dependency is down")
+}
@@ -0,0 +1,204 @@
+// services/idempotency_service.go
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"crypto-exchange/config"
+	"crypto-exchange/models"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/rs/zerolog"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// IdempotencyService stores the responses of requests sent with an Idempotency-Key.
+type IdempotencyService interface {
+	// Reserve locks a key for a new request. If the key is already known, the
+	// existing record is returned and nothing is locked.
+	Reserve(key, fingerprint string) (*models.IdempotencyRecord, error)
+	// Complete stores the response of a reserved key.
+	Complete(record models.IdempotencyRecord) error
+	// Release unlocks a reserved key so that the request can be retried.
+	Release(key string) error
+}
+
+// IdempotencyServiceRedis keeps idempotency records in Redis and falls back to
+// PostgreSQL when Redis is unavailable.
+type IdempotencyServiceRedis struct {
+	DB           *gorm.DB
+	Logger       zerolog.Logger
+	RedisService *RedisService
+	TTL          time.Duration
+	LockTimeout  time.Duration
+}
+
+// NewIdempotencyService initializes a new IdempotencyServiceRedis.
+func NewIdempotencyService(db *gorm.DB, logger zerolog.Logger, redisSvc *RedisService, cfg config.IdempotencyConfig) *IdempotencyServiceRedis {
+	return &IdempotencyServiceRedis{
+		DB:           db,
+		Logger:       logger,
+		RedisService: redisSvc,
+		TTL:          cfg.TTL,
+		LockTimeout:  cfg.LockTimeout,
+	}
+}
+
+// Reserve locks a key for a new request or returns its existing record.
+func (s *IdempotencyServiceRedis) Reserve(key, fingerprint string) (*models.IdempotencyRecord, error) {
+	ctx := context.Background()
+	now := time.Now()
+	record := models.IdempotencyRecord{
+		Key:         key,
+		Fingerprint: fingerprint,
+		CreatedAt:   now.Unix(),
+		ExpiresAt:   now.Add(s.LockTimeout).Unix(),
+	}
+
+	// A record written to PostgreSQL while Redis was down still counts
+	existing, err := s.getFromDB(key)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return existing, nil
+	}
+
+	recordJSON, err := json.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+	reserved, err := s.RedisService.SetNX(ctx, redisIdempotencyKey(key), recordJSON, s.LockTimeout)
+	if err != nil {
+		s.Logger.Warn().Err(err).Str("idempotency_key", key).Msg("Redis unavailable, reserving idempotency key in PostgreSQL")
+		return s.reserveInDB(record)
+	}
+	if reserved {
+		return nil, nil
+	}
+
+	cached, err := s.RedisService.Get(ctx, redisIdempotencyKey(key))
+	if errors.Is(err, redis.Nil) {
+		// The lock expired between SETNX and GET; let the client retry
+		return &record, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(cached), &record); err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+// Complete stores the response of a reserved key.
+func (s *IdempotencyServiceRedis) Complete(record models.IdempotencyRecord) error {
+	record.ExpiresAt = time.Now().Add(s.TTL).Unix()
+	recordJSON, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if err := s.RedisService.Set(context.Background(), redisIdempotencyKey(record.Key), recordJSON, s.TTL); err != nil {
+		s.Logger.Warn().Err(err).Str("idempotency_key", record.Key).Msg("Redis unavailable, storing idempotent response in PostgreSQL")
+		return s.DB.Save(&record).Error
+	}
+	// Drop any reservation made in PostgreSQL during a Redis outage
+	return s.DB.Delete(&models.IdempotencyRecord{}, "key = ?", record.Key).Error
+}
+
+// Release unlocks a reserved key so that the request can be retried.
+func (s *IdempotencyServiceRedis) Release(key string) error {
+	if err := s.RedisService.Delete(context.Background(), redisIdempotencyKey(key)); err != nil {
+		s.Logger.Warn().Err(err).Str("idempotency_key", key).Msg("Failed to release idempotency key in Redis")
+	}
+	return s.DB.Delete(&models.IdempotencyRecord{}, "key = ?", key).Error
+}
+
+// getFromDB returns the unexpired record of a key stored in PostgreSQL, if any.
+func (s *IdempotencyServiceRedis) getFromDB(key string) (*models.IdempotencyRecord, error) {
+	var record models.IdempotencyRecord
+	err := s.DB.Where("key = ? AND expires_at > ?", key, time.Now().Unix()).First(&record).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		s.Logger.Error().Err(err).Str("idempotency_key", key).Msg("Failed to read idempotency record from PostgreSQL")
+		return nil, err
+	}
+	return &record, nil
+}
+
+// reserveInDB locks a key in PostgreSQL, replacing an expired record.
+func (s *IdempotencyServiceRedis) reserveInDB(record models.IdempotencyRecord) (*models.IdempotencyRecord, error) {
+	if err := s.DB.Where("key = ? AND expires_at <= ?", record.Key, time.Now().Unix()).Delete(&models.IdempotencyRecord{}).Error; err != nil {
+		return nil, err
+	}
+	result := s.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(&record)
+	if result.Error != nil {
+		s.Logger.Error().Err(result.Error).Str("idempotency_key", record.Key).Msg("Failed to reserve idempotency key in PostgreSQL")
+		return nil, result.Error
+	}
+	if result.RowsAffected == 1 {
+		return nil, nil
+	}
+	return s.getFromDB(record.Key)
+}
+
+// redisIdempotencyKey namespaces idempotency keys in Redis.
+func redisIdempotencyKey(key string) string {
+	return "idempotency:" + key
+}
+
+// MockIdempotencyService is an in-memory implementation of IdempotencyService.
+type MockIdempotencyService struct {
+	records map[string]models.IdempotencyRecord
+	ttl     time.Duration
+	mutex   sync.Mutex
+}
+
+// NewMockIdempotencyService creates a new instance of MockIdempotencyService.
+func NewMockIdempotencyService(cfg config.IdempotencyConfig) IdempotencyService {
+	return &MockIdempotencyService{
+		records: make(map[string]models.IdempotencyRecord),
+		ttl:     cfg.TTL,
+	}
+}
+
+// Reserve locks a key for a new request or returns its existing record.
+func (s *MockIdempotencyService) Reserve(key, fingerprint string) (*models.IdempotencyRecord, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if record, exists := s.records[key]; exists && record.ExpiresAt > time.Now().Unix() {
+		return &record, nil
+	}
+	now := time.Now()
+	s.records[key] = models.IdempotencyRecord{
+		Key:         key,
+		Fingerprint: fingerprint,
+		CreatedAt:   now.Unix(),
+		ExpiresAt:   now.Add(s.ttl).Unix(),
+	}
+	return nil, nil
+}
+
+// Complete stores the response of a reserved key.
+func (s *MockIdempotencyService) Complete(record models.IdempotencyRecord) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	record.ExpiresAt = time.Now().Add(s.ttl).Unix()
+	s.records[record.Key] = record
+	return nil
+}
+
+// Release unlocks a reserved key so that the request can be retried.
+func (s *MockIdempotencyService) Release(key string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.records, key)
+	return nil
+}
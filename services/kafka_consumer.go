@@ -0,0 +1,312 @@
+// services/kafka_consumer.go
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"crypto-exchange/config"
+	"crypto-exchange/events"
+	"crypto-exchange/tracing"
+
+	"github.com/rs/zerolog"
+	"github.com/segmentio/kafka-go"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ErrNonRetryable marks handler errors that retrying cannot fix. Such events go
+// straight to the dead-letter topic.
+var ErrNonRetryable = errors.New("non-retryable event")
+
+// Headers added to events forwarded to a retry or dead-letter topic.
+const (
+	RetryAttemptHeader  = "x-retry-attempt"
+	OriginalTopicHeader = "x-original-topic"
+	ErrorHeader         = "x-error"
+)
+
+// consumerBackoff is the delay before retrying a failed read or forward.
+const consumerBackoff = time.Second
+
+// EventHandler processes one inbound event. Handlers must be idempotent, since
+// an event is delivered again if the consumer stops before committing it.
+type EventHandler func(ctx context.Context, env events.Envelope) error
+
+// KafkaConsumer reads inbound events as part of a consumer group and dispatches
+// them to the handler registered for their type. Offsets are committed only once
+// an event was handled or forwarded to a retry or dead-letter topic.
+//
+// Without a GroupID, the consumer reads every partition of its topics itself
+// and commits nothing, so that each process sees every event; partitions
+// added later are only read after a restart.
+type KafkaConsumer struct {
+	Logger      zerolog.Logger
+	Events      *events.Registry
+	Brokers     []string
+	GroupID     string
+	Topics      []string
+	RetryDelays []time.Duration
+	StartOffset int64 // where a new consumer group, or a consumer without one, starts reading
+	Writer      *kafka.Writer
+	handlers    map[string]EventHandler
+}
+
+// NewKafkaConsumer initializes a new KafkaConsumer.
+func NewKafkaConsumer(cfg config.KafkaConfig, logger zerolog.Logger, eventRegistry *events.Registry) *KafkaConsumer {
+	return &KafkaConsumer{
+		Logger:      logger,
+		Events:      eventRegistry,
+		Brokers:     cfg.Brokers,
+		GroupID:     cfg.Consumer.GroupID,
+		Topics:      cfg.Consumer.Topics,
+		RetryDelays: cfg.Consumer.RetryDelays,
+		StartOffset: kafka.FirstOffset,
+		Writer: &kafka.Writer{
+			Addr:         kafka.TCP(cfg.Brokers...),
+			Balancer:     &kafka.Hash{},
+			RequiredAcks: kafka.RequireAll,
+		},
+		handlers: make(map[string]EventHandler),
+	}
+}
+
+// Handle registers the handler of an event type. It must be called before Run.
+func (c *KafkaConsumer) Handle(eventType string, handler EventHandler) {
+	c.handlers[eventType] = handler
+}
+
+// Run consumes the configured topics and their retry topics until the context
+// is cancelled. Events being handled at that point are handled to the end and
+// committed before Run returns.
+func (c *KafkaConsumer) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, topic := range c.Topics {
+		for stage := 0; stage <= len(c.RetryDelays); stage++ {
+			for _, rc := range c.readerConfigs(ctx, retryTopic(topic, stage)) {
+				wg.Add(1)
+				go func(topic string, stage int, rc kafka.ReaderConfig) {
+					defer wg.Done()
+					c.consume(ctx, topic, stage, rc)
+				}(topic, stage, rc)
+			}
+		}
+	}
+	c.Logger.Info().Strs("topics", c.Topics).Str("group_id", c.GroupID).Msg("Kafka consumer started")
+	wg.Wait()
+	c.Logger.Info().Msg("Kafka consumer stopped")
+}
+
+// Close terminates the writer used for retry and dead-letter topics.
+func (c *KafkaConsumer) Close() error {
+	return c.Writer.Close()
+}
+
+// readerConfigs returns the readers of one stage of a topic: one for the
+// consumer group, or one per partition without a group. It returns nothing
+// if the context is cancelled while the partitions are looked up.
+func (c *KafkaConsumer) readerConfigs(ctx context.Context, source string) []kafka.ReaderConfig {
+	if c.GroupID != "" {
+		return []kafka.ReaderConfig{{
+			Brokers:     c.Brokers,
+			GroupID:     c.GroupID,
+			Topic:       source,
+			StartOffset: c.StartOffset,
+			// Offsets are committed explicitly after each event
+			CommitInterval: 0,
+		}}
+	}
+
+	for {
+		partitions, err := c.partitions(ctx, source)
+		if err == nil {
+			configs := make([]kafka.ReaderConfig, len(partitions))
+			for i, partition := range partitions {
+				configs[i] = kafka.ReaderConfig{Brokers: c.Brokers, Topic: source, Partition: partition}
+			}
+			return configs
+		}
+		c.Logger.Error().Err(err).Str("topic", source).Msg("Failed to look up Kafka partitions")
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(consumerBackoff):
+		}
+	}
+}
+
+// partitions returns the partition IDs of a topic, asking each broker in turn.
+func (c *KafkaConsumer) partitions(ctx context.Context, topic string) ([]int, error) {
+	err := errors.New("no Kafka brokers configured")
+	for _, broker := range c.Brokers {
+		var conn *kafka.Conn
+		conn, err = kafka.DialContext(ctx, "tcp", broker)
+		if err != nil {
+			continue
+		}
+		var partitions []kafka.Partition
+		partitions, err = conn.ReadPartitions(topic)
+		conn.Close()
+		if err != nil {
+			continue
+		}
+		ids := make([]int, len(partitions))
+		for i, p := range partitions {
+			ids[i] = p.ID
+		}
+		return ids, nil
+	}
+	return nil, err
+}
+
+// consume reads one stage of a topic: stage 0 is the topic itself and stage n
+// is its n-th retry topic, whose events are held back by the n-th retry delay.
+func (c *KafkaConsumer) consume(ctx context.Context, topic string, stage int, rc kafka.ReaderConfig) {
+	source := retryTopic(topic, stage)
+	reader := kafka.NewReader(rc)
+	defer reader.Close()
+	if rc.GroupID == "" {
+		// A reader without a group starts at the oldest event unless told otherwise
+		if err := reader.SetOffset(c.StartOffset); err != nil {
+			c.Logger.Error().Err(err).Str("topic", source).Int("partition", rc.Partition).Msg("Failed to set Kafka offset")
+			return
+		}
+	}
+
+	for {
+		msg, err := reader.FetchMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			c.Logger.Error().Err(err).Str("topic", source).Msg("Failed to fetch Kafka message")
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(consumerBackoff):
+			}
+			continue
+		}
+
+		// Retry topics are written in time order, so waiting for the head blocks nothing that is due
+		if stage > 0 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Until(msg.Time.Add(c.RetryDelays[stage-1]))):
+			}
+		}
+
+		if err := c.process(ctx, topic, stage, msg); err != nil {
+			// Only a cancelled context stops forwarding; the event is read again after a restart
+			return
+		}
+		if rc.GroupID == "" {
+			continue
+		}
+		// A handled event is committed even while stopping, so that it is not handled again
+		if err := reader.CommitMessages(context.WithoutCancel(ctx), msg); err != nil {
+			c.Logger.Error().Err(err).Str("topic", source).Int64("offset", msg.Offset).Msg("Failed to commit Kafka offset")
+		}
+	}
+}
+
+// process handles an event and forwards it to the next retry topic or to the
+// dead-letter topic if that fails. It only returns an error if the event could
+// not be handled nor forwarded.
+func (c *KafkaConsumer) process(ctx context.Context, topic string, stage int, msg kafka.Message) error {
+	// Continue the trace of the change that published the event, if any
+	ctx, span := tracing.Start(tracing.ExtractKafka(ctx, msg), msg.Topic+" process",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			semconv.MessagingSystemKafka,
+			semconv.MessagingOperationKey.String("process"),
+			semconv.MessagingDestinationName(msg.Topic),
+			attribute.Int("messaging.kafka.attempt", stage+1),
+		),
+	)
+	var failure error
+	defer func() { tracing.End(span, failure) }()
+
+	env, err := events.Decode(msg.Value)
+	if err == nil {
+		err = c.Events.Validate(env)
+	}
+	if err != nil {
+		failure = err
+		c.Logger.Error().Err(err).Str("topic", msg.Topic).Int64("offset", msg.Offset).Msg("Invalid inbound event")
+		return c.forward(ctx, deadLetterTopic(topic), topic, stage, msg, err)
+	}
+
+	span.SetAttributes(attribute.String("messaging.event_type", env.Type), attribute.String("messaging.message.id", env.EventID))
+	handler, ok := c.handlers[env.Type]
+	if !ok {
+		c.Logger.Debug().Str("event_type", env.Type).Str("event_id", env.EventID).Msg("No handler for event, skipping")
+		return nil
+	}
+
+	// Handlers finish the event they are given when the consumer is stopped
+	err = handler(context.WithoutCancel(ctx), env)
+	if err == nil {
+		return nil
+	}
+	failure = err
+
+	log := c.Logger.Warn().Err(err).
+		Str("event_type", env.Type).
+		Str("event_id", env.EventID).
+		Int("attempt", stage+1)
+	if errors.Is(err, ErrNonRetryable) || stage >= len(c.RetryDelays) {
+		log.Msg("Event handling failed, moving it to the dead-letter topic")
+		return c.forward(ctx, deadLetterTopic(topic), topic, stage, msg, err)
+	}
+	log.Dur("retry_in", c.RetryDelays[stage]).Msg("Event handling failed, scheduling a retry")
+	return c.forward(ctx, retryTopic(topic, stage+1), topic, stage, msg, err)
+}
+
+// forward writes a failed event to another topic, retrying until it succeeds
+// or the context is cancelled. The event stays in the trace it was handled in.
+func (c *KafkaConsumer) forward(ctx context.Context, dest, topic string, stage int, msg kafka.Message, cause error) error {
+	out := kafka.Message{
+		Topic: dest,
+		Key:   msg.Key,
+		Value: msg.Value,
+		Time:  time.Now(),
+		Headers: []kafka.Header{
+			{Key: RetryAttemptHeader, Value: []byte(strconv.Itoa(stage + 1))},
+			{Key: OriginalTopicHeader, Value: []byte(topic)},
+			{Key: ErrorHeader, Value: []byte(cause.Error())},
+		},
+	}
+	tracing.InjectKafka(ctx, &out)
+	for {
+		err := c.Writer.WriteMessages(ctx, out)
+		if err == nil {
+			return nil
+		}
+		c.Logger.Error().Err(err).Str("topic", dest).Msg("Failed to forward event")
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(consumerBackoff):
+		}
+	}
+}
+
+// retryTopic returns the topic of a retry stage; stage 0 is the topic itself.
+func retryTopic(topic string, stage int) string {
+	if stage == 0 {
+		return topic
+	}
+	return fmt.Sprintf("%s.retry.%d", topic, stage)
+}
+
+// deadLetterTopic returns the topic receiving the events of topic that could not be handled.
+func deadLetterTopic(topic string) string {
+	return topic + ".dlq"
+}
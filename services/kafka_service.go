@@ -3,45 +3,82 @@ package services
 
 import (
 	"context"
+	"encoding/json"
 	"time"
 
 	"crypto-exchange/config"
+	"crypto-exchange/events"
+	"crypto-exchange/metrics"
+	"crypto-exchange/tracing"
 
 	"github.com/segmentio/kafka-go"
 )
 
 // KafkaService encapsulates the Kafka writer.
 type KafkaService struct {
-	Writer *kafka.Writer
-	Topic  string
+	Writer  *kafka.Writer
+	Topic   string
+	Brokers []string
 }
 
-// NewKafkaService initializes the KafkaService.
+// NewKafkaService initializes the KafkaService. Messages are partitioned by
+// the hash of their key, so that the events of a key stay ordered.
 func NewKafkaService(cfg config.KafkaConfig) *KafkaService {
 	writer := kafka.NewWriter(kafka.WriterConfig{
 		Brokers:  cfg.Brokers,
-		Topic:    cfg.Topics
-		Balancer: &kafka.LeastBytes{},
+		Topic:    cfg.Topic,
+		Balancer: &kafka.Hash{},
 	})
 
 	return &KafkaService{
-		Writer: writer,
-		Topic:  cfg.Topic,
+		Writer:  writer,
+		Topic:   cfg.Topic,
+		Brokers: cfg.Brokers,
 	}
 }
 
-// Publish sends a message to the Kafka topic.
-func (k *KafkaService) Publish(message string) error {
-	return k.Writer.WriteMessages(context.Background(),
-		kafka.Message{
-			Time:  time.Now(),
-			Key:   []byte("transaction"),
-			Value: []byte(message),
-		},
-	)
+// Publish sends an event to the Kafka topic, keyed by the envelope's key. The
+// trace context of ctx is written to the message headers.
+func (k *KafkaService) Publish(ctx context.Context, env events.Envelope) error {
+	value, err := json.Marshal(env)
+	if err != nil {
+		return err
+	}
+	msg := kafka.Message{
+		Time:  time.Now(),
+		Key:   []byte(env.Key),
+		Value: value,
+	}
+	tracing.InjectKafka(ctx, &msg)
+	start := time.Now()
+	err = k.Writer.WriteMessages(ctx, msg)
+	metrics.ObserveDependency("kafka", "publish", time.Since(start), err)
+	return err
+}
+
+// PublishBatch sends several keyed messages to the Kafka topic in one write.
+// On partial failure the returned error is a kafka.WriteErrors indexed like messages.
+func (k *KafkaService) PublishBatch(ctx context.Context, messages []kafka.Message) error {
+	start := time.Now()
+	err := k.Writer.WriteMessages(ctx, messages...)
+	metrics.ObserveDependency("kafka", "publish_batch", time.Since(start), err)
+	return err
+}
+
+// Ping checks that at least one of the brokers accepts connections.
+func (k *KafkaService) Ping(ctx context.Context) error {
+	var err error
+	for _, broker := range k.Brokers {
+		var conn *kafka.Conn
+		conn, err = kafka.DialContext(ctx, "tcp", broker)
+		if err == nil {
+			return conn.Close()
+		}
+	}
+	return err
 }
 
 // Close terminates the Kafka writer.
 func (k *KafkaService) Close() error {
 	return k.Writer.Close()
-}
\ No newline at end of file
+}
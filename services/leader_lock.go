@@ -0,0 +1,93 @@
+// services/leader_lock.go
+package services
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+
+	"github.com/rs/zerolog"
+	"gorm.io/gorm"
+)
+
+// LeaderLock is a PostgreSQL session advisory lock held on a dedicated
+// connection. Only one node at a time holds a key; the lock is released when
+// its holder releases it or its connection ends, so that a standby can take over.
+type LeaderLock struct {
+	DB     *gorm.DB
+	Key    int64
+	Logger zerolog.Logger
+	conn   *sql.Conn // holds the lock; nil while it is not held
+	mutex  sync.Mutex
+}
+
+// NewLeaderLock initializes a LeaderLock on a key.
+func NewLeaderLock(db *gorm.DB, logger zerolog.Logger, key int64) *LeaderLock {
+	return &LeaderLock{
+		DB:     db,
+		Key:    key,
+		Logger: logger,
+	}
+}
+
+// TryAcquire takes the lock unless another node holds it.
+func (l *LeaderLock) TryAcquire(ctx context.Context) (bool, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.conn != nil {
+		return true, nil
+	}
+
+	sqlDB, err := l.DB.DB()
+	if err != nil {
+		return false, err
+	}
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		return false, err
+	}
+	var acquired bool
+	if err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", l.Key).Scan(&acquired); err != nil {
+		conn.Close()
+		return false, err
+	}
+	if !acquired {
+		conn.Close()
+		return false, nil
+	}
+	l.conn = conn
+	return true, nil
+}
+
+// Held reports whether the lock is still held, that is whether the connection
+// holding it is alive. A dead connection is dropped, as its lock is gone.
+func (l *LeaderLock) Held(ctx context.Context) bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.conn == nil {
+		return false
+	}
+	if err := l.conn.PingContext(ctx); err != nil {
+		l.Logger.Warn().Err(err).Int64("lock_key", l.Key).Msg("Lost the connection holding a leader lock")
+		l.conn.Close()
+		l.conn = nil
+		return false
+	}
+	return true
+}
+
+// Release unlocks the lock, if held, and returns its connection to the pool.
+func (l *LeaderLock) Release() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.conn == nil {
+		return nil
+	}
+	_, err := l.conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", l.Key)
+	closeErr := l.conn.Close()
+	l.conn = nil
+	if err != nil {
+		return err
+	}
+	return closeErr
+}
@@ -0,0 +1,215 @@
+// services/ledger_service.go
+package services
+
+import (
+	"errors"
+	"sort"
+	"sync"
+
+	"crypto-exchange/ledger"
+	"crypto-exchange/models"
+
+	"github.com/rs/zerolog"
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// LedgerService defines the read side of the double-entry ledger.
+type LedgerService interface {
+	GetEntriesByTransaction(transactionID string) ([]models.JournalEntry, error)
+	GetAccountBalance(accountID string) (models.LedgerBalance, error)
+	GetUserBalances(userID uint) ([]models.LedgerBalance, error)
+}
+
+// LedgerServiceDB stores journal entries and postings in PostgreSQL.
+type LedgerServiceDB struct {
+	DB     *gorm.DB
+	Logger zerolog.Logger
+}
+
+// NewLedgerService initializes a new LedgerServiceDB.
+func NewLedgerService(db *gorm.DB, logger zerolog.Logger) *LedgerServiceDB {
+	return &LedgerServiceDB{
+		DB:     db,
+		Logger: logger,
+	}
+}
+
+// RecordEntry validates and stores a journal entry using the given DB handle,
+// so that callers can write it in the same transaction as the business row.
+func (s *LedgerServiceDB) RecordEntry(db *gorm.DB, entry models.JournalEntry) error {
+	if err := ledger.Validate(entry); err != nil {
+		return err
+	}
+
+	// Make sure every account touched by the entry exists
+	for _, p := range entry.Postings {
+		account, err := ledger.ParseAccountID(p.AccountID)
+		if err != nil {
+			return err
+		}
+		if err := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&account).Error; err != nil {
+			s.Logger.Error().Err(err).Str("account_id", account.ID).Msg("Failed to create ledger account")
+			return err
+		}
+	}
+
+	if err := db.Create(&entry).Error; err != nil {
+		s.Logger.Error().Err(err).Str("entry_id", entry.ID).Msg("Failed to create journal entry")
+		return err
+	}
+	return nil
+}
+
+// LockAccount creates the account if needed and locks its row until the
+// surrounding DB transaction ends, serializing writers of the same account.
+func (s *LedgerServiceDB) LockAccount(db *gorm.DB, accountID string) error {
+	account, err := ledger.ParseAccountID(accountID)
+	if err != nil {
+		return err
+	}
+	if err := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&account).Error; err != nil {
+		s.Logger.Error().Err(err).Str("account_id", accountID).Msg("Failed to create ledger account")
+		return err
+	}
+	if err := db.Clauses(clause.Locking{Strength: "UPDATE"}).First(&account, "id = ?", accountID).Error; err != nil {
+		s.Logger.Error().Err(err).Str("account_id", accountID).Msg("Failed to lock ledger account")
+		return err
+	}
+	return nil
+}
+
+// GetEntriesByTransaction returns the journal entries recorded for a transaction.
+func (s *LedgerServiceDB) GetEntriesByTransaction(transactionID string) ([]models.JournalEntry, error) {
+	var entries []models.JournalEntry
+	err := s.DB.Preload("Postings").
+		Where("transaction_id = ?", transactionID).
+		Order("created_at, id").
+		Find(&entries).Error
+	if err != nil {
+		s.Logger.Error().Err(err).Str("transaction_id", transactionID).Msg("Failed to retrieve journal entries")
+		return nil, err
+	}
+	return entries, nil
+}
+
+// GetAccountBalance sums the postings of a single account.
+func (s *LedgerServiceDB) GetAccountBalance(accountID string) (models.LedgerBalance, error) {
+	account, err := ledger.ParseAccountID(accountID)
+	if err != nil {
+		return models.LedgerBalance{}, err
+	}
+
+	balance := models.LedgerBalance{AccountID: account.ID, Asset: account.Asset}
+	err = s.DB.Model(&models.Posting{}).
+		Select("COALESCE(SUM(amount), 0)").
+		Where("account_id = ?", account.ID).
+		Scan(&balance.Balance).Error
+	if err != nil {
+		s.Logger.Error().Err(err).Str("account_id", accountID).Msg("Failed to sum ledger postings")
+		return models.LedgerBalance{}, err
+	}
+	return balance, nil
+}
+
+// GetUserBalances sums the postings of every account owned by a user.
+func (s *LedgerServiceDB) GetUserBalances(userID uint) ([]models.LedgerBalance, error) {
+	return s.UserBalances(s.DB, userID)
+}
+
+// UserBalances sums a user's postings using the given DB handle.
+func (s *LedgerServiceDB) UserBalances(db *gorm.DB, userID uint) ([]models.LedgerBalance, error) {
+	var balances []models.LedgerBalance
+	err := db.Model(&models.Posting{}).
+		Select("postings.account_id, postings.asset, SUM(postings.amount) AS balance").
+		Joins("JOIN ledger_accounts ON ledger_accounts.id = postings.account_id").
+		Where("ledger_accounts.kind = ? AND ledger_accounts.user_id = ?", models.AccountKindUser, userID).
+		Group("postings.account_id, postings.asset").
+		Order("postings.asset").
+		Scan(&balances).Error
+	if err != nil {
+		s.Logger.Error().Err(err).Uint("user_id", userID).Msg("Failed to sum user ledger postings")
+		return nil, err
+	}
+	return balances, nil
+}
+
+// MockLedgerService is an in-memory implementation of LedgerService.
+type MockLedgerService struct {
+	entries  map[string]models.JournalEntry
+	byTx     map[string][]string
+	balances map[string]decimal.Decimal
+	mutex    sync.RWMutex
+}
+
+// NewMockLedgerService creates a new instance of MockLedgerService.
+func NewMockLedgerService() *MockLedgerService {
+	return &MockLedgerService{
+		entries:  make(map[string]models.JournalEntry),
+		byTx:     make(map[string][]string),
+		balances: make(map[string]decimal.Decimal),
+	}
+}
+
+// RecordEntry validates and stores a journal entry in memory.
+func (s *MockLedgerService) RecordEntry(entry models.JournalEntry) error {
+	if err := ledger.Validate(entry); err != nil {
+		return err
+	}
+	for _, p := range entry.Postings {
+		if _, err := ledger.ParseAccountID(p.AccountID); err != nil {
+			return err
+		}
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, exists := s.entries[entry.ID]; exists {
+		return errors.New("journal entry already exists")
+	}
+	s.entries[entry.ID] = entry
+	s.byTx[entry.TransactionID] = append(s.byTx[entry.TransactionID], entry.ID)
+	for _, p := range entry.Postings {
+		s.balances[p.AccountID] = s.balances[p.AccountID].Add(p.Amount)
+	}
+	return nil
+}
+
+// GetEntriesByTransaction returns the journal entries recorded for a transaction.
+func (s *MockLedgerService) GetEntriesByTransaction(transactionID string) ([]models.JournalEntry, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	entries := make([]models.JournalEntry, 0, len(s.byTx[transactionID]))
+	for _, id := range s.byTx[transactionID] {
+		entries = append(entries, s.entries[id])
+	}
+	return entries, nil
+}
+
+// GetAccountBalance sums the postings of a single account.
+func (s *MockLedgerService) GetAccountBalance(accountID string) (models.LedgerBalance, error) {
+	account, err := ledger.ParseAccountID(accountID)
+	if err != nil {
+		return models.LedgerBalance{}, err
+	}
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return models.LedgerBalance{AccountID: account.ID, Asset: account.Asset, Balance: s.balances[account.ID]}, nil
+}
+
+// GetUserBalances sums the postings of every account owned by a user.
+func (s *MockLedgerService) GetUserBalances(userID uint) ([]models.LedgerBalance, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	balances := []models.LedgerBalance{}
+	for accountID, balance := range s.balances {
+		account, err := ledger.ParseAccountID(accountID)
+		if err != nil || account.Kind != models.AccountKindUser || account.UserID != userID {
+			continue
+		}
+		balances = append(balances, models.LedgerBalance{AccountID: accountID, Asset: account.Asset, Balance: balance})
+	}
+	sort.Slice(balances, func(i, j int) bool { return balances[i].Asset < balances[j].Asset })
+	return balances, nil
+}
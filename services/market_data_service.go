@@ -0,0 +1,493 @@
+// services/market_data_service.go
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"crypto-exchange/apperrors"
+	"crypto-exchange/config"
+	"crypto-exchange/models"
+	"crypto-exchange/money"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/rs/zerolog"
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrMarketNotFound is returned for symbols that are not traded.
+	ErrMarketNotFound = apperrors.New(apperrors.ErrNotFound, "market_not_found", "market not found")
+	// ErrMarketDataUnavailable is returned when no recent snapshot exists.
+	ErrMarketDataUnavailable = apperrors.New(apperrors.ErrUnavailable, "market_data_unavailable", "market data unavailable")
+)
+
+// Redis keys of the market data snapshots.
+const (
+	marketRefreshLockKey   = "market:refresh"
+	marketListKey          = "market:list"
+	marketHistoryKeyPrefix = "market:history:"
+)
+
+// marketSnapshotTTLFactor is how many refresh intervals a snapshot is served
+// for; older snapshots are dropped rather than served stale.
+const marketSnapshotTTLFactor = 10
+
+// MarketDataService defines the methods for reading public market data.
+type MarketDataService interface {
+	ListMarkets() (models.MarketList, error)
+	GetTicker(symbol string) (models.Ticker, error)
+	GetHistory(symbol string) (models.PriceHistory, error)
+}
+
+// MarketDataServiceRedis serves market data from snapshots in Redis. Every API
+// node runs the refresher, but only one of them rebuilds the snapshots from
+// PostgreSQL per refresh interval.
+type MarketDataServiceRedis struct {
+	DB           *gorm.DB
+	Logger       zerolog.Logger
+	RedisService *RedisService
+	Assets       *money.Registry
+	Config       config.MarketDataConfig
+}
+
+// NewMarketDataService initializes a new MarketDataServiceRedis.
+func NewMarketDataService(db *gorm.DB, logger zerolog.Logger, redisSvc *RedisService, assets *money.Registry, cfg config.MarketDataConfig) *MarketDataServiceRedis {
+	return &MarketDataServiceRedis{
+		DB:           db,
+		Logger:       logger,
+		RedisService: redisSvc,
+		Assets:       assets,
+		Config:       cfg,
+	}
+}
+
+// Run refreshes the snapshots until the context is cancelled.
+func (s *MarketDataServiceRedis) Run(ctx context.Context) {
+	s.Logger.Info().Dur("refresh_interval", s.Config.RefreshInterval).Msg("Market data refresher started")
+	ticker := time.NewTicker(s.Config.RefreshInterval)
+	defer ticker.Stop()
+	for {
+		if err := s.refreshIfLeader(ctx); err != nil {
+			s.Logger.Error().Err(err).Msg("Failed to refresh market data")
+		}
+		select {
+		case <-ctx.Done():
+			s.Logger.Info().Msg("Market data refresher stopped")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// refreshIfLeader rebuilds the snapshots unless another node did so during the
+// current refresh interval.
+func (s *MarketDataServiceRedis) refreshIfLeader(ctx context.Context) error {
+	leader, err := s.RedisService.SetNX(ctx, marketRefreshLockKey, time.Now().Unix(), s.Config.RefreshInterval)
+	if err != nil || !leader {
+		return err
+	}
+	return s.Refresh(ctx)
+}
+
+// Refresh rebuilds the snapshots from the completed transactions in PostgreSQL.
+// The transactions are aggregated into candles and 24h statistics by the
+// database, so that only one row per candle and market is loaded.
+func (s *MarketDataServiceRedis) Refresh(ctx context.Context) error {
+	now := time.Now()
+	db := s.DB.WithContext(ctx)
+
+	var candles []marketCandle
+	err := db.Raw(`
+		SELECT crypto_symbol,
+			created_at - created_at % ? AS start_time,
+			(array_agg(amount / crypto_amount ORDER BY created_at, id))[1] AS open,
+			MAX(amount / crypto_amount) AS high,
+			MIN(amount / crypto_amount) AS low,
+			(array_agg(amount / crypto_amount ORDER BY created_at DESC, id DESC))[1] AS close,
+			SUM(crypto_amount) AS volume,
+			SUM(amount) AS quote_volume,
+			COUNT(*) AS count
+		FROM transactions
+		WHERE status = ? AND created_at >= ?
+		GROUP BY crypto_symbol, start_time
+		ORDER BY crypto_symbol, start_time
+	`, candleSeconds(s.Config), models.StatusCompleted, now.Add(-s.Config.HistoryWindow).Unix()).Scan(&candles).Error
+	if err != nil {
+		return fmt.Errorf("failed to aggregate candles: %w", err)
+	}
+
+	var stats []marketStats
+	err = db.Raw(`
+		SELECT crypto_symbol,
+			MAX(amount / crypto_amount) AS high,
+			MIN(amount / crypto_amount) AS low,
+			SUM(crypto_amount) AS volume,
+			SUM(amount) AS quote_volume,
+			COUNT(*) AS count
+		FROM transactions
+		WHERE status = ? AND created_at >= ?
+		GROUP BY crypto_symbol
+	`, models.StatusCompleted, now.Add(-24*time.Hour).Unix()).Scan(&stats).Error
+	if err != nil {
+		return fmt.Errorf("failed to aggregate 24h statistics: %w", err)
+	}
+
+	var latest []models.Transaction
+	err = db.Model(&models.Transaction{}).
+		Select("DISTINCT ON (crypto_symbol) id, crypto_symbol, amount, crypto_amount, created_at").
+		Where("status = ?", models.StatusCompleted).
+		Order("crypto_symbol, created_at DESC, id DESC").
+		Find(&latest).Error
+	if err != nil {
+		return fmt.Errorf("failed to load latest transactions: %w", err)
+	}
+
+	snapshot := buildMarketSnapshot(s.Assets, s.Config, now, candles, stats, latest)
+	ttl := marketSnapshotTTLFactor * s.Config.RefreshInterval
+	for symbol, history := range snapshot.history {
+		if err := s.writeSnapshot(ctx, marketHistoryKeyPrefix+symbol, history, ttl); err != nil {
+			return err
+		}
+	}
+	if err := s.writeSnapshot(ctx, marketListKey, snapshot.list, ttl); err != nil {
+		return err
+	}
+	s.Logger.Debug().Int("candles", len(candles)).Msg("Refreshed market data")
+	return nil
+}
+
+// ListMarkets returns the ticker of every market.
+func (s *MarketDataServiceRedis) ListMarkets() (models.MarketList, error) {
+	var list models.MarketList
+	err := s.readSnapshot(marketListKey, &list)
+	return list, err
+}
+
+// GetTicker returns the ticker of one market.
+func (s *MarketDataServiceRedis) GetTicker(symbol string) (models.Ticker, error) {
+	if !isMarket(s.Assets, strings.ToUpper(symbol)) {
+		return models.Ticker{}, ErrMarketNotFound
+	}
+	list, err := s.ListMarkets()
+	if err != nil {
+		return models.Ticker{}, err
+	}
+	return findTicker(list, symbol)
+}
+
+// GetHistory returns the price history of one market.
+func (s *MarketDataServiceRedis) GetHistory(symbol string) (models.PriceHistory, error) {
+	symbol = strings.ToUpper(symbol)
+	if !isMarket(s.Assets, symbol) {
+		return models.PriceHistory{}, ErrMarketNotFound
+	}
+	var history models.PriceHistory
+	err := s.readSnapshot(marketHistoryKeyPrefix+symbol, &history)
+	return history, err
+}
+
+// writeSnapshot stores a snapshot as JSON in Redis.
+func (s *MarketDataServiceRedis) writeSnapshot(ctx context.Context, key string, v interface{}, ttl time.Duration) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if err := s.RedisService.Set(ctx, key, data, ttl); err != nil {
+		return fmt.Errorf("failed to store %s: %w", key, err)
+	}
+	return nil
+}
+
+// readSnapshot loads a snapshot from Redis.
+func (s *MarketDataServiceRedis) readSnapshot(key string, v interface{}) error {
+	data, err := s.RedisService.Get(context.Background(), key)
+	if errors.Is(err, redis.Nil) {
+		return ErrMarketDataUnavailable
+	}
+	if err != nil {
+		s.Logger.Error().Err(err).Str("key", key).Msg("Failed to read market data from Redis")
+		return err
+	}
+	return json.Unmarshal([]byte(data), v)
+}
+
+// MockMarketDataService computes market data from the mock transaction store on every call.
+type MockMarketDataService struct {
+	transactions *MockTransactionService
+	assets       *money.Registry
+	config       config.MarketDataConfig
+}
+
+// NewMockMarketDataService creates a new instance of MockMarketDataService.
+func NewMockMarketDataService(txService *MockTransactionService, assets *money.Registry, cfg config.MarketDataConfig) MarketDataService {
+	return &MockMarketDataService{
+		transactions: txService,
+		assets:       assets,
+		config:       cfg,
+	}
+}
+
+// ListMarkets returns the ticker of every market.
+func (s *MockMarketDataService) ListMarkets() (models.MarketList, error) {
+	return s.snapshot().list, nil
+}
+
+// GetTicker returns the ticker of one market.
+func (s *MockMarketDataService) GetTicker(symbol string) (models.Ticker, error) {
+	return findTicker(s.snapshot().list, symbol)
+}
+
+// GetHistory returns the price history of one market.
+func (s *MockMarketDataService) GetHistory(symbol string) (models.PriceHistory, error) {
+	history, ok := s.snapshot().history[strings.ToUpper(symbol)]
+	if !ok {
+		return models.PriceHistory{}, ErrMarketNotFound
+	}
+	return history, nil
+}
+
+// snapshot aggregates the completed transactions in the mock store.
+func (s *MockMarketDataService) snapshot() marketSnapshot {
+	now := time.Now()
+	from := now.Add(-s.config.HistoryWindow).Unix()
+
+	s.transactions.mutex.RLock()
+	var txs []models.Transaction
+	latest := make(map[string]models.Transaction)
+	for _, tx := range s.transactions.transactions {
+		if tx.Status != models.StatusCompleted {
+			continue
+		}
+		if tx.CreatedAt >= from {
+			txs = append(txs, tx)
+		}
+		if last, ok := latest[tx.CryptoSymbol]; !ok || tx.CreatedAt > last.CreatedAt || (tx.CreatedAt == last.CreatedAt && tx.ID > last.ID) {
+			latest[tx.CryptoSymbol] = tx
+		}
+	}
+	s.transactions.mutex.RUnlock()
+
+	sort.Slice(txs, func(i, j int) bool {
+		if txs[i].CreatedAt != txs[j].CreatedAt {
+			return txs[i].CreatedAt < txs[j].CreatedAt
+		}
+		return txs[i].ID < txs[j].ID
+	})
+	last := make([]models.Transaction, 0, len(latest))
+	for _, tx := range latest {
+		last = append(last, tx)
+	}
+	candles, stats := aggregateMarket(txs, candleSeconds(s.config), now.Add(-24*time.Hour).Unix())
+	return buildMarketSnapshot(s.assets, s.config, now, candles, stats, last)
+}
+
+// marketSnapshot is the market data built at one point in time.
+type marketSnapshot struct {
+	list    models.MarketList
+	history map[string]models.PriceHistory
+}
+
+// marketCandle aggregates the completed transactions of a symbol in one candle
+// interval. Prices are not rounded yet.
+type marketCandle struct {
+	CryptoSymbol string
+	StartTime    int64
+	Open         decimal.Decimal
+	High         decimal.Decimal
+	Low          decimal.Decimal
+	Close        decimal.Decimal
+	Volume       decimal.Decimal
+	QuoteVolume  decimal.Decimal
+	Count        int64
+}
+
+// marketStats aggregates the completed transactions of a symbol in the last
+// 24 hours. Prices are not rounded yet.
+type marketStats struct {
+	CryptoSymbol string
+	High         decimal.Decimal
+	Low          decimal.Decimal
+	Volume       decimal.Decimal
+	QuoteVolume  decimal.Decimal
+	Count        int64
+}
+
+// candleSeconds returns the candle interval in seconds, at least 1.
+func candleSeconds(cfg config.MarketDataConfig) int64 {
+	// The configuration is validated, but a zero interval must not divide by zero
+	interval := int64(cfg.CandleInterval / time.Second)
+	if interval < 1 {
+		interval = 1
+	}
+	return interval
+}
+
+// aggregateMarket aggregates completed transactions, oldest first, the way
+// MarketDataServiceRedis.Refresh does in SQL: into candles of interval seconds
+// ordered by symbol and start time, and into the statistics of the
+// transactions created at or after dayAgo.
+func aggregateMarket(txs []models.Transaction, interval, dayAgo int64) ([]marketCandle, []marketStats) {
+	candles := make(map[string][]marketCandle)
+	stats := make(map[string]*marketStats)
+	for _, tx := range txs {
+		price := tx.Amount.Div(tx.CryptoAmount)
+
+		if tx.CreatedAt >= dayAgo {
+			st, ok := stats[tx.CryptoSymbol]
+			if !ok {
+				st = &marketStats{CryptoSymbol: tx.CryptoSymbol, High: price, Low: price}
+				stats[tx.CryptoSymbol] = st
+			}
+			st.High = decimal.Max(st.High, price)
+			st.Low = decimal.Min(st.Low, price)
+			st.Volume = st.Volume.Add(tx.CryptoAmount)
+			st.QuoteVolume = st.QuoteVolume.Add(tx.Amount)
+			st.Count++
+		}
+
+		start := tx.CreatedAt - tx.CreatedAt%interval
+		symbolCandles := candles[tx.CryptoSymbol]
+		n := len(symbolCandles)
+		if n == 0 || symbolCandles[n-1].StartTime != start {
+			symbolCandles = append(symbolCandles, marketCandle{CryptoSymbol: tx.CryptoSymbol, StartTime: start, Open: price, High: price, Low: price})
+			n++
+		}
+		c := &symbolCandles[n-1]
+		c.High = decimal.Max(c.High, price)
+		c.Low = decimal.Min(c.Low, price)
+		c.Close = price
+		c.Volume = c.Volume.Add(tx.CryptoAmount)
+		c.QuoteVolume = c.QuoteVolume.Add(tx.Amount)
+		c.Count++
+		candles[tx.CryptoSymbol] = symbolCandles
+	}
+
+	symbols := make([]string, 0, len(candles))
+	for symbol := range candles {
+		symbols = append(symbols, symbol)
+	}
+	sort.Strings(symbols)
+	var allCandles []marketCandle
+	for _, symbol := range symbols {
+		allCandles = append(allCandles, candles[symbol]...)
+	}
+	allStats := make([]marketStats, 0, len(stats))
+	for _, st := range stats {
+		allStats = append(allStats, *st)
+	}
+	return allCandles, allStats
+}
+
+// buildMarketSnapshot assembles the ticker and candles of every market from
+// the aggregates of the completed transactions. candles are ordered by symbol
+// and start time; latest holds the newest transaction of each symbol.
+// Prices are rounded to the fiat currency here, which keeps the highs and lows
+// of the aggregates, since rounding preserves order.
+func buildMarketSnapshot(assets *money.Registry, cfg config.MarketDataConfig, now time.Time, candles []marketCandle, stats []marketStats, latest []models.Transaction) marketSnapshot {
+	fiat := assets.Fiat()
+	asOf := now.Unix()
+
+	tickers := make(map[string]*models.Ticker)
+	snapshot := marketSnapshot{
+		list:    models.MarketList{Markets: []models.Ticker{}, AsOf: asOf},
+		history: make(map[string]models.PriceHistory),
+	}
+	for _, symbol := range assets.Symbols() {
+		if !isMarket(assets, symbol) {
+			continue
+		}
+		tickers[symbol] = &models.Ticker{Symbol: symbol, QuoteCurrency: fiat.Symbol, AsOf: asOf}
+		snapshot.history[symbol] = models.PriceHistory{
+			Symbol:        symbol,
+			QuoteCurrency: fiat.Symbol,
+			Interval:      formatInterval(cfg.CandleInterval),
+			Candles:       []models.Candle{},
+			AsOf:          asOf,
+		}
+	}
+
+	for _, c := range candles {
+		history, ok := snapshot.history[c.CryptoSymbol]
+		if !ok {
+			continue
+		}
+		history.Candles = append(history.Candles, models.Candle{
+			StartTime:   c.StartTime,
+			Open:        fiat.Round(c.Open),
+			High:        fiat.Round(c.High),
+			Low:         fiat.Round(c.Low),
+			Close:       fiat.Round(c.Close),
+			Volume:      c.Volume,
+			QuoteVolume: c.QuoteVolume,
+			Count:       c.Count,
+		})
+		snapshot.history[c.CryptoSymbol] = history
+	}
+
+	for _, st := range stats {
+		if t, ok := tickers[st.CryptoSymbol]; ok {
+			t.High24h = decimal.NewNullDecimal(fiat.Round(st.High))
+			t.Low24h = decimal.NewNullDecimal(fiat.Round(st.Low))
+			t.Volume24h = st.Volume
+			t.QuoteVolume24h = st.QuoteVolume
+			t.Count24h = st.Count
+		}
+	}
+
+	for _, tx := range latest {
+		if t, ok := tickers[tx.CryptoSymbol]; ok {
+			t.LastPrice = decimal.NewNullDecimal(impliedPrice(fiat, tx))
+			t.LastAt = tx.CreatedAt
+		}
+	}
+	for _, symbol := range assets.Symbols() {
+		if t, ok := tickers[symbol]; ok {
+			snapshot.list.Markets = append(snapshot.list.Markets, *t)
+		}
+	}
+	return snapshot
+}
+
+// findTicker returns the ticker of a symbol from a market list.
+func findTicker(list models.MarketList, symbol string) (models.Ticker, error) {
+	symbol = strings.ToUpper(symbol)
+	for _, t := range list.Markets {
+		if t.Symbol == symbol {
+			return t, nil
+		}
+	}
+	return models.Ticker{}, ErrMarketNotFound
+}
+
+// isMarket reports whether a symbol has market data; every configured asset
+// except the fiat currency does.
+func isMarket(assets *money.Registry, symbol string) bool {
+	_, err := assets.Asset(symbol)
+	return err == nil && symbol != assets.Fiat().Symbol
+}
+
+// impliedPrice returns the fiat price per unit of CryptoSymbol paid in a transaction.
+func impliedPrice(fiat money.Asset, tx models.Transaction) decimal.Decimal {
+	return fiat.Round(tx.Amount.Div(tx.CryptoAmount))
+}
+
+// formatInterval renders a candle interval as e.g. 15m, 1h or 1d.
+func formatInterval(d time.Duration) string {
+	switch {
+	case d%(24*time.Hour) == 0:
+		return fmt.Sprintf("%dd", d/(24*time.Hour))
+	case d%time.Hour == 0:
+		return fmt.Sprintf("%dh", d/time.Hour)
+	case d%time.Minute == 0:
+		return fmt.Sprintf("%dm", d/time.Minute)
+	default:
+		return d.String()
+	}
+}
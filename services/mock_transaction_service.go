@@ -2,52 +2,335 @@
 package services
 
 import (
-	"errors"
+	"context"
+	"fmt"
+	"sort"
 	"sync"
+	"time"
 
+	"crypto-exchange/apperrors"
+	"crypto-exchange/events"
+	"crypto-exchange/ledger"
 	"crypto-exchange/models"
+	"crypto-exchange/money"
+	"crypto-exchange/policy"
+
+	"github.com/shopspring/decimal"
 )
 
-// TransactionService defines the methods for transaction operations.
+var (
+	// ErrTransactionNotFound is returned when no transaction has the requested ID.
+	ErrTransactionNotFound = apperrors.New(apperrors.ErrNotFound, "transaction_not_found", "transaction not found")
+	// ErrInvalidTransition is returned when a status change is not allowed by the state machine.
+	ErrInvalidTransition = apperrors.New(apperrors.ErrConflict, "invalid_transition", "invalid status transition")
+	// ErrInvalidAmount is returned when an amount is not valid for its asset.
+	ErrInvalidAmount = apperrors.New(apperrors.ErrValidation, "invalid_amount", "invalid amount")
+
+	// errNotPending is returned for transactions created in another status
+	// than pending. Only the system settles or fails a transaction, through a
+	// status change, and withdrawals wait in pending for the step-up.
+	errNotPending = apperrors.Validation("transactions are created pending",
+		apperrors.FieldError{Field: "status", Code: "eq", Message: "transactions are created pending"})
+)
+
+// TransactionService defines the methods for transaction operations. Reads and
+// status changes are checked against the actor's role: transactions the actor
+// may not view do not exist for them.
 type TransactionService interface {
-	CreateTransaction(tx models.Transaction) (models.Transaction, error)
-	GetTransactionByID(id string) (models.Transaction, error)
+	CreateTransaction(ctx context.Context, tx models.Transaction) (models.Transaction, error)
+	GetTransactionByID(ctx context.Context, actor policy.Actor, id string) (models.Transaction, error)
+	ListTransactions(ctx context.Context, actor policy.Actor, filter models.TransactionFilter) (models.TransactionPage, error)
+	// RecentTransactions returns the latest transactions of a user, newest
+	// first, from the primary store, for snapshots that must not lag behind.
+	RecentTransactions(ctx context.Context, userID uint, limit int) ([]models.Transaction, error)
+	TransitionTransaction(ctx context.Context, actor policy.Actor, id string, status string, reason string) (models.Transaction, error)
+	GetTransactionHistory(ctx context.Context, actor policy.Actor, id string) ([]models.TransactionTransition, error)
+	// StepUpWithdrawal checks the second factor of a pending withdrawal of a
+	// user, which lets it move on to processing.
+	StepUpWithdrawal(ctx context.Context, id string, userID uint, code string) (models.Transaction, error)
 }
 
 // MockTransactionService is a mock implementation of TransactionService.
 type MockTransactionService struct {
 	transactions map[string]models.Transaction
+	history      map[string][]models.TransactionTransition
+	transitionID uint
+	ledger       *MockLedgerService
+	assets       *money.Registry
+	orders       *MockOrderService // set by NewMockOrderService, shares mutex
+	users        *MockUserService  // set by NewMockUserService
+	stepUp       StepUpVerifier    // set by NewMockTwoFactorService
+	publish      func(events.Envelope)
 	mutex        sync.RWMutex
+	Now          func() time.Time
 }
 
 // NewMockTransactionService creates a new instance of MockTransactionService.
-func NewMockTransactionService() TransactionService {
+func NewMockTransactionService(ledgerSvc *MockLedgerService, assets *money.Registry) *MockTransactionService {
 	return &MockTransactionService{
 		transactions: make(map[string]models.Transaction),
+		history:      make(map[string][]models.TransactionTransition),
+		ledger:       ledgerSvc,
+		assets:       assets,
+		Now:          time.Now,
 	}
 }
 
 // CreateTransaction adds a new transaction to the mock store.
-func (s *MockTransactionService) CreateTransaction(tx models.Transaction) (models.Transaction, error) {
+func (s *MockTransactionService) CreateTransaction(ctx context.Context, tx models.Transaction) (models.Transaction, error) {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 	if tx.ID == "" {
-		return models.Transaction{}, errors.New("transaction ID cannot be empty")
+		return models.Transaction{}, apperrors.Validation("transaction ID cannot be empty",
+			apperrors.FieldError{Field: "id", Code: "required", Message: "transaction ID cannot be empty"})
 	}
 	if _, exists := s.transactions[tx.ID]; exists {
-		return models.Transaction{}, errors.New("transaction ID already exists")
+		return models.Transaction{}, apperrors.New(apperrors.ErrConflict, "transaction_exists", "transaction ID already exists")
+	}
+	tx, err := normalizeAmounts(s.assets, tx)
+	if err != nil {
+		return models.Transaction{}, err
 	}
+	if tx.Status != models.StatusPending {
+		return models.Transaction{}, errNotPending
+	}
+	tx.StepUpAt = nil
+	if s.users != nil {
+		if err := s.users.checkActive(tx.UserID); err != nil {
+			return models.Transaction{}, err
+		}
+	}
+	if reservesFunds(tx) {
+		balances, err := s.userBalances(tx.UserID)
+		if err != nil {
+			return models.Transaction{}, err
+		}
+		if err := checkAvailable(balances, tx); err != nil {
+			return models.Transaction{}, err
+		}
+	}
+	// Timestamps are the store's, whatever the payload says
+	now := s.Now().Unix()
+	tx.CreatedAt, tx.UpdatedAt, tx.DeletedAt = now, now, 0
 	s.transactions[tx.ID] = tx
+	s.recordTransition(policy.Actor{UserID: tx.UserID}, tx.ID, "", tx.Status, "created")
+	s.emit(events.NewTransactionCreated(tx))
 	return tx, nil
 }
 
 // GetTransactionByID retrieves a transaction by ID from the mock store.
-func (s *MockTransactionService) GetTransactionByID(id string) (models.Transaction, error) {
+func (s *MockTransactionService) GetTransactionByID(ctx context.Context, actor policy.Actor, id string) (models.Transaction, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	tx, exists := s.transactions[id]
+	if !exists || !policy.CanViewTransaction(actor, tx) {
+		return models.Transaction{}, ErrTransactionNotFound
+	}
+	return tx, nil
+}
+
+// ListTransactions returns a page of transactions from the mock store, newest first.
+func (s *MockTransactionService) ListTransactions(ctx context.Context, actor policy.Actor, filter models.TransactionFilter) (models.TransactionPage, error) {
+	if !policy.CanListTransactions(actor, filter.UserID) {
+		return models.TransactionPage{}, fmt.Errorf("%w: cannot list transactions of other users", policy.ErrForbidden)
+	}
+	q, err := parseFilter(filter)
+	if err != nil {
+		return models.TransactionPage{}, err
+	}
+
 	s.mutex.RLock()
 	defer s.mutex.RUnlock()
+	txs := make([]models.Transaction, 0)
+	for _, tx := range s.transactions {
+		if q.matches(tx) && (q.after == nil || q.after.isAfter(tx)) {
+			txs = append(txs, tx)
+		}
+	}
+	sort.Slice(txs, func(i, j int) bool {
+		if txs[i].CreatedAt != txs[j].CreatedAt {
+			return txs[i].CreatedAt > txs[j].CreatedAt
+		}
+		return txs[i].ID > txs[j].ID
+	})
+	if len(txs) > q.limit+1 {
+		txs = txs[:q.limit+1]
+	}
+	return newPage(txs, q.limit), nil
+}
+
+// RecentTransactions returns the latest transactions of a user from the mock store.
+func (s *MockTransactionService) RecentTransactions(ctx context.Context, userID uint, limit int) ([]models.Transaction, error) {
+	page, err := s.ListTransactions(ctx, policy.Actor{UserID: userID}, models.TransactionFilter{UserID: userID, Limit: limit})
+	if err != nil {
+		return nil, err
+	}
+	return page.Transactions, nil
+}
+
+// TransitionTransaction moves a transaction in the mock store to a new status.
+func (s *MockTransactionService) TransitionTransaction(ctx context.Context, actor policy.Actor, id string, status string, reason string) (models.Transaction, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
 	tx, exists := s.transactions[id]
 	if !exists {
-		return models.Transaction{}, errors.New("transaction not found")
+		return models.Transaction{}, ErrTransactionNotFound
 	}
+	if err := checkTransitionAllowed(actor, tx, status); err != nil {
+		return models.Transaction{}, err
+	}
+	from := tx.Status
+	if !models.CanTransition(from, status) {
+		return models.Transaction{}, fmt.Errorf("%w: %s -> %s", ErrInvalidTransition, from, status)
+	}
+	if err := checkStepUp(tx, status); err != nil {
+		return models.Transaction{}, err
+	}
+
+	tx.Status = status
+	tx.UpdatedAt = s.Now().Unix()
+	entry, journaled, err := transitionEntry(tx)
+	if err != nil {
+		return models.Transaction{}, err
+	}
+	if journaled {
+		if debit := reversalDebit(tx, entry); debit.IsPositive() {
+			balances, err := s.userBalances(tx.UserID)
+			if err != nil {
+				return models.Transaction{}, err
+			}
+			if err := checkAvailableAmount(balances, tx.CryptoSymbol, debit); err != nil {
+				return models.Transaction{}, err
+			}
+		}
+		if err := s.ledger.RecordEntry(entry); err != nil {
+			return models.Transaction{}, err
+		}
+	}
+	s.transactions[id] = tx
+	s.recordTransition(actor, id, from, status, reason)
+	s.emit(events.NewTransactionStatusChanged(tx, from, reason))
 	return tx, nil
-}
\ No newline at end of file
+}
+
+// GetTransactionHistory returns the status transitions of a transaction, oldest first.
+func (s *MockTransactionService) GetTransactionHistory(ctx context.Context, actor policy.Actor, id string) ([]models.TransactionTransition, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	if tx, exists := s.transactions[id]; !exists || !policy.CanViewTransaction(actor, tx) {
+		return nil, ErrTransactionNotFound
+	}
+	history := make([]models.TransactionTransition, len(s.history[id]))
+	copy(history, s.history[id])
+	return history, nil
+}
+
+// StepUpWithdrawal checks the second factor of a pending withdrawal of a user.
+func (s *MockTransactionService) StepUpWithdrawal(ctx context.Context, id string, userID uint, code string) (models.Transaction, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	tx, exists := s.transactions[id]
+	if !exists || tx.UserID != userID {
+		return models.Transaction{}, ErrTransactionNotFound
+	}
+	if tx.Type != "withdrawal" || tx.Status != models.StatusPending {
+		return models.Transaction{}, fmt.Errorf("%w: only pending withdrawals take a step-up", ErrInvalidTransition)
+	}
+	if tx.StepUpAt != nil {
+		return tx, nil
+	}
+	if s.stepUp == nil {
+		return models.Transaction{}, ErrTwoFactorNotEnabled
+	}
+	if err := s.stepUp.VerifyStepUp(userID, code); err != nil {
+		return models.Transaction{}, err
+	}
+	now := s.Now().Unix()
+	tx.StepUpAt = &now
+	tx.UpdatedAt = now
+	s.transactions[id] = tx
+	s.emit(events.NewTransactionStatusChanged(tx, tx.Status, stepUpReason))
+	return tx, nil
+}
+
+// OnEvent registers a function receiving the events TransactionServiceDB would
+// publish to Kafka. It is called with the store locked and must not call back into it.
+func (s *MockTransactionService) OnEvent(publish func(events.Envelope)) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.publish = publish
+}
+
+// emit passes an event to the OnEvent function. The caller must hold s.mutex.
+func (s *MockTransactionService) emit(env events.Envelope, err error) {
+	if s.publish != nil && err == nil {
+		s.publish(env)
+	}
+}
+
+// recordTransition appends a transition history row. The caller must hold s.mutex.
+func (s *MockTransactionService) recordTransition(actor policy.Actor, id, from, to, reason string) {
+	s.transitionID++
+	s.history[id] = append(s.history[id], models.TransactionTransition{
+		ID:            s.transitionID,
+		TransactionID: id,
+		FromStatus:    from,
+		ToStatus:      to,
+		Reason:        reason,
+		ActorID:       actor.UserID,
+		ActorRole:     actor.Role,
+		CreatedAt:     s.Now().Unix(),
+	})
+}
+
+// checkTransitionAllowed returns an error unless the actor's role permits
+// moving a transaction to a status. Transactions the actor may not view are
+// reported as not found.
+func checkTransitionAllowed(actor policy.Actor, tx models.Transaction, status string) error {
+	if policy.CanTransition(actor, tx, status) {
+		return nil
+	}
+	if !policy.CanViewTransaction(actor, tx) {
+		return ErrTransactionNotFound
+	}
+	return fmt.Errorf("%w: %s may not move transactions to %s", policy.ErrForbidden, actor.Role, status)
+}
+
+// transitionEntry returns the journal entry a transaction needs after moving to
+// its current status, and whether there is one at all.
+func transitionEntry(tx models.Transaction) (models.JournalEntry, bool, error) {
+	switch tx.Status {
+	case models.StatusCompleted:
+		entry, err := ledger.EntryForTransaction(tx)
+		return entry, err == nil, err
+	case models.StatusReversed:
+		entry, err := ledger.ReversalEntry(tx)
+		return entry, err == nil, err
+	default:
+		return models.JournalEntry{}, false, nil
+	}
+}
+
+// userBalances computes a user's balances from the mock ledger, in-flight
+// withdrawals and open orders. The caller must hold s.mutex.
+func (s *MockTransactionService) userBalances(userID uint) ([]models.Balance, error) {
+	settled, err := s.ledger.GetUserBalances(userID)
+	if err != nil {
+		return nil, err
+	}
+	held := make(map[string]decimal.Decimal)
+	for _, tx := range s.transactions {
+		if tx.UserID == userID && tx.Type == "withdrawal" && models.IsHolding(tx.Status) {
+			held[tx.CryptoSymbol] = held[tx.CryptoSymbol].Add(tx.CryptoAmount).Add(tx.TransactionFee)
+		}
+	}
+	if s.orders != nil {
+		for _, o := range s.orders.orders {
+			if o.UserID == userID && o.IsOpen() {
+				asset, amount := orderHold(o)
+				held[asset] = held[asset].Add(amount)
+			}
+		}
+	}
+	return buildBalances(settled, held), nil
+}
@@ -0,0 +1,492 @@
+// services/order_service.go
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"crypto-exchange/apperrors"
+	"crypto-exchange/ledger"
+	"crypto-exchange/matching"
+	"crypto-exchange/models"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrOrderNotFound is returned when no order has the requested ID.
+	ErrOrderNotFound = apperrors.New(apperrors.ErrNotFound, "order_not_found", "order not found")
+	// ErrOrderNotOpen is returned when cancelling an order that is no longer on the book.
+	ErrOrderNotOpen = apperrors.New(apperrors.ErrConflict, "order_not_open", "order is not open")
+	// ErrInvalidOrder is returned when an order's parameters do not fit together.
+	ErrInvalidOrder = apperrors.New(apperrors.ErrValidation, "invalid_order", "invalid order")
+	// ErrMatchingUnavailable is returned by nodes that do not own the order books.
+	ErrMatchingUnavailable = apperrors.New(apperrors.ErrUnavailable, "matching_unavailable", "order matching is unavailable on this node")
+)
+
+// matchingLockKey is the PostgreSQL advisory lock key held by the node that
+// owns the order books.
+const matchingLockKey int64 = 0x6d61746368 // "match"
+
+// matchingLeaseInterval is how often the owner of the order books checks that
+// it still holds the matching lock, and how often standbys try to take it.
+const matchingLeaseInterval = 5 * time.Second
+
+// OrderService defines the methods for placing and cancelling orders.
+type OrderService interface {
+	PlaceOrder(req models.PlaceOrderRequest) (models.OrderResult, error)
+	CancelOrder(id string, userID uint) (models.Order, error)
+}
+
+// OrderServiceDB matches orders in the engine and settles their trades in
+// PostgreSQL. The books are held in memory, so only one node may match: the
+// one holding the matching lock. The others answer ErrMatchingUnavailable.
+type OrderServiceDB struct {
+	DB       *gorm.DB
+	Logger   zerolog.Logger
+	Ledger   *LedgerServiceDB
+	Balances *BalanceServiceDB
+	Engine   *matching.Engine
+	Leader   *LeaderLock
+	leading  bool // whether the books are restored and served by this node
+	mutex    sync.Mutex
+}
+
+// NewOrderService initializes a new OrderServiceDB.
+func NewOrderService(db *gorm.DB, logger zerolog.Logger, ledgerSvc *LedgerServiceDB, balanceSvc *BalanceServiceDB, engine *matching.Engine) *OrderServiceDB {
+	return &OrderServiceDB{
+		DB:       db,
+		Logger:   logger,
+		Ledger:   ledgerSvc,
+		Balances: balanceSvc,
+		Engine:   engine,
+		Leader:   NewLeaderLock(db, logger, matchingLockKey),
+	}
+}
+
+// Run competes for the matching lock until ctx is cancelled. The node that
+// takes it restores the books and matches orders until it loses the lock;
+// the others stand by.
+func (s *OrderServiceDB) Run(ctx context.Context) {
+	s.Logger.Info().Msg("Matching engine standing by")
+	ticker := time.NewTicker(matchingLeaseInterval)
+	defer ticker.Stop()
+	for {
+		s.checkLeadership(ctx)
+		select {
+		case <-ctx.Done():
+			s.setLeading(false)
+			if err := s.Leader.Release(); err != nil {
+				s.Logger.Error().Err(err).Msg("Failed to release the matching lock")
+			}
+			s.Logger.Info().Msg("Matching engine stopped")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// checkLeadership takes over the books if the matching lock is free, and
+// drops them if the lock was lost.
+func (s *OrderServiceDB) checkLeadership(ctx context.Context) {
+	if s.isLeading() {
+		if !s.Leader.Held(ctx) {
+			s.setLeading(false)
+			s.Engine.Reset()
+			s.Logger.Warn().Msg("Lost the matching lock, standing by")
+		}
+		return
+	}
+
+	acquired, err := s.Leader.TryAcquire(ctx)
+	if err != nil {
+		s.Logger.Error().Err(err).Msg("Failed to take the matching lock")
+		return
+	}
+	if !acquired {
+		return
+	}
+	// Another node may have matched since this one last held the books
+	s.Engine.Reset()
+	if err := s.RestoreBooks(); err != nil {
+		if err := s.Leader.Release(); err != nil {
+			s.Logger.Error().Err(err).Msg("Failed to release the matching lock")
+		}
+		return
+	}
+	s.setLeading(true)
+	s.Logger.Info().Msg("Took the matching lock, matching orders")
+}
+
+// isLeading reports whether this node serves the order books.
+func (s *OrderServiceDB) isLeading() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.leading
+}
+
+// setLeading records whether this node serves the order books.
+func (s *OrderServiceDB) setLeading(leading bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.leading = leading
+}
+
+// checkLeader returns ErrMatchingUnavailable unless this node still holds the
+// matching lock. It is checked under the book's lock, right before writing.
+func (s *OrderServiceDB) checkLeader() error {
+	if !s.isLeading() || !s.Leader.Held(context.Background()) {
+		return ErrMatchingUnavailable
+	}
+	return nil
+}
+
+// RestoreBooks puts the open orders stored in PostgreSQL back on the engine's books.
+func (s *OrderServiceDB) RestoreBooks() error {
+	var orders []models.Order
+	err := s.DB.Where("status IN ?", []string{models.OrderStatusOpen, models.OrderStatusPartiallyFilled}).
+		Order("created_at, id").
+		Find(&orders).Error
+	if err != nil {
+		s.Logger.Error().Err(err).Msg("Failed to load open orders from PostgreSQL")
+		return err
+	}
+	for _, o := range orders {
+		if err := s.Engine.Restore(o); err != nil {
+			return err
+		}
+	}
+	s.Logger.Info().Int("orders", len(orders)).Msg("Restored order books")
+	return nil
+}
+
+// PlaceOrder matches a new order and settles its trades in one DB transaction.
+// Nothing is written and the book is unchanged if settlement fails.
+func (s *OrderServiceDB) PlaceOrder(req models.PlaceOrderRequest) (models.OrderResult, error) {
+	order, market, err := newOrder(s.Engine, req)
+	if err != nil {
+		return models.OrderResult{}, err
+	}
+
+	if !s.isLeading() {
+		return models.OrderResult{}, ErrMatchingUnavailable
+	}
+
+	var trades []models.Trade
+	m, err := s.Engine.Submit(order, func(m matching.Match) error {
+		if err := s.checkLeader(); err != nil {
+			return err
+		}
+		var err error
+		if trades, err = newTrades(market, m); err != nil {
+			return err
+		}
+		return s.DB.Transaction(func(db *gorm.DB) error {
+			return s.settle(db, m, trades)
+		})
+	})
+	if err != nil {
+		return models.OrderResult{}, err
+	}
+
+	s.Logger.Info().
+		Str("order_id", m.Taker.ID).
+		Str("pair", m.Taker.Pair).
+		Str("status", m.Taker.Status).
+		Int("trades", len(trades)).
+		Bool("self_trade", m.SelfTrade).
+		Msg("Order placed")
+	return models.OrderResult{Order: m.Taker, Trades: trades}, nil
+}
+
+// settle writes a match using the given DB handle: the taker order, the updated
+// maker orders, the trades and their journal entries.
+func (s *OrderServiceDB) settle(db *gorm.DB, m matching.Match, trades []models.Trade) error {
+	if err := checkUserActive(db, m.Taker.UserID); err != nil {
+		return err
+	}
+	asset, amount := requiredFunds(m, trades)
+	if err := s.Balances.ReserveAmount(db, m.Taker.UserID, asset, amount); err != nil {
+		return err
+	}
+
+	if err := db.Create(&m.Taker).Error; err != nil {
+		s.Logger.Error().Err(err).Str("order_id", m.Taker.ID).Msg("Failed to create order in PostgreSQL")
+		return err
+	}
+	for i, f := range m.Fills {
+		err := db.Model(&models.Order{}).Where("id = ?", f.Maker.ID).Updates(map[string]interface{}{
+			"filled_quantity": f.Maker.FilledQuantity,
+			"status":          f.Maker.Status,
+		}).Error
+		if err != nil {
+			s.Logger.Error().Err(err).Str("order_id", f.Maker.ID).Msg("Failed to update maker order in PostgreSQL")
+			return err
+		}
+		if err := db.Create(&trades[i]).Error; err != nil {
+			s.Logger.Error().Err(err).Str("trade_id", trades[i].ID).Msg("Failed to create trade in PostgreSQL")
+			return err
+		}
+		entry, err := ledger.EntryForTrade(trades[i])
+		if err != nil {
+			return err
+		}
+		if err := s.Ledger.RecordEntry(db, entry); err != nil {
+			return err
+		}
+	}
+	for _, c := range m.Cancelled {
+		if err := db.Model(&models.Order{}).Where("id = ?", c.ID).Update("status", c.Status).Error; err != nil {
+			s.Logger.Error().Err(err).Str("order_id", c.ID).Msg("Failed to cancel dust order in PostgreSQL")
+			return err
+		}
+	}
+	return nil
+}
+
+// CancelOrder takes an open order of a user off the book and releases its funds.
+func (s *OrderServiceDB) CancelOrder(id string, userID uint) (models.Order, error) {
+	var order models.Order
+	if err := s.DB.First(&order, "id = ? AND user_id = ?", id, userID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return models.Order{}, ErrOrderNotFound
+		}
+		s.Logger.Error().Err(err).Str("order_id", id).Msg("Failed to retrieve order from PostgreSQL")
+		return models.Order{}, err
+	}
+
+	if !s.isLeading() {
+		return models.Order{}, ErrMatchingUnavailable
+	}
+	cancelled, err := s.Engine.Cancel(order.Pair, id, func(o models.Order) error {
+		if err := s.checkLeader(); err != nil {
+			return err
+		}
+		return s.DB.Model(&models.Order{}).Where("id = ?", id).Update("status", o.Status).Error
+	})
+	if errors.Is(err, matching.ErrOrderNotOnBook) {
+		return models.Order{}, fmt.Errorf("%w: order is %s", ErrOrderNotOpen, order.Status)
+	}
+	if err != nil {
+		s.Logger.Error().Err(err).Str("order_id", id).Msg("Failed to cancel order")
+		return models.Order{}, err
+	}
+	s.Logger.Info().Str("order_id", id).Msg("Order cancelled")
+	return cancelled, nil
+}
+
+// MockOrderService matches orders in the engine and settles their trades in the
+// mock ledger. It shares the mutex of the transaction service so that orders and
+// withdrawals see each other's holds.
+type MockOrderService struct {
+	transactions *MockTransactionService
+	engine       *matching.Engine
+	orders       map[string]models.Order
+	trades       []models.Trade
+}
+
+// NewMockOrderService creates a new instance of MockOrderService.
+func NewMockOrderService(txService *MockTransactionService, engine *matching.Engine) *MockOrderService {
+	s := &MockOrderService{
+		transactions: txService,
+		engine:       engine,
+		orders:       make(map[string]models.Order),
+	}
+	txService.orders = s
+	return s
+}
+
+// PlaceOrder matches a new order and settles its trades in the mock ledger.
+func (s *MockOrderService) PlaceOrder(req models.PlaceOrderRequest) (models.OrderResult, error) {
+	order, market, err := newOrder(s.engine, req)
+	if err != nil {
+		return models.OrderResult{}, err
+	}
+
+	s.transactions.mutex.Lock()
+	defer s.transactions.mutex.Unlock()
+	var trades []models.Trade
+	m, err := s.engine.Submit(order, func(m matching.Match) error {
+		var err error
+		if trades, err = newTrades(market, m); err != nil {
+			return err
+		}
+		if s.transactions.users != nil {
+			if err := s.transactions.users.checkActive(m.Taker.UserID); err != nil {
+				return err
+			}
+		}
+		balances, err := s.transactions.userBalances(m.Taker.UserID)
+		if err != nil {
+			return err
+		}
+		asset, amount := requiredFunds(m, trades)
+		if err := checkAvailableAmount(balances, asset, amount); err != nil {
+			return err
+		}
+
+		// Build every entry before recording any, so that a failure changes nothing
+		entries := make([]models.JournalEntry, len(trades))
+		for i, t := range trades {
+			if entries[i], err = ledger.EntryForTrade(t); err != nil {
+				return err
+			}
+		}
+		for _, entry := range entries {
+			if err := s.transactions.ledger.RecordEntry(entry); err != nil {
+				return err
+			}
+		}
+
+		s.orders[m.Taker.ID] = m.Taker
+		for _, f := range m.Fills {
+			s.orders[f.Maker.ID] = f.Maker
+		}
+		for _, c := range m.Cancelled {
+			s.orders[c.ID] = c
+		}
+		s.trades = append(s.trades, trades...)
+		return nil
+	})
+	if err != nil {
+		return models.OrderResult{}, err
+	}
+	return models.OrderResult{Order: m.Taker, Trades: trades}, nil
+}
+
+// CancelOrder takes an open order of a user off the book and releases its funds.
+func (s *MockOrderService) CancelOrder(id string, userID uint) (models.Order, error) {
+	s.transactions.mutex.Lock()
+	defer s.transactions.mutex.Unlock()
+	order, exists := s.orders[id]
+	if !exists || order.UserID != userID {
+		return models.Order{}, ErrOrderNotFound
+	}
+	cancelled, err := s.engine.Cancel(order.Pair, id, func(o models.Order) error {
+		s.orders[id] = o
+		return nil
+	})
+	if errors.Is(err, matching.ErrOrderNotOnBook) {
+		return models.Order{}, fmt.Errorf("%w: order is %s", ErrOrderNotOpen, order.Status)
+	}
+	return cancelled, err
+}
+
+// newOrder validates an order request and rounds its price and quantity to the
+// precision of the pair's quote and base assets.
+func newOrder(engine *matching.Engine, req models.PlaceOrderRequest) (models.Order, matching.Market, error) {
+	market, err := engine.Market(req.Pair)
+	if err != nil {
+		return models.Order{}, matching.Market{}, err
+	}
+
+	order := models.Order{
+		ID:          uuid.NewString(),
+		UserID:      req.UserID,
+		Pair:        market.Symbol,
+		Side:        req.Side,
+		Type:        req.Type,
+		TimeInForce: req.TimeInForce,
+		PostOnly:    req.PostOnly,
+		Quantity:    market.Base.Round(req.Quantity),
+		Status:      models.OrderStatusOpen,
+		// Set here rather than by GORM so that the book holds the same timestamps
+		CreatedAt: time.Now().UnixMilli(),
+	}
+	if !order.Quantity.IsPositive() {
+		return models.Order{}, market, fmt.Errorf("%w: quantity must be at least one %s unit", ErrInvalidOrder, market.Base.Symbol)
+	}
+
+	switch order.Type {
+	case models.OrderTypeLimit:
+		if order.TimeInForce == "" {
+			order.TimeInForce = models.TimeInForceGTC
+		}
+		order.Price = market.Quote.Round(req.Price)
+		if !order.Price.IsPositive() {
+			return models.Order{}, market, fmt.Errorf("%w: limit orders need a positive price", ErrInvalidOrder)
+		}
+		if order.PostOnly && order.TimeInForce != models.TimeInForceGTC {
+			return models.Order{}, market, fmt.Errorf("%w: post-only orders must be good-til-cancelled", ErrInvalidOrder)
+		}
+	case models.OrderTypeMarket:
+		if order.TimeInForce == "" {
+			order.TimeInForce = models.TimeInForceIOC
+		}
+		switch {
+		case order.TimeInForce == models.TimeInForceGTC:
+			return models.Order{}, market, fmt.Errorf("%w: market orders cannot rest on the book", ErrInvalidOrder)
+		case order.PostOnly:
+			return models.Order{}, market, fmt.Errorf("%w: market orders cannot be post-only", ErrInvalidOrder)
+		case !req.Price.IsZero():
+			return models.Order{}, market, fmt.Errorf("%w: market orders take no price", ErrInvalidOrder)
+		}
+	default:
+		return models.Order{}, market, fmt.Errorf("%w: unsupported order type %q", ErrInvalidOrder, order.Type)
+	}
+	return order, market, nil
+}
+
+// newTrades builds the trades of a match. The quote amount of each trade is
+// rounded down, so that a buyer never pays more than price times quantity;
+// the engine never matches fills worth less than one quote unit.
+func newTrades(market matching.Market, m matching.Match) ([]models.Trade, error) {
+	now := time.Now().UnixMilli()
+	trades := make([]models.Trade, len(m.Fills))
+	for i, f := range m.Fills {
+		trade := models.Trade{
+			ID:            uuid.NewString(),
+			Pair:          market.Symbol,
+			Price:         f.Price,
+			Quantity:      f.Quantity,
+			QuoteQuantity: f.Price.Mul(f.Quantity).RoundDown(market.Quote.Precision),
+			TakerSide:     m.Taker.Side,
+			MakerOrderID:  f.Maker.ID,
+			TakerOrderID:  m.Taker.ID,
+			BuyerID:       m.Taker.UserID,
+			SellerID:      f.Maker.UserID,
+			CreatedAt:     now,
+		}
+		if m.Taker.Side == models.SideSell {
+			trade.BuyerID, trade.SellerID = f.Maker.UserID, m.Taker.UserID
+		}
+		if !trade.QuoteQuantity.IsPositive() {
+			return nil, fmt.Errorf("%w: trade of %s at %s is worth less than one %s unit", ErrInvalidOrder, f.Quantity, f.Price, market.Quote.Symbol)
+		}
+		trades[i] = trade
+	}
+	return trades, nil
+}
+
+// requiredFunds returns the asset and amount the taker of a match must have
+// available: what its trades cost plus what its resting remainder holds.
+func requiredFunds(m matching.Match, trades []models.Trade) (string, decimal.Decimal) {
+	asset, amount := orderHold(m.Taker)
+	if !m.Taker.IsOpen() {
+		amount = decimal.Zero
+	}
+	for _, t := range trades {
+		if m.Taker.Side == models.SideBuy {
+			amount = amount.Add(t.QuoteQuantity)
+		} else {
+			amount = amount.Add(t.Quantity)
+		}
+	}
+	return asset, amount
+}
+
+// orderHold returns the asset and amount held by an open order: the quote
+// asset needed to buy its remaining quantity, or the base asset it sells.
+func orderHold(o models.Order) (string, decimal.Decimal) {
+	base, quote, _ := models.SplitPair(o.Pair)
+	if o.Side == models.SideBuy {
+		return quote, o.Price.Mul(o.Remaining())
+	}
+	return base, o.Remaining()
+}
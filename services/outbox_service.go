@@ -0,0 +1,252 @@
+// services/outbox_service.go
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"crypto-exchange/config"
+	"crypto-exchange/events"
+	"crypto-exchange/models"
+	"crypto-exchange/tracing"
+
+	"github.com/rs/zerolog"
+	"github.com/segmentio/kafka-go"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+// outboxRelayLockID is the PostgreSQL advisory lock that elects a single active
+// relay, so that events are published in the order they were written.
+const outboxRelayLockID = 7310001
+
+// OutboxService defines the methods for observing the outbox relay.
+type OutboxService interface {
+	Lag() (models.OutboxLag, error)
+}
+
+// enqueueOutbox validates an event and writes it to the outbox using the given DB
+// handle, so that it is committed or rolled back together with the change it describes.
+// The trace context of ctx is kept with the event, so that its publication
+// joins the trace of the change.
+func enqueueOutbox(ctx context.Context, db *gorm.DB, registry *events.Registry, env events.Envelope) error {
+	if err := registry.Validate(env); err != nil {
+		return err
+	}
+	payload, err := json.Marshal(env)
+	if err != nil {
+		return err
+	}
+	traceContext, err := json.Marshal(tracing.Inject(ctx))
+	if err != nil {
+		return err
+	}
+	return tracing.Store(ctx, "postgresql", "enqueue outbox", func(context.Context) error {
+		return db.Create(&models.OutboxMessage{Key: env.Key, Payload: payload, TraceContext: traceContext}).Error
+	})
+}
+
+// OutboxRelay drains the outbox table to Kafka with at-least-once delivery.
+type OutboxRelay struct {
+	DB           *gorm.DB
+	Logger       zerolog.Logger
+	KafkaService *KafkaService
+	PollInterval time.Duration
+	BatchSize    int
+	MaxBackoff   time.Duration
+}
+
+// NewOutboxRelay initializes a new OutboxRelay.
+func NewOutboxRelay(db *gorm.DB, logger zerolog.Logger, kafkaSvc *KafkaService, cfg config.OutboxConfig) *OutboxRelay {
+	return &OutboxRelay{
+		DB:           db,
+		Logger:       logger,
+		KafkaService: kafkaSvc,
+		PollInterval: cfg.PollInterval,
+		BatchSize:    cfg.BatchSize,
+		MaxBackoff:   cfg.MaxBackoff,
+	}
+}
+
+// Run publishes outbox messages until the context is cancelled. After a failed
+// batch it waits with exponential backoff before retrying the same messages.
+func (r *OutboxRelay) Run(ctx context.Context) {
+	r.Logger.Info().Msg("Outbox relay started")
+	delay := time.Duration(0)
+	backoff := r.PollInterval
+	for {
+		select {
+		case <-ctx.Done():
+			r.Logger.Info().Msg("Outbox relay stopped")
+			return
+		case <-time.After(delay):
+		}
+
+		published, err := r.relayBatch(ctx)
+		switch {
+		case err != nil:
+			r.Logger.Error().Err(err).Dur("retry_in", backoff).Msg("Failed to relay outbox messages")
+			delay = backoff
+			backoff *= 2
+			if backoff > r.MaxBackoff {
+				backoff = r.MaxBackoff
+			}
+		case published == r.BatchSize:
+			// There is probably more to publish right away
+			delay = 0
+			backoff = r.PollInterval
+		default:
+			delay = r.PollInterval
+			backoff = r.PollInterval
+		}
+	}
+}
+
+// relayBatch publishes the oldest unpublished messages and returns how many were published.
+func (r *OutboxRelay) relayBatch(ctx context.Context) (int, error) {
+	published := 0
+	var publishErr error
+	err := r.DB.Transaction(func(db *gorm.DB) error {
+		var leader bool
+		if err := db.Raw("SELECT pg_try_advisory_xact_lock(?)", outboxRelayLockID).Scan(&leader).Error; err != nil {
+			return err
+		}
+		if !leader {
+			return nil
+		}
+
+		var messages []models.OutboxMessage
+		if err := db.Where("published_at IS NULL").Order("id").Limit(r.BatchSize).Find(&messages).Error; err != nil {
+			return err
+		}
+		if len(messages) == 0 {
+			return nil
+		}
+
+		batch := make([]kafka.Message, len(messages))
+		spans := make([]trace.Span, len(messages))
+		for i, m := range messages {
+			batch[i] = kafka.Message{Key: []byte(m.Key), Value: m.Payload, Time: time.Now()}
+			spans[i] = r.traceMessage(ctx, m, &batch[i])
+		}
+		publishErr = r.KafkaService.PublishBatch(ctx, batch)
+		for i, span := range spans {
+			tracing.End(span, messageError(publishErr, i))
+		}
+
+		// Only the prefix before the first failure counts as published, so a retry
+		// resends the rest in order. Later messages may be delivered twice.
+		failedAt := len(messages)
+		if publishErr != nil {
+			failedAt = 0
+			var writeErrs kafka.WriteErrors
+			if errors.As(publishErr, &writeErrs) {
+				for failedAt < len(writeErrs) && writeErrs[failedAt] == nil {
+					failedAt++
+				}
+			}
+		}
+
+		if failedAt > 0 {
+			ids := make([]uint, failedAt)
+			for i := range ids {
+				ids[i] = messages[i].ID
+			}
+			if err := db.Model(&models.OutboxMessage{}).Where("id IN ?", ids).
+				Update("published_at", time.Now().UnixMilli()).Error; err != nil {
+				return err
+			}
+			published = failedAt
+		}
+
+		if publishErr != nil {
+			failed := make([]uint, 0, len(messages)-failedAt)
+			for _, m := range messages[failedAt:] {
+				failed = append(failed, m.ID)
+			}
+			if err := db.Model(&models.OutboxMessage{}).Where("id IN ?", failed).Updates(map[string]interface{}{
+				"attempts":   gorm.Expr("attempts + 1"),
+				"last_error": publishErr.Error(),
+			}).Error; err != nil {
+				return err
+			}
+		}
+		// Commit the bookkeeping even if publishing failed
+		return nil
+	})
+	if published > 0 {
+		r.Logger.Debug().Int("published", published).Msg("Relayed outbox messages to Kafka")
+	}
+	if err != nil {
+		return published, err
+	}
+	return published, publishErr
+}
+
+// traceMessage starts the producer span of an outbox message in the trace of
+// the change it describes and passes the span on in the message headers.
+func (r *OutboxRelay) traceMessage(ctx context.Context, m models.OutboxMessage, msg *kafka.Message) trace.Span {
+	var headers map[string]string
+	if len(m.TraceContext) > 0 {
+		if err := json.Unmarshal(m.TraceContext, &headers); err != nil {
+			r.Logger.Warn().Err(err).Uint("outbox_id", m.ID).Msg("Invalid trace context in outbox message")
+		}
+	}
+	ctx, span := tracing.Start(tracing.Extract(ctx, headers), r.KafkaService.Topic+" publish",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			semconv.MessagingSystemKafka,
+			semconv.MessagingOperationPublish,
+			semconv.MessagingDestinationName(r.KafkaService.Topic),
+		),
+	)
+	tracing.InjectKafka(ctx, msg)
+	return span
+}
+
+// messageError returns the error of the i-th message of a batch write.
+func messageError(err error, i int) error {
+	var writeErrs kafka.WriteErrors
+	if errors.As(err, &writeErrs) && i < len(writeErrs) {
+		return writeErrs[i]
+	}
+	return err
+}
+
+// Lag reports how many messages are waiting and the age of the oldest one.
+func (r *OutboxRelay) Lag() (models.OutboxLag, error) {
+	var stats struct {
+		Pending int64
+		Oldest  *int64
+	}
+	err := r.DB.Model(&models.OutboxMessage{}).
+		Select("COUNT(*) AS pending, MIN(created_at) AS oldest").
+		Where("published_at IS NULL").
+		Scan(&stats).Error
+	if err != nil {
+		r.Logger.Error().Err(err).Msg("Failed to compute outbox lag")
+		return models.OutboxLag{}, err
+	}
+
+	lag := models.OutboxLag{Pending: stats.Pending}
+	if stats.Oldest != nil {
+		lag.OldestAgeSeconds = time.Since(time.UnixMilli(*stats.Oldest)).Seconds()
+	}
+	return lag, nil
+}
+
+// MockOutboxService reports an empty outbox; the mock services publish nothing.
+type MockOutboxService struct{}
+
+// NewMockOutboxService creates a new instance of MockOutboxService.
+func NewMockOutboxService() OutboxService {
+	return &MockOutboxService{}
+}
+
+// Lag reports an empty outbox.
+func (s *MockOutboxService) Lag() (models.OutboxLag, error) {
+	return models.OutboxLag{}, nil
+}
@@ -0,0 +1,186 @@
+// services/rate_limit_service.go
+package services
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"crypto-exchange/config"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/rs/zerolog"
+)
+
+// rateLimitKeyPrefix prefixes the Redis keys of rate limit buckets.
+const rateLimitKeyPrefix = "ratelimit:"
+
+// memoryRateLimitSweep is how often the in-memory limiter drops idle buckets.
+const memoryRateLimitSweep = time.Minute
+
+// RateLimitResult is the outcome of counting a request against a limit.
+type RateLimitResult struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// ResetAfter is how long until the full limit is available again.
+	ResetAfter time.Duration
+	// RetryAfter is how long until the next request is allowed; zero if this one was.
+	RetryAfter time.Duration
+}
+
+// RateLimiter counts requests against the limit of a key, such as a user.
+type RateLimiter interface {
+	Allow(ctx context.Context, key string, rule config.RateLimitRule) (RateLimitResult, error)
+}
+
+// gcraScript applies the generic cell rate algorithm to the bucket in KEYS[1]
+// using the Redis clock, so that every API node counts the same way. The
+// bucket holds the theoretical arrival time (TAT) of the next request in
+// microseconds. ARGV[1] is the emission interval and ARGV[2] the window, in
+// microseconds. It returns whether the request is allowed, the TAT after it
+// and the current time.
+var gcraScript = redis.NewScript(`
+local t = redis.call("TIME")
+local now = tonumber(t[1]) * 1000000 + tonumber(t[2])
+local interval = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local tat = tonumber(redis.call("GET", KEYS[1]))
+if not tat or tat < now then
+  tat = now
+end
+local next_tat = tat + interval
+if next_tat - now > window then
+  return {0, tat, now}
+end
+redis.call("SET", KEYS[1], next_tat, "PX", math.ceil((next_tat - now) / 1000))
+return {1, next_tat, now}
+`)
+
+// RateLimiterRedis keeps rate limit buckets in Redis, so that limits hold
+// across API nodes. While Redis is unavailable, each node falls back to
+// counting requests in memory.
+type RateLimiterRedis struct {
+	RedisService *RedisService
+	Logger       zerolog.Logger
+	Fallback     *MemoryRateLimiter
+	unavailable  bool // whether the last request fell back to memory
+	mutex        sync.Mutex
+}
+
+// NewRateLimiter initializes a new RateLimiterRedis.
+func NewRateLimiter(redisSvc *RedisService, logger zerolog.Logger) *RateLimiterRedis {
+	return &RateLimiterRedis{
+		RedisService: redisSvc,
+		Logger:       logger,
+		Fallback:     NewMemoryRateLimiter(),
+	}
+}
+
+// Allow counts a request against the limit of a key.
+func (l *RateLimiterRedis) Allow(ctx context.Context, key string, rule config.RateLimitRule) (RateLimitResult, error) {
+	interval := emissionInterval(rule)
+	values, err := gcraScript.Run(ctx, l.RedisService.Client, []string{rateLimitKeyPrefix + key},
+		interval.Microseconds(), rule.Window.Microseconds()).Int64Slice()
+	if err == nil && len(values) != 3 {
+		err = fmt.Errorf("unexpected rate limit script result %v", values)
+	}
+	l.setUnavailable(err)
+	if err != nil {
+		return l.Fallback.Allow(ctx, key, rule)
+	}
+	tat, now := time.UnixMicro(values[1]), time.UnixMicro(values[2])
+	return rateLimitResult(values[0] == 1, tat, now, rule), nil
+}
+
+// setUnavailable records whether Redis failed to count a request, logging
+// only when the limiter falls back to memory and when Redis is back.
+func (l *RateLimiterRedis) setUnavailable(err error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	switch {
+	case err != nil && !l.unavailable:
+		l.Logger.Warn().Err(err).Msg("Redis unavailable, rate limiting in memory")
+	case err == nil && l.unavailable:
+		l.Logger.Info().Msg("Redis available again, rate limiting in Redis")
+	}
+	l.unavailable = err != nil
+}
+
+// MemoryRateLimiter keeps rate limit buckets in memory. Limits only hold per
+// process, so it serves development and the Redis fallback.
+type MemoryRateLimiter struct {
+	tats    map[string]time.Time // theoretical arrival time of the next request, by key
+	sweepAt time.Time
+	mutex   sync.Mutex
+	Now     func() time.Time
+}
+
+// NewMemoryRateLimiter creates a new instance of MemoryRateLimiter.
+func NewMemoryRateLimiter() *MemoryRateLimiter {
+	return &MemoryRateLimiter{
+		tats: make(map[string]time.Time),
+		Now:  time.Now,
+	}
+}
+
+// Allow counts a request against the limit of a key.
+func (l *MemoryRateLimiter) Allow(ctx context.Context, key string, rule config.RateLimitRule) (RateLimitResult, error) {
+	now := l.Now()
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	// Buckets whose TAT has passed are full again and need not be kept
+	if now.After(l.sweepAt) {
+		for k, tat := range l.tats {
+			if tat.Before(now) {
+				delete(l.tats, k)
+			}
+		}
+		l.sweepAt = now.Add(memoryRateLimitSweep)
+	}
+
+	tat := l.tats[key]
+	if tat.Before(now) {
+		tat = now
+	}
+	next := tat.Add(emissionInterval(rule))
+	if next.Sub(now) > rule.Window {
+		return rateLimitResult(false, tat, now, rule), nil
+	}
+	l.tats[key] = next
+	return rateLimitResult(true, next, now, rule), nil
+}
+
+// emissionInterval is the time one request of a rule takes up in its bucket.
+// Rules scaled up by an API key tier may allow more requests than the window
+// can count; they are clamped to config.MinRateLimitInterval.
+func emissionInterval(rule config.RateLimitRule) time.Duration {
+	if rule.Requests < 1 {
+		return rule.Window
+	}
+	interval := rule.Window / time.Duration(rule.Requests)
+	if interval < config.MinRateLimitInterval {
+		return config.MinRateLimitInterval
+	}
+	return interval
+}
+
+// rateLimitResult describes a bucket whose next request is due at tat. A
+// bucket is empty when tat is a whole window ahead of now.
+func rateLimitResult(allowed bool, tat, now time.Time, rule config.RateLimitRule) RateLimitResult {
+	interval := emissionInterval(rule)
+	ahead := tat.Sub(now)
+	result := RateLimitResult{
+		Allowed:    allowed,
+		Limit:      rule.Requests,
+		Remaining:  int((rule.Window - ahead) / interval),
+		ResetAfter: ahead,
+	}
+	if !allowed {
+		result.Remaining = 0
+		result.RetryAfter = ahead + interval - rule.Window
+	}
+	return result
+}
@@ -3,10 +3,12 @@ package services
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"time"
 
 	"crypto-exchange/config"
+	"crypto-exchange/metrics"
 
 	"github.com/go-redis/redis/v8"
 )
@@ -16,27 +18,26 @@ type RedisService struct {
 	Client *redis.Client
 }
 
-// NewRedisService initializes the RedisService.
+// NewRedisService initializes the RedisService. The client connects lazily,
+// so the service starts even while Redis is unavailable; use Ping to check it.
 func NewRedisService(cfg config.RedisConfig) *RedisService {
 	rdb := redis.NewClient(&redis.Options{
 		Addr:     fmt.Sprintf("%s:%d", cfg.Host, cfg.Port),
 		Password: cfg.Password,
 		DB:       cfg.DB,
 	})
-
-	// Test the connection
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	if err := rdb.Ping(ctx).Err(); err != nil {
-		panic(fmt.Sprintf("Unable to connect to Redis: %v", err))
-	}
+	rdb.AddHook(redisMetricsHook{})
 
 	return &RedisService{
 		Client: rdb,
 	}
 }
 
+// Ping checks that Redis is reachable.
+func (r *RedisService) Ping(ctx context.Context) error {
+	return r.Client.Ping(ctx).Err()
+}
+
 // Set stores a key-value pair in Redis with an expiration.
 func (r *RedisService) Set(ctx context.Context, key string, value interface{}, expiration time.Duration) error {
 	return r.Client.Set(ctx, key, value, expiration).Err()
@@ -45,4 +46,62 @@ func (r *RedisService) Set(ctx context.Context, key string, value interface{}, e
 // Get retrieves the value for a given key from Redis.
 func (r *RedisService) Get(ctx context.Context, key string) (string, error) {
 	return r.Client.Get(ctx, key).Result()
-}
\ No newline at end of file
+}
+
+// SetNX stores a key-value pair only if the key does not exist yet.
+func (r *RedisService) SetNX(ctx context.Context, key string, value interface{}, expiration time.Duration) (bool, error) {
+	return r.Client.SetNX(ctx, key, value, expiration).Result()
+}
+
+// Delete removes a key from Redis.
+func (r *RedisService) Delete(ctx context.Context, key string) error {
+	return r.Client.Del(ctx, key).Err()
+}
+
+// Close terminates the Redis client.
+func (r *RedisService) Close() error {
+	return r.Client.Close()
+}
+
+// redisStartKey holds the start time of a command in its context.
+type redisStartKey struct{}
+
+// redisMetricsHook observes the latency and errors of every Redis command,
+// including those sent through the client directly, such as scripts.
+type redisMetricsHook struct{}
+
+// BeforeProcess records when a command starts.
+func (redisMetricsHook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	return context.WithValue(ctx, redisStartKey{}, time.Now()), nil
+}
+
+// AfterProcess observes a command. A missing key is not an error.
+func (redisMetricsHook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	if start, ok := ctx.Value(redisStartKey{}).(time.Time); ok {
+		err := cmd.Err()
+		if errors.Is(err, redis.Nil) {
+			err = nil
+		}
+		metrics.ObserveDependency("redis", cmd.Name(), time.Since(start), err)
+	}
+	return nil
+}
+
+// BeforeProcessPipeline records when a pipeline starts.
+func (redisMetricsHook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	return context.WithValue(ctx, redisStartKey{}, time.Now()), nil
+}
+
+// AfterProcessPipeline observes a pipeline as a whole.
+func (redisMetricsHook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	if start, ok := ctx.Value(redisStartKey{}).(time.Time); ok {
+		var err error
+		for _, cmd := range cmds {
+			if cmdErr := cmd.Err(); cmdErr != nil && !errors.Is(cmdErr, redis.Nil) {
+				err = cmdErr
+			}
+		}
+		metrics.ObserveDependency("redis", "pipeline", time.Since(start), err)
+	}
+	return nil
+}
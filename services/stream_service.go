@@ -0,0 +1,431 @@
+// services/stream_service.go
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"crypto-exchange/config"
+	"crypto-exchange/events"
+	"crypto-exchange/models"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/rs/zerolog"
+	"github.com/segmentio/kafka-go"
+)
+
+// ErrUnknownChannel is returned when subscribing to a channel that does not exist.
+var ErrUnknownChannel = errors.New("unknown channel")
+
+// Reasons a subscriber is dropped, reported by StreamSubscriber.Err.
+var (
+	ErrSubscriberTooSlow = errors.New("subscriber too slow")
+	ErrStreamClosed      = errors.New("stream closed")
+)
+
+// Stream channels. Clients subscribe to "transactions" for their own
+// transactions and to "market:<SYMBOL>" for the activity of a symbol.
+const (
+	TransactionsChannel = "transactions"
+	MarketChannelPrefix = "market:"
+)
+
+// Redis keys of the stream sequence numbers.
+const (
+	streamSeqKeyPrefix   = "stream:seq:"
+	streamEventKeyPrefix = "stream:event:"
+)
+
+// streamEventTTL is how long the sequence number of an event is remembered, so
+// that replicas receiving the event late still agree on it.
+const streamEventTTL = time.Hour
+
+// nextStreamSeqScript returns the sequence number of an event in a topic,
+// assigning the next one if no replica did so yet.
+var nextStreamSeqScript = redis.NewScript(`
+local seq = redis.call('GET', KEYS[2])
+if seq then
+	return tonumber(seq)
+end
+seq = redis.call('INCR', KEYS[1])
+redis.call('SET', KEYS[2], seq, 'EX', ARGV[1])
+return seq
+`)
+
+// StreamSequencer numbers the updates of each stream topic. Every replica
+// receives every event, so all of them must get the same number for it.
+type StreamSequencer interface {
+	Next(ctx context.Context, topic, eventID string) (int64, error)
+	Current(ctx context.Context, topic string) (int64, error)
+}
+
+// RedisStreamSequencer keeps the sequence numbers in Redis.
+type RedisStreamSequencer struct {
+	RedisService *RedisService
+}
+
+// NewRedisStreamSequencer initializes a new RedisStreamSequencer.
+func NewRedisStreamSequencer(redisSvc *RedisService) *RedisStreamSequencer {
+	return &RedisStreamSequencer{RedisService: redisSvc}
+}
+
+// Next returns the sequence number of an event in a topic.
+func (s *RedisStreamSequencer) Next(ctx context.Context, topic, eventID string) (int64, error) {
+	keys := []string{streamSeqKeyPrefix + topic, streamEventKeyPrefix + topic + ":" + eventID}
+	return nextStreamSeqScript.Run(ctx, s.RedisService.Client, keys, int(streamEventTTL.Seconds())).Int64()
+}
+
+// Current returns the sequence number of the latest event in a topic.
+func (s *RedisStreamSequencer) Current(ctx context.Context, topic string) (int64, error) {
+	value, err := s.RedisService.Get(ctx, streamSeqKeyPrefix+topic)
+	if errors.Is(err, redis.Nil) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(value, 10, 64)
+}
+
+// MockStreamSequencer keeps the sequence numbers in memory. There is a single
+// replica, so every event it is given is a new one.
+type MockStreamSequencer struct {
+	seqs  map[string]int64
+	mutex sync.Mutex
+}
+
+// NewMockStreamSequencer creates a new instance of MockStreamSequencer.
+func NewMockStreamSequencer() *MockStreamSequencer {
+	return &MockStreamSequencer{seqs: make(map[string]int64)}
+}
+
+// Next returns the next sequence number of a topic.
+func (s *MockStreamSequencer) Next(ctx context.Context, topic, eventID string) (int64, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.seqs[topic]++
+	return s.seqs[topic], nil
+}
+
+// Current returns the sequence number of the latest event in a topic.
+func (s *MockStreamSequencer) Current(ctx context.Context, topic string) (int64, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.seqs[topic], nil
+}
+
+// StreamSubscriber is one WebSocket connection. Its messages are queued on
+// Send; Done is closed when the hub drops it for not keeping up or because
+// the hub is closed.
+type StreamSubscriber struct {
+	UserID  uint
+	Send    chan models.StreamMessage
+	Done    chan struct{}
+	topics  map[string]string // channel -> topic
+	dropped bool
+	err     error
+}
+
+// Err returns why the subscriber was dropped. It must only be called once
+// Done is closed.
+func (s *StreamSubscriber) Err() error {
+	return s.err
+}
+
+// streamSubscription is a subscriber's interest in a topic. Updates are held
+// back until the snapshot of the channel has been queued.
+type streamSubscription struct {
+	channel string
+	ready   bool
+	pending []models.StreamMessage
+}
+
+// StreamHub fans transaction events out to the WebSocket subscribers of this
+// replica. It is fed by the transaction events on Kafka, which every replica
+// consumes, so a client can be served by any of them.
+type StreamHub struct {
+	Logger       zerolog.Logger
+	Sequencer    StreamSequencer
+	Transactions TransactionService
+	MarketData   MarketDataService
+	Config       config.StreamConfig
+	subscribers  map[string]map[*StreamSubscriber]*streamSubscription // by topic
+	connected    map[*StreamSubscriber]struct{}
+	closed       bool
+	mutex        sync.Mutex
+}
+
+// NewStreamHub initializes a new StreamHub.
+func NewStreamHub(logger zerolog.Logger, sequencer StreamSequencer, txService TransactionService, marketData MarketDataService, cfg config.StreamConfig) *StreamHub {
+	return &StreamHub{
+		Logger:       logger,
+		Sequencer:    sequencer,
+		Transactions: txService,
+		MarketData:   marketData,
+		Config:       cfg,
+		subscribers:  make(map[string]map[*StreamSubscriber]*streamSubscription),
+		connected:    make(map[*StreamSubscriber]struct{}),
+	}
+}
+
+// NewStreamConsumer initializes a KafkaConsumer that feeds a StreamHub from the
+// transactions topic. Every replica must see every event, so each one reads
+// all partitions itself, without a consumer group, starting at the newest
+// events; nothing is committed, so replicas leave no groups behind. Events are
+// not retried; subscribers notice the gap instead.
+func NewStreamConsumer(cfg config.KafkaConfig, logger zerolog.Logger, eventRegistry *events.Registry) *KafkaConsumer {
+	consumer := NewKafkaConsumer(cfg, logger, eventRegistry)
+	consumer.GroupID = ""
+	consumer.Topics = []string{cfg.Topic}
+	consumer.RetryDelays = nil
+	consumer.StartOffset = kafka.LastOffset
+	return consumer
+}
+
+// Register adds a subscriber without any subscriptions. Once the hub is
+// closed, the subscriber is dropped right away.
+func (h *StreamHub) Register(userID uint) *StreamSubscriber {
+	sub := &StreamSubscriber{
+		UserID: userID,
+		Send:   make(chan models.StreamMessage, h.Config.SendBuffer),
+		Done:   make(chan struct{}),
+		topics: make(map[string]string),
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if h.closed {
+		h.drop(sub, ErrStreamClosed)
+		return sub
+	}
+	h.connected[sub] = struct{}{}
+	return sub
+}
+
+// Unregister removes a subscriber and all of its subscriptions.
+func (h *StreamHub) Unregister(sub *StreamSubscriber) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	for channel := range sub.topics {
+		h.remove(sub, channel)
+	}
+	delete(h.connected, sub)
+}
+
+// Close drops every subscriber, so that their connections are closed and the
+// clients reconnect to another replica.
+func (h *StreamHub) Close() error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.closed = true
+	for sub := range h.connected {
+		h.drop(sub, ErrStreamClosed)
+	}
+	h.Logger.Info().Int("subscribers", len(h.connected)).Msg("Stream hub closed")
+	return nil
+}
+
+// Subscribe subscribes to a channel, or resubscribes to it, and queues its
+// snapshot. Updates published while the snapshot is taken are queued after it.
+func (h *StreamHub) Subscribe(ctx context.Context, sub *StreamSubscriber, channel string) error {
+	channel, topic, err := streamChannel(sub.UserID, channel)
+	if err != nil {
+		return err
+	}
+
+	h.mutex.Lock()
+	h.remove(sub, channel)
+	if h.subscribers[topic] == nil {
+		h.subscribers[topic] = make(map[*StreamSubscriber]*streamSubscription)
+	}
+	subscription := &streamSubscription{channel: channel}
+	h.subscribers[topic][sub] = subscription
+	sub.topics[channel] = topic
+	h.mutex.Unlock()
+
+	// Read the sequence number first: the snapshot is at least as recent
+	seq, err := h.Sequencer.Current(ctx, topic)
+	var data interface{}
+	if err == nil {
+		data, err = h.snapshot(ctx, sub.UserID, topic)
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if h.subscribers[topic][sub] != subscription {
+		// Unsubscribed or resubscribed in the meantime
+		return err
+	}
+	if err != nil {
+		h.remove(sub, channel)
+		return err
+	}
+	h.deliver(sub, models.StreamMessage{Type: models.StreamSnapshot, Channel: channel, Seq: seq, Data: data})
+	for _, msg := range subscription.pending {
+		if msg.Seq > seq {
+			h.deliver(sub, msg)
+		}
+	}
+	subscription.ready = true
+	subscription.pending = nil
+	return nil
+}
+
+// Unsubscribe ends a subscription and confirms it to the subscriber.
+func (h *StreamHub) Unsubscribe(sub *StreamSubscriber, channel string) error {
+	channel, _, err := streamChannel(sub.UserID, channel)
+	if err != nil {
+		return err
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.remove(sub, channel)
+	h.deliver(sub, models.StreamMessage{Type: models.StreamUnsubscribed, Channel: channel})
+	return nil
+}
+
+// Reply queues a message for a single subscriber.
+func (h *StreamHub) Reply(sub *StreamSubscriber, msg models.StreamMessage) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.deliver(sub, msg)
+}
+
+// HandleEvent publishes a transaction event to the owner's transactions
+// channel and to the market channel of its symbol. Errors are logged rather
+// than returned: the event is then missing from the stream, which subscribers
+// detect by the gap in sequence numbers.
+func (h *StreamHub) HandleEvent(ctx context.Context, env events.Envelope) error {
+	var tx models.Transaction
+	var err error
+	switch env.Type {
+	case events.TransactionCreated:
+		err = json.Unmarshal(env.Payload, &tx)
+	case events.TransactionStatusChanged:
+		var changed events.StatusChanged
+		err = json.Unmarshal(env.Payload, &changed)
+		tx = changed.Transaction
+	default:
+		return nil
+	}
+	if err != nil {
+		h.Logger.Error().Err(err).Str("event_id", env.EventID).Msg("Failed to decode transaction event for streaming")
+		return nil
+	}
+
+	h.publish(ctx, env, userTopic(tx.UserID), json.RawMessage(env.Payload))
+	h.publish(ctx, env, MarketChannelPrefix+tx.CryptoSymbol, models.MarketActivity{
+		Symbol:       tx.CryptoSymbol,
+		Type:         tx.Type,
+		Status:       tx.Status,
+		Amount:       tx.Amount,
+		CryptoAmount: tx.CryptoAmount,
+		UpdatedAt:    tx.UpdatedAt,
+	})
+	return nil
+}
+
+// publish numbers an update of a topic and queues it for the topic's subscribers.
+// The number is taken even without subscribers, to stay in step with other replicas.
+func (h *StreamHub) publish(ctx context.Context, env events.Envelope, topic string, data interface{}) {
+	seq, err := h.Sequencer.Next(ctx, topic, env.EventID)
+	if err != nil {
+		h.Logger.Error().Err(err).Str("topic", topic).Str("event_id", env.EventID).Msg("Failed to sequence stream update")
+		return
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	for sub, subscription := range h.subscribers[topic] {
+		msg := models.StreamMessage{Type: models.StreamUpdate, Channel: subscription.channel, Seq: seq, Event: env.Type, Data: data}
+		if !subscription.ready {
+			subscription.pending = append(subscription.pending, msg)
+			continue
+		}
+		h.deliver(sub, msg)
+	}
+}
+
+// snapshot returns the current state of a topic.
+func (h *StreamHub) snapshot(ctx context.Context, userID uint, topic string) (interface{}, error) {
+	if symbol, ok := strings.CutPrefix(topic, MarketChannelPrefix); ok {
+		ticker, err := h.MarketData.GetTicker(symbol)
+		switch {
+		case errors.Is(err, ErrMarketNotFound):
+			return nil, fmt.Errorf("%w: %s", ErrUnknownChannel, MarketChannelPrefix+symbol)
+		case errors.Is(err, ErrMarketDataUnavailable):
+			return nil, nil
+		case err != nil:
+			return nil, err
+		}
+		return ticker, nil
+	}
+
+	// Read PostgreSQL rather than the history read model, which may lag
+	// behind the sequence number
+	return h.Transactions.RecentTransactions(ctx, userID, h.Config.SnapshotSize)
+}
+
+// deliver queues a message without blocking. A subscriber whose queue is full
+// is dropped; it reconnects and resubscribes. The caller must hold h.mutex.
+func (h *StreamHub) deliver(sub *StreamSubscriber, msg models.StreamMessage) {
+	if sub.dropped {
+		return
+	}
+	select {
+	case sub.Send <- msg:
+	default:
+		h.Logger.Warn().Uint("user_id", sub.UserID).Msg("Stream subscriber is too slow, dropping it")
+		h.drop(sub, ErrSubscriberTooSlow)
+	}
+}
+
+// drop closes a subscriber's Done channel, unless it was dropped already. The
+// caller must hold h.mutex.
+func (h *StreamHub) drop(sub *StreamSubscriber, reason error) {
+	if sub.dropped {
+		return
+	}
+	sub.dropped = true
+	sub.err = reason
+	close(sub.Done)
+}
+
+// remove ends a subscription. The caller must hold h.mutex.
+func (h *StreamHub) remove(sub *StreamSubscriber, channel string) {
+	topic, ok := sub.topics[channel]
+	if !ok {
+		return
+	}
+	delete(sub.topics, channel)
+	delete(h.subscribers[topic], sub)
+	if len(h.subscribers[topic]) == 0 {
+		delete(h.subscribers, topic)
+	}
+}
+
+// streamChannel returns the canonical name of a channel and the topic behind
+// it. The transactions channel is per user, so that subscribers only ever see
+// their own transactions.
+func streamChannel(userID uint, channel string) (string, string, error) {
+	if channel == TransactionsChannel && userID != 0 {
+		return channel, userTopic(userID), nil
+	}
+	if symbol, ok := strings.CutPrefix(channel, MarketChannelPrefix); ok && symbol != "" {
+		channel = MarketChannelPrefix + strings.ToUpper(symbol)
+		return channel, channel, nil
+	}
+	return "", "", fmt.Errorf("%w: %s", ErrUnknownChannel, channel)
+}
+
+// userTopic returns the topic of a user's transactions.
+func userTopic(userID uint) string {
+	return "user:" + events.UserKey(userID) + ":transactions"
+}
@@ -0,0 +1,299 @@
+// services/token_service.go
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"crypto-exchange/apperrors"
+	"crypto-exchange/config"
+	"crypto-exchange/events"
+	"crypto-exchange/models"
+	"crypto-exchange/policy"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+var (
+	// ErrInvalidToken is returned for tokens that are malformed, expired, signed
+	// with an unknown key or of the wrong type.
+	ErrInvalidToken = apperrors.New(apperrors.ErrUnauthorized, "invalid_token", "invalid token")
+	// ErrTokenRevoked is returned for tokens that were revoked, including
+	// refresh tokens that were already exchanged.
+	ErrTokenRevoked = apperrors.New(apperrors.ErrUnauthorized, "token_revoked", "token revoked")
+	// ErrInvalidCredentials is returned when an email and password do not match.
+	ErrInvalidCredentials = apperrors.New(apperrors.ErrUnauthorized, "invalid_credentials", "invalid credentials")
+)
+
+// Token types, stored in the typ claim so that one kind cannot stand in for the other.
+const (
+	AccessTokenType  = "access"
+	RefreshTokenType = "refresh"
+)
+
+// revokedTokenKeyPrefix prefixes the Redis keys of revoked token IDs.
+const revokedTokenKeyPrefix = "auth:revoked:"
+
+// TokenClaims are the claims of access and refresh tokens. The subject is the
+// user ID; the role is the user's when the token was issued, for clients only:
+// Authenticate acts with the user's current role.
+type TokenClaims struct {
+	jwt.RegisteredClaims
+	Type string `json:"typ"`
+	Role string `json:"role,omitempty"`
+}
+
+// UserID returns the user the token was issued to.
+func (c *TokenClaims) UserID() (uint, error) {
+	id, err := strconv.ParseUint(c.Subject, 10, 32)
+	if err != nil || id == 0 {
+		return 0, fmt.Errorf("%w: subject is not a user ID", ErrInvalidToken)
+	}
+	return uint(id), nil
+}
+
+// CredentialVerifier checks login credentials and returns the user they belong to.
+type CredentialVerifier interface {
+	VerifyCredentials(email, password string) (uint, error)
+}
+
+// AccountLookup returns the account tokens are issued to.
+type AccountLookup interface {
+	GetUser(id uint) (models.User, error)
+}
+
+// TokenRevocationService stores the IDs of revoked tokens until they expire.
+type TokenRevocationService interface {
+	// Revoke revokes a token and reports whether it was not revoked before.
+	Revoke(tokenID string, expiresAt time.Time) (bool, error)
+	IsRevoked(tokenID string) (bool, error)
+}
+
+// TokenService issues and verifies access and refresh tokens.
+type TokenService struct {
+	Config      config.JWTConfig
+	Revocations TokenRevocationService
+	Accounts    AccountLookup
+	keys        map[string][]byte // by kid
+	parser      *jwt.Parser
+}
+
+// NewTokenService initializes a new TokenService with the current and retired
+// signing keys. Tokens carry the role accounts have when they are issued.
+func NewTokenService(cfg config.JWTConfig, revocations TokenRevocationService, accounts AccountLookup) *TokenService {
+	keys := map[string][]byte{cfg.KeyID: []byte(cfg.SecretKey)}
+	for _, key := range cfg.RetiredKeys {
+		if _, exists := keys[key.KeyID]; !exists {
+			keys[key.KeyID] = []byte(key.SecretKey)
+		}
+	}
+	return &TokenService{
+		Config:      cfg,
+		Revocations: revocations,
+		Accounts:    accounts,
+		keys:        keys,
+		parser: jwt.NewParser(
+			jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+			jwt.WithExpirationRequired(),
+			jwt.WithIssuedAt(),
+		),
+	}
+}
+
+// IssueTokens issues a new access and refresh token for an active user, with
+// the user's current role.
+func (s *TokenService) IssueTokens(userID uint) (models.TokenPair, error) {
+	user, err := s.ActiveUser(userID)
+	if err != nil {
+		return models.TokenPair{}, err
+	}
+
+	access, err := s.sign(user, AccessTokenType, s.Config.TokenDuration)
+	if err != nil {
+		return models.TokenPair{}, err
+	}
+	refresh, err := s.sign(user, RefreshTokenType, s.Config.RefreshTokenDuration)
+	if err != nil {
+		return models.TokenPair{}, err
+	}
+	return models.TokenPair{
+		AccessToken:      access,
+		RefreshToken:     refresh,
+		TokenType:        "Bearer",
+		ExpiresIn:        int64(s.Config.TokenDuration.Seconds()),
+		RefreshExpiresIn: int64(s.Config.RefreshTokenDuration.Seconds()),
+	}, nil
+}
+
+// ActiveUser returns a user, or ErrUserNotActive if the account is frozen or closed.
+func (s *TokenService) ActiveUser(userID uint) (models.User, error) {
+	user, err := s.Accounts.GetUser(userID)
+	if err != nil {
+		return models.User{}, err
+	}
+	if user.Status != models.UserStatusActive {
+		return models.User{}, fmt.Errorf("%w: user is %s", ErrUserNotActive, user.Status)
+	}
+	return user, nil
+}
+
+// Authenticate verifies an access token and returns its claims and the actor
+// it acts as. The account is looked up on every call, so that a frozen
+// account or a changed role applies at once rather than when the token expires.
+func (s *TokenService) Authenticate(accessToken string) (*TokenClaims, policy.Actor, error) {
+	claims, err := s.Verify(accessToken, AccessTokenType)
+	if err != nil {
+		return nil, policy.Actor{}, err
+	}
+	userID, _ := claims.UserID()
+	user, err := s.ActiveUser(userID)
+	if errors.Is(err, ErrUserNotFound) {
+		return nil, policy.Actor{}, fmt.Errorf("%w: unknown user", ErrInvalidToken)
+	}
+	if err != nil {
+		return nil, policy.Actor{}, err
+	}
+	return claims, policy.Actor{UserID: user.ID, Role: user.Role}, nil
+}
+
+// Verify checks the signature, expiry, type and revocation of a token.
+func (s *TokenService) Verify(tokenString, tokenType string) (*TokenClaims, error) {
+	var claims TokenClaims
+	_, err := s.parser.ParseWithClaims(tokenString, &claims, s.key)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	if claims.Type != tokenType || claims.ID == "" {
+		return nil, fmt.Errorf("%w: not a %s token", ErrInvalidToken, tokenType)
+	}
+	if _, err := claims.UserID(); err != nil {
+		return nil, err
+	}
+
+	revoked, err := s.Revocations.IsRevoked(claims.ID)
+	if err != nil {
+		return nil, err
+	}
+	if revoked {
+		return nil, ErrTokenRevoked
+	}
+	return &claims, nil
+}
+
+// Refresh exchanges a refresh token for a new token pair, which picks up
+// changes of the user's role and status. Refresh tokens are single-use: the
+// exchanged token is revoked, and only one of several concurrent exchanges of
+// the same token succeeds.
+func (s *TokenService) Refresh(refreshToken string) (models.TokenPair, error) {
+	claims, err := s.Verify(refreshToken, RefreshTokenType)
+	if err != nil {
+		return models.TokenPair{}, err
+	}
+	first, err := s.Revocations.Revoke(claims.ID, claims.ExpiresAt.Time)
+	if err != nil {
+		return models.TokenPair{}, err
+	}
+	if !first {
+		return models.TokenPair{}, ErrTokenRevoked
+	}
+	userID, _ := claims.UserID()
+	return s.IssueTokens(userID)
+}
+
+// Revoke revokes a verified token until it expires.
+func (s *TokenService) Revoke(claims *TokenClaims) error {
+	_, err := s.Revocations.Revoke(claims.ID, claims.ExpiresAt.Time)
+	return err
+}
+
+// sign creates a token of a type for a user, signed with the current key.
+func (s *TokenService) sign(user models.User, tokenType string, duration time.Duration) (string, error) {
+	now := time.Now()
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, TokenClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.NewString(),
+			Subject:   events.UserKey(user.ID),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(duration)),
+		},
+		Type: tokenType,
+		Role: user.Role,
+	})
+	token.Header["kid"] = s.Config.KeyID
+	return token.SignedString(s.keys[s.Config.KeyID])
+}
+
+// key returns the verification key named by a token's kid.
+func (s *TokenService) key(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	key, ok := s.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	return key, nil
+}
+
+// TokenRevocationServiceRedis stores revoked token IDs in Redis, each expiring
+// together with its token.
+type TokenRevocationServiceRedis struct {
+	RedisService *RedisService
+}
+
+// NewTokenRevocationService initializes a new TokenRevocationServiceRedis.
+func NewTokenRevocationService(redisSvc *RedisService) *TokenRevocationServiceRedis {
+	return &TokenRevocationServiceRedis{RedisService: redisSvc}
+}
+
+// Revoke revokes a token until it expires.
+func (s *TokenRevocationServiceRedis) Revoke(tokenID string, expiresAt time.Time) (bool, error) {
+	ttl := time.Until(expiresAt)
+	if ttl <= 0 {
+		// Expired tokens are rejected anyway
+		return true, nil
+	}
+	return s.RedisService.SetNX(context.Background(), revokedTokenKeyPrefix+tokenID, 1, ttl)
+}
+
+// IsRevoked reports whether a token was revoked.
+func (s *TokenRevocationServiceRedis) IsRevoked(tokenID string) (bool, error) {
+	n, err := s.RedisService.Client.Exists(context.Background(), revokedTokenKeyPrefix+tokenID).Result()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// MockTokenRevocationService stores revoked token IDs in memory.
+type MockTokenRevocationService struct {
+	revoked map[string]time.Time
+	mutex   sync.Mutex
+}
+
+// NewMockTokenRevocationService creates a new instance of MockTokenRevocationService.
+func NewMockTokenRevocationService() *MockTokenRevocationService {
+	return &MockTokenRevocationService{revoked: make(map[string]time.Time)}
+}
+
+// Revoke revokes a token until it expires.
+func (s *MockTokenRevocationService) Revoke(tokenID string, expiresAt time.Time) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, exists := s.revoked[tokenID]; exists {
+		return false, nil
+	}
+	s.revoked[tokenID] = expiresAt
+	return true, nil
+}
+
+// IsRevoked reports whether a token was revoked.
+func (s *MockTokenRevocationService) IsRevoked(tokenID string) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	_, exists := s.revoked[tokenID]
+	return exists, nil
+}
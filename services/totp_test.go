@@ -0,0 +1,175 @@
+// services/totp_test.go
+package services
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"crypto-exchange/config"
+	"crypto-exchange/models"
+
+	"github.com/rs/zerolog"
+)
+
+// rfc6238Secret is the SHA-1 seed of the RFC 6238 test vectors.
+var rfc6238Secret = base32NoPadding.EncodeToString([]byte("12345678901234567890"))
+
+func TestTOTPCodeRFC6238(t *testing.T) {
+	// RFC 6238 appendix B lists 8-digit codes; 6-digit codes are their last six digits
+	vectors := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, v := range vectors {
+		code, err := totpCode(rfc6238Secret, totpStep(time.Unix(v.unix, 0)))
+		if err != nil {
+			t.Fatalf("totpCode(%d): %v", v.unix, err)
+		}
+		if code != v.code {
+			t.Errorf("totpCode(%d) = %s, want %s", v.unix, code, v.code)
+		}
+	}
+}
+
+func TestMatchTOTPSkew(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	current := totpStep(now)
+	tests := []struct {
+		name   string
+		offset int64
+		skew   int
+		ok     bool
+	}{
+		{"current step", 0, 0, true},
+		{"previous step without skew", -1, 0, false},
+		{"previous step", -1, 1, true},
+		{"next step", 1, 1, true},
+		{"two steps behind", -2, 1, false},
+		{"two steps ahead", 2, 1, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, err := totpCode(rfc6238Secret, current+tt.offset)
+			if err != nil {
+				t.Fatal(err)
+			}
+			step, ok, err := matchTOTP(rfc6238Secret, code, now, tt.skew, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok != tt.ok {
+				t.Fatalf("matchTOTP ok = %v, want %v", ok, tt.ok)
+			}
+			if ok && step != current+tt.offset {
+				t.Fatalf("matchTOTP step = %d, want %d", step, current+tt.offset)
+			}
+		})
+	}
+}
+
+func TestMatchTOTPRejectsUsedSteps(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	current := totpStep(now)
+	code, err := totpCode(rfc6238Secret, current)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, _ := matchTOTP(rfc6238Secret, code, now, 1, current); ok {
+		t.Fatal("code of the last used step accepted")
+	}
+	previous, err := totpCode(rfc6238Secret, current-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, _ := matchTOTP(rfc6238Secret, previous, now, 1, current); ok {
+		t.Fatal("code older than the last used step accepted")
+	}
+}
+
+// newTestTwoFactor returns a mock two-factor service on a fixed clock and a
+// user who enabled two-factor authentication with the returned secret and
+// recovery codes.
+func newTestTwoFactor(t *testing.T, txs *MockTransactionService, now *time.Time) (*MockTwoFactorService, uint, string, []string) {
+	t.Helper()
+	users := NewMockUserService(txs, LogEmailSender{Logger: zerolog.Nop()}, config.UsersConfig{VerificationTTL: time.Hour})
+	tfa := NewMockTwoFactorService(txs, users, config.TwoFactorConfig{Issuer: "Test", Skew: 1, RecoveryCodes: 2})
+	tfa.Now = func() time.Time { return *now }
+
+	user, err := users.Register(models.RegisterRequest{Email: "alice@example.com", Password: "correct horse battery"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	enrollment, err := tfa.Enroll(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, err := totpCode(enrollment.Secret, totpStep(*now))
+	if err != nil {
+		t.Fatal(err)
+	}
+	recovery, err := tfa.Enable(user.ID, code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tfa, user.ID, enrollment.Secret, recovery.RecoveryCodes
+}
+
+func TestVerifyStepUpFixedClock(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	tfa, userID, secret, _ := newTestTwoFactor(t, NewMockTransactionService(NewMockLedgerService(), nil), &now)
+
+	// The code that enabled two-factor authentication is spent
+	code, _ := totpCode(secret, totpStep(now))
+	if err := tfa.VerifyStepUp(userID, code); !errors.Is(err, ErrInvalidTwoFactorCode) {
+		t.Fatalf("reused code: got %v, want ErrInvalidTwoFactorCode", err)
+	}
+
+	// The next step's code is accepted once, within the skew
+	next, _ := totpCode(secret, totpStep(now)+1)
+	if err := tfa.VerifyStepUp(userID, next); err != nil {
+		t.Fatalf("next step code: %v", err)
+	}
+	if err := tfa.VerifyStepUp(userID, next); !errors.Is(err, ErrInvalidTwoFactorCode) {
+		t.Fatalf("reused next step code: got %v, want ErrInvalidTwoFactorCode", err)
+	}
+
+	// Codes outside the skew are rejected
+	now = now.Add(10 * totpPeriod * time.Second)
+	stale, _ := totpCode(secret, totpStep(now)-2)
+	if err := tfa.VerifyStepUp(userID, stale); !errors.Is(err, ErrInvalidTwoFactorCode) {
+		t.Fatalf("stale code: got %v, want ErrInvalidTwoFactorCode", err)
+	}
+	current, _ := totpCode(secret, totpStep(now))
+	if err := tfa.VerifyStepUp(userID, current); err != nil {
+		t.Fatalf("current code: %v", err)
+	}
+}
+
+func TestVerifyStepUpRecoveryCodes(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	tfa, userID, _, recovery := newTestTwoFactor(t, NewMockTransactionService(NewMockLedgerService(), nil), &now)
+
+	// Recovery codes are accepted however they are typed, but only once
+	typed := strings.ToLower(strings.ReplaceAll(recovery[0], "-", " "))
+	if err := tfa.VerifyStepUp(userID, typed); err != nil {
+		t.Fatalf("recovery code: %v", err)
+	}
+	if err := tfa.VerifyStepUp(userID, recovery[0]); !errors.Is(err, ErrInvalidTwoFactorCode) {
+		t.Fatalf("spent recovery code: got %v, want ErrInvalidTwoFactorCode", err)
+	}
+	if err := tfa.VerifyStepUp(userID, recovery[1]); err != nil {
+		t.Fatalf("second recovery code: %v", err)
+	}
+	if err := tfa.VerifyStepUp(userID, "AAAAA-AAAAA"); !errors.Is(err, ErrInvalidTwoFactorCode) {
+		t.Fatalf("unknown recovery code: got %v, want ErrInvalidTwoFactorCode", err)
+	}
+}
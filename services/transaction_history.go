@@ -0,0 +1,65 @@
+// services/transaction_history.go
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"crypto-exchange/config"
+	"crypto-exchange/events"
+	"crypto-exchange/logging"
+	"crypto-exchange/models"
+	"crypto-exchange/tracing"
+
+	"github.com/rs/zerolog"
+)
+
+// NewHistoryConsumer returns the consumer of the transactions topic that
+// feeds the transaction history read model. All API nodes share its consumer
+// group, so every event is projected once; failed events are retried through
+// the transactions topic's own retry topics.
+func NewHistoryConsumer(cfg config.KafkaConfig, logger zerolog.Logger, eventRegistry *events.Registry) *KafkaConsumer {
+	consumer := NewKafkaConsumer(cfg, logger, eventRegistry)
+	consumer.GroupID = cfg.Consumer.GroupID + ".history"
+	consumer.Topics = []string{cfg.Topic}
+	return consumer
+}
+
+// NewHistoryProjector returns the handler of transaction events that writes
+// the state of the transaction they carry to the history read model in
+// Cassandra. Projecting an event again is harmless, and events handled out of
+// order do not roll a transaction back to an older state.
+func NewHistoryProjector(cassandraSvc *CassandraService, logger zerolog.Logger) EventHandler {
+	return func(ctx context.Context, env events.Envelope) error {
+		var tx models.Transaction
+		switch env.Type {
+		case events.TransactionCreated:
+			if err := json.Unmarshal(env.Payload, &tx); err != nil {
+				return fmt.Errorf("%w: %v", ErrNonRetryable, err)
+			}
+		case events.TransactionStatusChanged:
+			var changed events.StatusChanged
+			if err := json.Unmarshal(env.Payload, &changed); err != nil {
+				return fmt.Errorf("%w: %v", ErrNonRetryable, err)
+			}
+			tx = changed.Transaction
+		default:
+			return fmt.Errorf("%w: cannot project %s events", ErrNonRetryable, env.Type)
+		}
+
+		err := tracing.Store(ctx, "cassandra", "upsert transaction history", func(ctx context.Context) error {
+			return cassandraSvc.UpsertHistory(ctx, tx, env.OccurredAt)
+		})
+		if err != nil {
+			return err
+		}
+		log := logging.FromContext(ctx, logger)
+		log.Debug().
+			Str("transaction_id", tx.ID).
+			Str("status", tx.Status).
+			Str("event_id", env.EventID).
+			Msg("Transaction history updated")
+		return nil
+	}
+}
@@ -0,0 +1,137 @@
+// services/transaction_list.go
+package services
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"crypto-exchange/apperrors"
+	"crypto-exchange/models"
+
+	"github.com/shopspring/decimal"
+)
+
+// ErrInvalidFilter is returned when the listing filter or cursor cannot be parsed.
+var ErrInvalidFilter = apperrors.New(apperrors.ErrValidation, "invalid_filter", "invalid transaction filter")
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 100
+)
+
+// listQuery is a parsed TransactionFilter.
+type listQuery struct {
+	models.TransactionFilter
+	minAmount *decimal.Decimal
+	maxAmount *decimal.Decimal
+	after     *pageCursor
+	limit     int
+}
+
+// pageCursor is the position of the last transaction of a page.
+type pageCursor struct {
+	CreatedAt int64
+	ID        string
+}
+
+// parseFilter validates a TransactionFilter and decodes its amounts and cursor.
+func parseFilter(f models.TransactionFilter) (listQuery, error) {
+	q := listQuery{TransactionFilter: f, limit: f.Limit}
+	q.CryptoSymbol = strings.ToUpper(f.CryptoSymbol)
+	if q.limit <= 0 {
+		q.limit = defaultPageSize
+	}
+	if q.limit > maxPageSize {
+		q.limit = maxPageSize
+	}
+
+	if f.MinAmount != "" {
+		d, err := decimal.NewFromString(f.MinAmount)
+		if err != nil {
+			return listQuery{}, fmt.Errorf("%w: min_amount: %v", ErrInvalidFilter, err)
+		}
+		q.minAmount = &d
+	}
+	if f.MaxAmount != "" {
+		d, err := decimal.NewFromString(f.MaxAmount)
+		if err != nil {
+			return listQuery{}, fmt.Errorf("%w: max_amount: %v", ErrInvalidFilter, err)
+		}
+		q.maxAmount = &d
+	}
+	if q.minAmount != nil && q.maxAmount != nil && q.minAmount.GreaterThan(*q.maxAmount) {
+		return listQuery{}, fmt.Errorf("%w: min_amount exceeds max_amount", ErrInvalidFilter)
+	}
+	if f.CreatedFrom != 0 && f.CreatedTo != 0 && f.CreatedFrom >= f.CreatedTo {
+		return listQuery{}, fmt.Errorf("%w: created_from must be before created_to", ErrInvalidFilter)
+	}
+
+	if f.Cursor != "" {
+		c, err := decodeCursor(f.Cursor)
+		if err != nil {
+			return listQuery{}, err
+		}
+		q.after = &c
+	}
+	return q, nil
+}
+
+// matches reports whether a transaction passes the filter, ignoring the cursor.
+func (q listQuery) matches(tx models.Transaction) bool {
+	switch {
+	case q.UserID != 0 && tx.UserID != q.UserID,
+		q.Type != "" && tx.Type != q.Type,
+		q.Status != "" && tx.Status != q.Status,
+		q.CryptoSymbol != "" && tx.CryptoSymbol != q.CryptoSymbol,
+		q.minAmount != nil && tx.Amount.LessThan(*q.minAmount),
+		q.maxAmount != nil && tx.Amount.GreaterThan(*q.maxAmount),
+		q.CreatedFrom != 0 && tx.CreatedAt < q.CreatedFrom,
+		q.CreatedTo != 0 && tx.CreatedAt >= q.CreatedTo:
+		return false
+	}
+	return true
+}
+
+// isAfter reports whether a transaction sorts after the cursor (newest first).
+func (c pageCursor) isAfter(tx models.Transaction) bool {
+	return tx.CreatedAt < c.CreatedAt || (tx.CreatedAt == c.CreatedAt && tx.ID < c.ID)
+}
+
+// newPage trims a result fetched with one extra row and sets the next cursor.
+func newPage(txs []models.Transaction, limit int) models.TransactionPage {
+	page := models.TransactionPage{Transactions: txs}
+	if len(txs) > limit {
+		page.Transactions = txs[:limit]
+		last := page.Transactions[limit-1]
+		page.NextCursor = encodeCursor(pageCursor{CreatedAt: last.CreatedAt, ID: last.ID})
+	}
+	if page.Transactions == nil {
+		page.Transactions = []models.Transaction{}
+	}
+	return page
+}
+
+// encodeCursor returns the opaque form of a cursor.
+func encodeCursor(c pageCursor) string {
+	raw := strconv.FormatInt(c.CreatedAt, 10) + ":" + c.ID
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// decodeCursor parses a cursor produced by encodeCursor.
+func decodeCursor(s string) (pageCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return pageCursor{}, fmt.Errorf("%w: malformed cursor", ErrInvalidFilter)
+	}
+	createdAt, id, ok := strings.Cut(string(raw), ":")
+	if !ok || id == "" {
+		return pageCursor{}, fmt.Errorf("%w: malformed cursor", ErrInvalidFilter)
+	}
+	ts, err := strconv.ParseInt(createdAt, 10, 64)
+	if err != nil {
+		return pageCursor{}, fmt.Errorf("%w: malformed cursor", ErrInvalidFilter)
+	}
+	return pageCursor{CreatedAt: ts, ID: id}, nil
+}
@@ -5,84 +5,149 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"strings"
 	"time"
 
+	"crypto-exchange/events"
+	"crypto-exchange/logging"
+	"crypto-exchange/metrics"
 	"crypto-exchange/models"
+	"crypto-exchange/money"
+	"crypto-exchange/policy"
+	"crypto-exchange/tracing"
 
+	"github.com/go-redis/redis/v8"
 	"github.com/rs/zerolog"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
+// transactionCache names the Redis cache of transactions in the cache metrics.
+const transactionCache = "transactions"
+
+// transactionCacheKey namespaces the cached copy of a transaction in Redis.
+func transactionCacheKey(id string) string {
+	return "tx:" + id
+}
+
 // TransactionServiceDB combines all services for transaction operations.
 type TransactionServiceDB struct {
 	DB           *gorm.DB
 	Logger       zerolog.Logger
 	RedisService *RedisService
 	CassandraSvc *CassandraService
-	KafkaService *KafkaService
+	Ledger       *LedgerServiceDB
+	Balances     *BalanceServiceDB
+	Assets       *money.Registry
+	Events       *events.Registry
+	StepUp       TxStepUpVerifier
 }
 
 // NewTransactionService initializes a new TransactionServiceDB.
-func NewTransactionService(db *gorm.DB, logger zerolog.Logger, redisSvc *RedisService, cassandraSvc *CassandraService, kafkaSvc *KafkaService) *TransactionServiceDB {
+func NewTransactionService(db *gorm.DB, logger zerolog.Logger, redisSvc *RedisService, cassandraSvc *CassandraService, ledgerSvc *LedgerServiceDB, balanceSvc *BalanceServiceDB, assets *money.Registry, eventRegistry *events.Registry, stepUp TxStepUpVerifier) *TransactionServiceDB {
 	return &TransactionServiceDB{
 		DB:           db,
 		Logger:       logger,
 		RedisService: redisSvc,
 		CassandraSvc: cassandraSvc,
-		KafkaService: kafkaSvc,
+		Ledger:       ledgerSvc,
+		Balances:     balanceSvc,
+		Assets:       assets,
+		Events:       eventRegistry,
+		StepUp:       stepUp,
 	}
 }
 
-// CreateTransaction handles the creation of a new transaction across multiple services.
-func (s *TransactionServiceDB) CreateTransaction(tx models.Transaction) (models.Transaction, error) {
+// CreateTransaction handles the creation of a new transaction across multiple
+// services. Each store call is traced in a child span of the span in ctx.
+func (s *TransactionServiceDB) CreateTransaction(ctx context.Context, tx models.Transaction) (models.Transaction, error) {
+	log := logging.FromContext(ctx, s.Logger)
+	// Round amounts to the precision of their asset
+	tx, err := normalizeAmounts(s.Assets, tx)
+	if err != nil {
+		return models.Transaction{}, err
+	}
+	// Transactions are settled by the system only; withdrawals wait in
+	// pending for the two-factor step-up
+	if tx.Status != models.StatusPending {
+		return models.Transaction{}, errNotPending
+	}
+	tx.StepUpAt = nil
+	// Timestamps are the store's, whatever the payload says
+	now := time.Now().Unix()
+	tx.CreatedAt, tx.UpdatedAt, tx.DeletedAt = now, now, 0
+
 	// Start a database transaction
-	txDB := s.DB.Begin()
+	txDB := s.DB.WithContext(ctx).Begin()
 	if txDB.Error != nil {
-		s.Logger.Error().Err(txDB.Error).Msg("Failed to start DB transaction")
+		log.Error().Err(txDB.Error).Msg("Failed to start DB transaction")
 		return models.Transaction{}, txDB.Error
 	}
 
-	// Create the transaction in PostgreSQL
-	if err := txDB.Create(&tx).Error; err != nil {
-		s.Logger.Error().Err(err).Msg("Failed to create transaction in PostgreSQL")
+	// Frozen and closed accounts cannot move funds
+	err = tracing.Store(ctx, "postgresql", "check user", func(context.Context) error {
+		return checkUserActive(txDB, tx.UserID)
+	})
+	if err != nil {
+		log.Warn().Err(err).Str("transaction_id", tx.ID).Msg("Transaction rejected")
 		txDB.Rollback()
 		return models.Transaction{}, err
 	}
 
-	// Insert into Cassandra
-	if err := s.CassandraSvc.InsertTransaction(tx); err != nil {
-		s.Logger.Error().Err(err).Msg("Failed to create transaction in Cassandra")
-		txDB.Rollback()
-		return models.Transaction{}, err
+	// Withdrawals must be covered by the available balance
+	if reservesFunds(tx) {
+		err := tracing.Store(ctx, "postgresql", "reserve funds", func(context.Context) error {
+			return s.Balances.ReserveFunds(txDB, tx)
+		})
+		if err != nil {
+			log.Warn().Err(err).Str("transaction_id", tx.ID).Msg("Withdrawal rejected")
+			txDB.Rollback()
+			return models.Transaction{}, err
+		}
 	}
 
-	// Cache the transaction in Redis
-	ctx := context.Background()
-	txJSON, err := json.Marshal(tx)
+	// Create the transaction in PostgreSQL
+	err = tracing.Store(ctx, "postgresql", "insert transaction", func(context.Context) error {
+		return txDB.Create(&tx).Error
+	})
 	if err != nil {
-		s.Logger.Error().Err(err).Msg("Failed to marshal transaction for Redis")
+		log.Error().Err(err).Msg("Failed to create transaction in PostgreSQL")
 		txDB.Rollback()
 		return models.Transaction{}, err
 	}
 
-	if err := s.RedisService.Set(ctx, tx.ID, string(txJSON), time.Minute*10); err != nil {
-		s.Logger.Error().Err(err).Msg("Failed to set transaction in Redis")
-		// Not rolling back as caching is not critical
+	// Record the initial status in the transition history
+	if err := s.recordTransition(ctx, txDB, policy.Actor{UserID: tx.UserID}, tx.ID, "", tx.Status, "created"); err != nil {
+		txDB.Rollback()
+		return models.Transaction{}, err
 	}
 
-	// Publish the transaction event to Kafka
-	if err := s.KafkaService.Publish(string(txJSON)); err != nil {
-		s.Logger.Error().Err(err).Msg("Failed to publish transaction to Kafka")
-		// Not rolling back as event publishing is not critical
+	// Queue the transaction event for Kafka in the same DB transaction
+	event, err := events.NewTransactionCreated(tx)
+	if err != nil {
+		txDB.Rollback()
+		return models.Transaction{}, err
+	}
+	if err := enqueueOutbox(ctx, txDB, s.Events, event); err != nil {
+		log.Error().Err(err).Msg("Failed to write transaction event to outbox")
+		txDB.Rollback()
+		return models.Transaction{}, err
 	}
 
 	// Commit the transaction
-	if err := txDB.Commit().Error; err != nil {
-		s.Logger.Error().Err(err).Msg("Failed to commit DB transaction")
+	err = tracing.Store(ctx, "postgresql", "commit", func(context.Context) error {
+		return txDB.Commit().Error
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to commit DB transaction")
 		return models.Transaction{}, err
 	}
 
-	s.Logger.Info().
+	// Cache the transaction in Redis; caching is not critical
+	s.refreshCache(ctx, tx)
+
+	log.Info().
 		Str("transaction_id", tx.ID).
 		Msg("Transaction created successfully across all services")
 
@@ -90,42 +155,428 @@ func (s *TransactionServiceDB) CreateTransaction(tx models.Transaction) (models.
 }
 
 // GetTransactionByID retrieves a transaction by ID, utilizing Redis cache.
-func (s *TransactionServiceDB) GetTransactionByID(id string) (models.Transaction, error) {
+func (s *TransactionServiceDB) GetTransactionByID(ctx context.Context, actor policy.Actor, id string) (models.Transaction, error) {
+	tx, err := s.getTransaction(ctx, id)
+	if err != nil {
+		return models.Transaction{}, err
+	}
+	if !policy.CanViewTransaction(actor, tx) {
+		// Transactions the actor may not see do not exist for them
+		return models.Transaction{}, ErrTransactionNotFound
+	}
+	return tx, nil
+}
+
+// getTransaction retrieves a transaction by ID from Redis or PostgreSQL.
+func (s *TransactionServiceDB) getTransaction(ctx context.Context, id string) (models.Transaction, error) {
+	log := logging.FromContext(ctx, s.Logger)
 	// Attempt to retrieve from Redis cache
-	ctx := context.Background()
-	cachedTx, err := s.RedisService.Get(ctx, id)
+	var cachedTx string
+	err := tracing.Store(ctx, "redis", "get transaction", func(ctx context.Context) error {
+		var err error
+		cachedTx, err = s.RedisService.Get(ctx, transactionCacheKey(id))
+		return err
+	})
 	if err == nil {
 		var tx models.Transaction
 		if err := json.Unmarshal([]byte(cachedTx), &tx); err == nil {
-			s.Logger.Info().
+			metrics.CacheRequests.WithLabelValues(transactionCache, metrics.CacheHit).Inc()
+			log.Info().
 				Str("transaction_id", id).
 				Msg("Transaction retrieved from Redis cache")
 			return tx, nil
 		}
 	}
+	if err == nil || errors.Is(err, redis.Nil) {
+		metrics.CacheRequests.WithLabelValues(transactionCache, metrics.CacheMiss).Inc()
+	} else {
+		metrics.CacheRequests.WithLabelValues(transactionCache, metrics.CacheError).Inc()
+	}
 
 	// If not in cache, retrieve from PostgreSQL
 	var tx models.Transaction
-	if err := s.DB.First(&tx, "id = ?", id).Error; err != nil {
+	err = tracing.Store(ctx, "postgresql", "select transaction", func(context.Context) error {
+		return s.DB.WithContext(ctx).First(&tx, "id = ?", id).Error
+	})
+	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
-			s.Logger.Warn().
+			log.Warn().
 				Str("transaction_id", id).
 				Msg("Transaction not found in PostgreSQL")
-			return models.Transaction{}, errors.New("transaction not found")
+			return models.Transaction{}, ErrTransactionNotFound
 		}
-		s.Logger.Error().Err(err).Msg("Failed to retrieve transaction from PostgreSQL")
+		log.Error().Err(err).Msg("Failed to retrieve transaction from PostgreSQL")
 		return models.Transaction{}, err
 	}
 
 	// Cache the retrieved transaction in Redis
 	txJSON, err := json.Marshal(tx)
 	if err == nil {
-		s.RedisService.Set(ctx, id, string(txJSON), time.Minute*10)
+		tracing.Store(ctx, "redis", "set transaction", func(ctx context.Context) error {
+			return s.RedisService.Set(ctx, transactionCacheKey(id), string(txJSON), time.Minute*10)
+		})
 	}
 
-	s.Logger.Info().
+	log.Info().
 		Str("transaction_id", id).
 		Msg("Transaction retrieved from PostgreSQL")
 
 	return tx, nil
-}
\ No newline at end of file
+}
+
+// ListTransactions returns a page of transactions, newest first. The
+// transactions of a single user are read from the history read model.
+func (s *TransactionServiceDB) ListTransactions(ctx context.Context, actor policy.Actor, filter models.TransactionFilter) (models.TransactionPage, error) {
+	log := logging.FromContext(ctx, s.Logger)
+	if !policy.CanListTransactions(actor, filter.UserID) {
+		return models.TransactionPage{}, fmt.Errorf("%w: cannot list transactions of other users", policy.ErrForbidden)
+	}
+	q, err := parseFilter(filter)
+	if err != nil {
+		return models.TransactionPage{}, err
+	}
+
+	// On request, a user's history is read from the Cassandra read model,
+	// which may lag behind by the outbox and Kafka delays; PostgreSQL answers
+	// everything else, and the history if Cassandra fails
+	if q.Source == models.SourceHistory && q.UserID != 0 {
+		var page models.TransactionPage
+		err := tracing.Store(ctx, "cassandra", "select transaction history", func(ctx context.Context) error {
+			var err error
+			page, err = s.CassandraSvc.GetUserHistory(ctx, q)
+			return err
+		})
+		if err == nil {
+			return page, nil
+		}
+		log.Warn().Err(err).Msg("Failed to read transaction history from Cassandra, falling back to PostgreSQL")
+	}
+
+	query := s.DB.WithContext(ctx).Model(&models.Transaction{})
+	if q.UserID != 0 {
+		query = query.Where("user_id = ?", q.UserID)
+	}
+	if q.Type != "" {
+		query = query.Where("type = ?", q.Type)
+	}
+	if q.Status != "" {
+		query = query.Where("status = ?", q.Status)
+	}
+	if q.CryptoSymbol != "" {
+		query = query.Where("crypto_symbol = ?", q.CryptoSymbol)
+	}
+	if q.minAmount != nil {
+		query = query.Where("amount >= ?", *q.minAmount)
+	}
+	if q.maxAmount != nil {
+		query = query.Where("amount <= ?", *q.maxAmount)
+	}
+	if q.CreatedFrom != 0 {
+		query = query.Where("created_at >= ?", q.CreatedFrom)
+	}
+	if q.CreatedTo != 0 {
+		query = query.Where("created_at < ?", q.CreatedTo)
+	}
+	if q.after != nil {
+		query = query.Where("created_at < ? OR (created_at = ? AND id < ?)", q.after.CreatedAt, q.after.CreatedAt, q.after.ID)
+	}
+
+	// Fetch one extra row to know whether there is a next page
+	var txs []models.Transaction
+	err = tracing.Store(ctx, "postgresql", "list transactions", func(context.Context) error {
+		return query.Order("created_at DESC, id DESC").Limit(q.limit + 1).Find(&txs).Error
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to list transactions from PostgreSQL")
+		return models.TransactionPage{}, err
+	}
+	return newPage(txs, q.limit), nil
+}
+
+// RecentTransactions returns the latest transactions of a user from
+// PostgreSQL, never from the history read model.
+func (s *TransactionServiceDB) RecentTransactions(ctx context.Context, userID uint, limit int) ([]models.Transaction, error) {
+	if limit <= 0 || limit > maxPageSize {
+		limit = defaultPageSize
+	}
+	txs := make([]models.Transaction, 0)
+	err := tracing.Store(ctx, "postgresql", "select recent transactions", func(ctx context.Context) error {
+		return s.DB.WithContext(ctx).
+			Where("user_id = ?", userID).
+			Order("created_at DESC, id DESC").
+			Limit(limit).
+			Find(&txs).Error
+	})
+	if err != nil {
+		log := logging.FromContext(ctx, s.Logger)
+		log.Error().Err(err).Uint("user_id", userID).Msg("Failed to read recent transactions from PostgreSQL")
+		return nil, err
+	}
+	return txs, nil
+}
+
+// TransitionTransaction moves a transaction to a new status, journaling any
+// movement of funds and recording the change in the transition history.
+func (s *TransactionServiceDB) TransitionTransaction(ctx context.Context, actor policy.Actor, id string, status string, reason string) (models.Transaction, error) {
+	log := logging.FromContext(ctx, s.Logger)
+	// Start a database transaction
+	txDB := s.DB.WithContext(ctx).Begin()
+	if txDB.Error != nil {
+		log.Error().Err(txDB.Error).Msg("Failed to start DB transaction")
+		return models.Transaction{}, txDB.Error
+	}
+
+	// Lock the transaction row so concurrent transitions are serialized
+	var tx models.Transaction
+	err := tracing.Store(ctx, "postgresql", "lock transaction", func(context.Context) error {
+		return txDB.Clauses(clause.Locking{Strength: "UPDATE"}).First(&tx, "id = ?", id).Error
+	})
+	if err != nil {
+		txDB.Rollback()
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return models.Transaction{}, ErrTransactionNotFound
+		}
+		log.Error().Err(err).Str("transaction_id", id).Msg("Failed to lock transaction in PostgreSQL")
+		return models.Transaction{}, err
+	}
+
+	if err := checkTransitionAllowed(actor, tx, status); err != nil {
+		txDB.Rollback()
+		return models.Transaction{}, err
+	}
+
+	from := tx.Status
+	if !models.CanTransition(from, status) {
+		txDB.Rollback()
+		return models.Transaction{}, fmt.Errorf("%w: %s -> %s", ErrInvalidTransition, from, status)
+	}
+	if err := checkStepUp(tx, status); err != nil {
+		txDB.Rollback()
+		return models.Transaction{}, err
+	}
+
+	tx.Status = status
+	err = tracing.Store(ctx, "postgresql", "update transaction status", func(context.Context) error {
+		return txDB.Model(&tx).Update("status", status).Error
+	})
+	if err != nil {
+		log.Error().Err(err).Str("transaction_id", id).Msg("Failed to update transaction status in PostgreSQL")
+		txDB.Rollback()
+		return models.Transaction{}, err
+	}
+
+	if err := s.recordTransition(ctx, txDB, actor, id, from, status, reason); err != nil {
+		txDB.Rollback()
+		return models.Transaction{}, err
+	}
+
+	// Journal the movement of funds in the same DB transaction
+	entry, journaled, err := transitionEntry(tx)
+	if err != nil {
+		log.Error().Err(err).Str("transaction_id", id).Msg("Failed to build journal entry")
+		txDB.Rollback()
+		return models.Transaction{}, err
+	}
+	if journaled {
+		// A reversal must not take more than the user has available, e.g.
+		// a deposit that was already withdrawn or traded
+		if debit := reversalDebit(tx, entry); debit.IsPositive() {
+			err := tracing.Store(ctx, "postgresql", "reserve funds", func(context.Context) error {
+				return s.Balances.ReserveAmount(txDB, tx.UserID, tx.CryptoSymbol, debit)
+			})
+			if err != nil {
+				log.Warn().Err(err).Str("transaction_id", id).Msg("Reversal rejected")
+				txDB.Rollback()
+				return models.Transaction{}, err
+			}
+		}
+		err := tracing.Store(ctx, "postgresql", "record journal entry", func(context.Context) error {
+			return s.Ledger.RecordEntry(txDB, entry)
+		})
+		if err != nil {
+			txDB.Rollback()
+			return models.Transaction{}, err
+		}
+	}
+
+	// Queue the status change event for Kafka in the same DB transaction
+	event, err := events.NewTransactionStatusChanged(tx, from, reason)
+	if err != nil {
+		txDB.Rollback()
+		return models.Transaction{}, err
+	}
+	if err := enqueueOutbox(ctx, txDB, s.Events, event); err != nil {
+		log.Error().Err(err).Msg("Failed to write transaction event to outbox")
+		txDB.Rollback()
+		return models.Transaction{}, err
+	}
+
+	// Commit the transaction
+	err = tracing.Store(ctx, "postgresql", "commit", func(context.Context) error {
+		return txDB.Commit().Error
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to commit DB transaction")
+		return models.Transaction{}, err
+	}
+
+	s.refreshCache(ctx, tx)
+
+	log.Info().
+		Str("transaction_id", id).
+		Str("from_status", from).
+		Str("to_status", status).
+		Uint("actor_id", actor.UserID).
+		Str("actor_role", actor.Role).
+		Msg("Transaction status changed")
+
+	return tx, nil
+}
+
+// StepUpWithdrawal checks the second factor of a pending withdrawal of a user.
+// Stepping up a withdrawal twice is a no-op.
+func (s *TransactionServiceDB) StepUpWithdrawal(ctx context.Context, id string, userID uint, code string) (models.Transaction, error) {
+	log := logging.FromContext(ctx, s.Logger)
+	var tx models.Transaction
+	err := tracing.Store(ctx, "postgresql", "step up withdrawal", func(context.Context) error {
+		return s.DB.WithContext(ctx).Transaction(func(db *gorm.DB) error {
+			// Lock the transaction row so that it cannot move on meanwhile
+			err := db.Clauses(clause.Locking{Strength: "UPDATE"}).First(&tx, "id = ? AND user_id = ?", id, userID).Error
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrTransactionNotFound
+			}
+			if err != nil {
+				log.Error().Err(err).Str("transaction_id", id).Msg("Failed to lock transaction in PostgreSQL")
+				return err
+			}
+			if tx.Type != "withdrawal" || tx.Status != models.StatusPending {
+				return fmt.Errorf("%w: only pending withdrawals take a step-up", ErrInvalidTransition)
+			}
+			if tx.StepUpAt != nil {
+				return nil
+			}
+
+			// The code is used up in this DB transaction, so it stays valid
+			// if the step-up is rolled back
+			if err := s.StepUp.VerifyStepUpTx(db, userID, code); err != nil {
+				return err
+			}
+			now := time.Now().Unix()
+			tx.StepUpAt = &now
+			if err := db.Model(&tx).Update("step_up_at", now).Error; err != nil {
+				log.Error().Err(err).Str("transaction_id", id).Msg("Failed to record withdrawal step-up in PostgreSQL")
+				return err
+			}
+
+			// Queue the change for Kafka in the same DB transaction, so the
+			// read model and streams learn of the step-up
+			event, err := events.NewTransactionStatusChanged(tx, tx.Status, stepUpReason)
+			if err != nil {
+				return err
+			}
+			if err := enqueueOutbox(ctx, db, s.Events, event); err != nil {
+				log.Error().Err(err).Msg("Failed to write transaction event to outbox")
+				return err
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return models.Transaction{}, err
+	}
+
+	s.refreshCache(ctx, tx)
+
+	log.Info().
+		Str("transaction_id", id).
+		Msg("Withdrawal passed step-up")
+	return tx, nil
+}
+
+// GetTransactionHistory returns the status transitions of a transaction, oldest first.
+func (s *TransactionServiceDB) GetTransactionHistory(ctx context.Context, actor policy.Actor, id string) ([]models.TransactionTransition, error) {
+	log := logging.FromContext(ctx, s.Logger)
+	var tx models.Transaction
+	err := tracing.Store(ctx, "postgresql", "select transaction", func(context.Context) error {
+		return s.DB.WithContext(ctx).Select("id", "user_id").Take(&tx, "id = ?", id).Error
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrTransactionNotFound
+	}
+	if err != nil {
+		log.Error().Err(err).Str("transaction_id", id).Msg("Failed to look up transaction in PostgreSQL")
+		return nil, err
+	}
+	if !policy.CanViewTransaction(actor, tx) {
+		return nil, ErrTransactionNotFound
+	}
+
+	var history []models.TransactionTransition
+	err = tracing.Store(ctx, "postgresql", "select transaction history", func(context.Context) error {
+		return s.DB.WithContext(ctx).Where("transaction_id = ?", id).Order("id").Find(&history).Error
+	})
+	if err != nil {
+		log.Error().Err(err).Str("transaction_id", id).Msg("Failed to retrieve transaction history")
+		return nil, err
+	}
+	return history, nil
+}
+
+// refreshCache replaces the cached copy of a transaction after a change. It
+// must only be called once the change is committed, so that the cache never
+// holds a row that was rolled back.
+func (s *TransactionServiceDB) refreshCache(ctx context.Context, tx models.Transaction) {
+	log := logging.FromContext(ctx, s.Logger)
+	txJSON, err := json.Marshal(tx)
+	if err != nil {
+		return
+	}
+	err = tracing.Store(ctx, "redis", "set transaction", func(ctx context.Context) error {
+		return s.RedisService.Set(ctx, transactionCacheKey(tx.ID), string(txJSON), time.Minute*10)
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to set transaction in Redis")
+	}
+}
+
+// recordTransition writes a transition history row using the given DB handle.
+func (s *TransactionServiceDB) recordTransition(ctx context.Context, db *gorm.DB, actor policy.Actor, id, from, to, reason string) error {
+	log := logging.FromContext(ctx, s.Logger)
+	transition := models.TransactionTransition{
+		TransactionID: id,
+		FromStatus:    from,
+		ToStatus:      to,
+		Reason:        reason,
+		ActorID:       actor.UserID,
+		ActorRole:     actor.Role,
+	}
+	err := tracing.Store(ctx, "postgresql", "record transition", func(context.Context) error {
+		return db.Create(&transition).Error
+	})
+	if err != nil {
+		log.Error().Err(err).Str("transaction_id", id).Msg("Failed to record transaction transition")
+		return err
+	}
+	return nil
+}
+
+// normalizeAmounts rounds the amounts of a transaction to the configured precision
+// of their asset and rejects amounts that round to zero.
+func normalizeAmounts(assets *money.Registry, tx models.Transaction) (models.Transaction, error) {
+	tx.CryptoSymbol = strings.ToUpper(tx.CryptoSymbol)
+	crypto, err := assets.Asset(tx.CryptoSymbol)
+	if err != nil {
+		return models.Transaction{}, err
+	}
+
+	tx.Amount = assets.Fiat().Round(tx.Amount)
+	tx.CryptoAmount = crypto.Round(tx.CryptoAmount)
+	tx.TransactionFee = crypto.Round(tx.TransactionFee)
+	if !tx.Amount.IsPositive() || !tx.CryptoAmount.IsPositive() {
+		return models.Transaction{}, fmt.Errorf("%w: amounts must be positive at %s precision", ErrInvalidAmount, tx.CryptoSymbol)
+	}
+	if tx.TransactionFee.IsNegative() {
+		return models.Transaction{}, fmt.Errorf("%w: transaction fee cannot be negative", ErrInvalidAmount)
+	}
+	return tx, nil
+}
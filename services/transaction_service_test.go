@@ -0,0 +1,86 @@
+// services/transaction_service_test.go
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"crypto-exchange/config"
+	"crypto-exchange/models"
+	"crypto-exchange/money"
+	"crypto-exchange/policy"
+
+	"github.com/shopspring/decimal"
+)
+
+// newTestAssets returns a registry of USD and BTC.
+func newTestAssets(t *testing.T) *money.Registry {
+	t.Helper()
+	assets, err := money.NewRegistry(config.MoneyConfig{
+		FiatCurrency: "USD",
+		Assets: []config.AssetConfig{
+			{Symbol: "USD", Precision: 2, Rounding: "half_even"},
+			{Symbol: "BTC", Precision: 8, Rounding: "half_even"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return assets
+}
+
+// newTestTransaction returns a pending transaction of amount BTC of a user.
+func newTestTransaction(id string, userID uint, txType, amount string) models.Transaction {
+	return models.Transaction{
+		ID:             id,
+		UserID:         userID,
+		Amount:         decimal.NewFromInt(100),
+		Type:           txType,
+		Status:         models.StatusPending,
+		CryptoSymbol:   "BTC",
+		CryptoAmount:   decimal.RequireFromString(amount),
+		TransactionFee: decimal.RequireFromString("0.001"),
+	}
+}
+
+// settle moves a transaction through processing to completed.
+func settle(t *testing.T, txs *MockTransactionService, id string) {
+	t.Helper()
+	for _, status := range []string{models.StatusProcessing, models.StatusCompleted} {
+		if _, err := txs.TransitionTransaction(context.Background(), policy.System, id, status, "confirmed"); err != nil {
+			t.Fatalf("settle %s: %v", id, err)
+		}
+	}
+}
+
+func TestReversalNeedsAvailableBalance(t *testing.T) {
+	ctx := context.Background()
+	txs := NewMockTransactionService(NewMockLedgerService(), newTestAssets(t))
+
+	for _, id := range []string{"first", "second"} {
+		if _, err := txs.CreateTransaction(ctx, newTestTransaction(id, 1, "deposit", "1")); err != nil {
+			t.Fatal(err)
+		}
+		settle(t, txs, id)
+	}
+	// The user has 1.998 BTC and holds 1.001 BTC in a pending withdrawal
+	if _, err := txs.CreateTransaction(ctx, newTestTransaction("withdrawal", 1, "withdrawal", "1")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Taking back 0.999 BTC would leave less than nothing available
+	if _, err := txs.TransitionTransaction(ctx, policy.System, "first", models.StatusReversed, "chargeback"); !errors.Is(err, ErrInsufficientFunds) {
+		t.Fatalf("reversal beyond the available balance: got %v, want ErrInsufficientFunds", err)
+	}
+	if tx, _ := txs.GetTransactionByID(ctx, policy.System, "first"); tx.Status != models.StatusCompleted {
+		t.Fatalf("rejected reversal left the deposit %s", tx.Status)
+	}
+
+	if _, err := txs.TransitionTransaction(ctx, policy.System, "withdrawal", models.StatusCancelled, "user"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := txs.TransitionTransaction(ctx, policy.System, "first", models.StatusReversed, "chargeback"); err != nil {
+		t.Fatalf("covered reversal: %v", err)
+	}
+}
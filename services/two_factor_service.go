@@ -0,0 +1,403 @@
+// services/two_factor_service.go
+package services
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"crypto-exchange/apperrors"
+	"crypto-exchange/config"
+	"crypto-exchange/models"
+
+	"github.com/rs/zerolog"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	// ErrTwoFactorNotEnabled is returned when a code is checked for a user
+	// without confirmed two-factor authentication.
+	ErrTwoFactorNotEnabled = apperrors.New(apperrors.ErrConflict, "two_factor_not_enabled", "two-factor authentication is not enabled")
+	// ErrTwoFactorAlreadyEnabled is returned when enrolling a user who already
+	// has two-factor authentication enabled.
+	ErrTwoFactorAlreadyEnabled = apperrors.New(apperrors.ErrConflict, "two_factor_already_enabled", "two-factor authentication is already enabled")
+	// ErrInvalidTwoFactorCode is returned for wrong, expired and reused codes.
+	ErrInvalidTwoFactorCode = apperrors.New(apperrors.ErrForbidden, "invalid_two_factor_code", "invalid two-factor code")
+	// ErrStepUpRequired is returned when a withdrawal would leave pending
+	// without passing the two-factor step-up.
+	ErrStepUpRequired = apperrors.New(apperrors.ErrConflict, "step_up_required", "withdrawal requires two-factor step-up")
+)
+
+// StepUpVerifier checks the second factor withdrawals must pass before they
+// are processed.
+type StepUpVerifier interface {
+	// VerifyStepUp checks a TOTP or recovery code of a user. Each code is
+	// accepted only once.
+	VerifyStepUp(userID uint, code string) error
+}
+
+// TxStepUpVerifier checks the second factor of withdrawals within the DB
+// transaction that records the step-up, so that a code is only used up if
+// that transaction commits.
+type TxStepUpVerifier interface {
+	// VerifyStepUpTx checks and uses up a TOTP or recovery code of a user
+	// using the given DB handle.
+	VerifyStepUpTx(db *gorm.DB, userID uint, code string) error
+}
+
+// TwoFactorService defines the methods for managing TOTP two-factor authentication.
+type TwoFactorService interface {
+	StepUpVerifier
+	// Enroll creates a new TOTP secret. It takes effect once Enable confirms a
+	// code generated from it.
+	Enroll(userID uint) (models.TwoFactorEnrollment, error)
+	// Enable turns on two-factor authentication and returns new recovery codes.
+	Enable(userID uint, code string) (models.RecoveryCodes, error)
+	Disable(userID uint, code string) error
+}
+
+// TwoFactorServiceDB stores TOTP secrets and recovery codes in PostgreSQL.
+type TwoFactorServiceDB struct {
+	DB     *gorm.DB
+	Logger zerolog.Logger
+	Config config.TwoFactorConfig
+	Now    func() time.Time // the clock codes are checked against
+}
+
+// NewTwoFactorService initializes a new TwoFactorServiceDB.
+func NewTwoFactorService(db *gorm.DB, logger zerolog.Logger, cfg config.TwoFactorConfig) *TwoFactorServiceDB {
+	return &TwoFactorServiceDB{
+		DB:     db,
+		Logger: logger,
+		Config: cfg,
+		Now:    time.Now,
+	}
+}
+
+// Enroll creates a new TOTP secret for a user, replacing one not yet enabled.
+func (s *TwoFactorServiceDB) Enroll(userID uint) (models.TwoFactorEnrollment, error) {
+	var user models.User
+	if err := s.DB.First(&user, userID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return models.TwoFactorEnrollment{}, ErrUserNotFound
+		}
+		s.Logger.Error().Err(err).Uint("user_id", userID).Msg("Failed to retrieve user from PostgreSQL")
+		return models.TwoFactorEnrollment{}, err
+	}
+	secret, err := newTOTPSecret()
+	if err != nil {
+		return models.TwoFactorEnrollment{}, err
+	}
+
+	err = s.DB.Transaction(func(db *gorm.DB) error {
+		var existing models.TwoFactor
+		err := db.Clauses(clause.Locking{Strength: "UPDATE"}).First(&existing, "user_id = ?", userID).Error
+		if err == nil && existing.EnabledAt != nil {
+			return ErrTwoFactorAlreadyEnabled
+		}
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			s.Logger.Error().Err(err).Uint("user_id", userID).Msg("Failed to lock two-factor secret in PostgreSQL")
+			return err
+		}
+		tf := models.TwoFactor{UserID: userID, Secret: secret}
+		return db.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "user_id"}},
+			DoUpdates: clause.AssignmentColumns([]string{"secret", "last_used_step", "updated_at"}),
+		}).Create(&tf).Error
+	})
+	if err != nil {
+		return models.TwoFactorEnrollment{}, err
+	}
+
+	return models.TwoFactorEnrollment{
+		Secret:          secret,
+		ProvisioningURI: totpURI(s.Config.Issuer, user.Email, secret),
+	}, nil
+}
+
+// Enable confirms the enrolled secret of a user with a TOTP code and issues
+// new recovery codes.
+func (s *TwoFactorServiceDB) Enable(userID uint, code string) (models.RecoveryCodes, error) {
+	codes, hashes, err := newRecoveryCodes(s.Config.RecoveryCodes)
+	if err != nil {
+		return models.RecoveryCodes{}, err
+	}
+
+	err = s.DB.Transaction(func(db *gorm.DB) error {
+		var tf models.TwoFactor
+		err := db.Clauses(clause.Locking{Strength: "UPDATE"}).First(&tf, "user_id = ?", userID).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return fmt.Errorf("%w: enroll first", ErrTwoFactorNotEnabled)
+		}
+		if err != nil {
+			s.Logger.Error().Err(err).Uint("user_id", userID).Msg("Failed to lock two-factor secret in PostgreSQL")
+			return err
+		}
+		if tf.EnabledAt != nil {
+			return ErrTwoFactorAlreadyEnabled
+		}
+		step, ok, err := matchTOTP(tf.Secret, code, s.Now(), s.Config.Skew, tf.LastUsedStep)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return ErrInvalidTwoFactorCode
+		}
+
+		now := s.Now().Unix()
+		err = db.Model(&tf).Updates(map[string]interface{}{"enabled_at": now, "last_used_step": step}).Error
+		if err != nil {
+			return err
+		}
+		if err := db.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		recovery := make([]models.RecoveryCode, len(hashes))
+		for i, hash := range hashes {
+			recovery[i] = models.RecoveryCode{UserID: userID, CodeHash: hash}
+		}
+		return db.Create(&recovery).Error
+	})
+	if err != nil {
+		return models.RecoveryCodes{}, err
+	}
+
+	s.Logger.Info().Uint("user_id", userID).Msg("Two-factor authentication enabled")
+	return models.RecoveryCodes{RecoveryCodes: codes}, nil
+}
+
+// Disable turns off two-factor authentication after checking a TOTP or recovery code.
+func (s *TwoFactorServiceDB) Disable(userID uint, code string) error {
+	err := s.DB.Transaction(func(db *gorm.DB) error {
+		if err := s.verify(db, userID, code); err != nil {
+			return err
+		}
+		if err := db.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		return db.Delete(&models.TwoFactor{}, "user_id = ?", userID).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	s.Logger.Info().Uint("user_id", userID).Msg("Two-factor authentication disabled")
+	return nil
+}
+
+// VerifyStepUp checks a TOTP or recovery code of a user.
+func (s *TwoFactorServiceDB) VerifyStepUp(userID uint, code string) error {
+	return s.DB.Transaction(func(db *gorm.DB) error {
+		return s.verify(db, userID, code)
+	})
+}
+
+// VerifyStepUpTx checks a TOTP or recovery code of a user within the caller's
+// DB transaction.
+func (s *TwoFactorServiceDB) VerifyStepUpTx(db *gorm.DB, userID uint, code string) error {
+	return s.verify(db, userID, code)
+}
+
+// verify checks and uses up a code of a user using the given DB handle. The
+// two-factor row is locked so that concurrent uses of a code are serialized.
+func (s *TwoFactorServiceDB) verify(db *gorm.DB, userID uint, code string) error {
+	var tf models.TwoFactor
+	err := db.Clauses(clause.Locking{Strength: "UPDATE"}).First(&tf, "user_id = ?", userID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrTwoFactorNotEnabled
+	}
+	if err != nil {
+		s.Logger.Error().Err(err).Uint("user_id", userID).Msg("Failed to lock two-factor secret in PostgreSQL")
+		return err
+	}
+	if tf.EnabledAt == nil {
+		return ErrTwoFactorNotEnabled
+	}
+
+	if isTOTPCode(code) {
+		step, ok, err := matchTOTP(tf.Secret, code, s.Now(), s.Config.Skew, tf.LastUsedStep)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return ErrInvalidTwoFactorCode
+		}
+		return db.Model(&tf).Update("last_used_step", step).Error
+	}
+
+	result := db.Model(&models.RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, hashToken(normalizeRecoveryCode(code))).
+		Update("used_at", s.Now().Unix())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrInvalidTwoFactorCode
+	}
+	s.Logger.Info().Uint("user_id", userID).Msg("Recovery code used")
+	return nil
+}
+
+// MockTwoFactorService is a mock implementation of TwoFactorService.
+type MockTwoFactorService struct {
+	users    *MockUserService
+	config   config.TwoFactorConfig
+	secrets  map[uint]models.TwoFactor
+	recovery map[uint]map[string]bool // code hashes by user, true once used
+	Now      func() time.Time
+	mutex    sync.Mutex
+}
+
+// NewMockTwoFactorService creates a new instance of MockTwoFactorService. The
+// transaction service checks the step-up of withdrawals with it.
+func NewMockTwoFactorService(txService *MockTransactionService, users *MockUserService, cfg config.TwoFactorConfig) *MockTwoFactorService {
+	s := &MockTwoFactorService{
+		users:    users,
+		config:   cfg,
+		secrets:  make(map[uint]models.TwoFactor),
+		recovery: make(map[uint]map[string]bool),
+		Now:      time.Now,
+	}
+	txService.stepUp = s
+	return s
+}
+
+// Enroll creates a new TOTP secret for a user, replacing one not yet enabled.
+func (s *MockTwoFactorService) Enroll(userID uint) (models.TwoFactorEnrollment, error) {
+	user, err := s.users.GetUser(userID)
+	if err != nil {
+		return models.TwoFactorEnrollment{}, err
+	}
+	secret, err := newTOTPSecret()
+	if err != nil {
+		return models.TwoFactorEnrollment{}, err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if tf, exists := s.secrets[userID]; exists && tf.EnabledAt != nil {
+		return models.TwoFactorEnrollment{}, ErrTwoFactorAlreadyEnabled
+	}
+	now := s.Now().Unix()
+	s.secrets[userID] = models.TwoFactor{UserID: userID, Secret: secret, CreatedAt: now, UpdatedAt: now}
+	return models.TwoFactorEnrollment{
+		Secret:          secret,
+		ProvisioningURI: totpURI(s.config.Issuer, user.Email, secret),
+	}, nil
+}
+
+// Enable confirms the enrolled secret of a user with a TOTP code and issues
+// new recovery codes.
+func (s *MockTwoFactorService) Enable(userID uint, code string) (models.RecoveryCodes, error) {
+	codes, hashes, err := newRecoveryCodes(s.config.RecoveryCodes)
+	if err != nil {
+		return models.RecoveryCodes{}, err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	tf, exists := s.secrets[userID]
+	if !exists {
+		return models.RecoveryCodes{}, fmt.Errorf("%w: enroll first", ErrTwoFactorNotEnabled)
+	}
+	if tf.EnabledAt != nil {
+		return models.RecoveryCodes{}, ErrTwoFactorAlreadyEnabled
+	}
+	step, ok, err := matchTOTP(tf.Secret, code, s.Now(), s.config.Skew, tf.LastUsedStep)
+	if err != nil {
+		return models.RecoveryCodes{}, err
+	}
+	if !ok {
+		return models.RecoveryCodes{}, ErrInvalidTwoFactorCode
+	}
+
+	now := s.Now().Unix()
+	tf.EnabledAt = &now
+	tf.LastUsedStep = step
+	tf.UpdatedAt = now
+	s.secrets[userID] = tf
+	s.recovery[userID] = make(map[string]bool, len(hashes))
+	for _, hash := range hashes {
+		s.recovery[userID][hash] = false
+	}
+	return models.RecoveryCodes{RecoveryCodes: codes}, nil
+}
+
+// Disable turns off two-factor authentication after checking a TOTP or recovery code.
+func (s *MockTwoFactorService) Disable(userID uint, code string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if err := s.verify(userID, code); err != nil {
+		return err
+	}
+	delete(s.secrets, userID)
+	delete(s.recovery, userID)
+	return nil
+}
+
+// VerifyStepUp checks a TOTP or recovery code of a user.
+func (s *MockTwoFactorService) VerifyStepUp(userID uint, code string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.verify(userID, code)
+}
+
+// verify checks and uses up a code of a user. The caller must hold s.mutex.
+func (s *MockTwoFactorService) verify(userID uint, code string) error {
+	tf, exists := s.secrets[userID]
+	if !exists || tf.EnabledAt == nil {
+		return ErrTwoFactorNotEnabled
+	}
+
+	if isTOTPCode(code) {
+		step, ok, err := matchTOTP(tf.Secret, code, s.Now(), s.config.Skew, tf.LastUsedStep)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return ErrInvalidTwoFactorCode
+		}
+		tf.LastUsedStep = step
+		s.secrets[userID] = tf
+		return nil
+	}
+
+	hash := hashToken(normalizeRecoveryCode(code))
+	used, exists := s.recovery[userID][hash]
+	if !exists || used {
+		return ErrInvalidTwoFactorCode
+	}
+	s.recovery[userID][hash] = true
+	return nil
+}
+
+// stepUpReason is the reason of the transaction.status_changed event of a
+// withdrawal passing the step-up, which keeps its status.
+const stepUpReason = "step_up"
+
+// checkStepUp returns ErrStepUpRequired if a withdrawal would move from pending
+// to processing without having passed the step-up. Failing or cancelling it
+// needs none, as no funds leave the exchange.
+func checkStepUp(tx models.Transaction, status string) error {
+	if tx.Type == "withdrawal" && tx.Status == models.StatusPending && status == models.StatusProcessing && tx.StepUpAt == nil {
+		return ErrStepUpRequired
+	}
+	return nil
+}
+
+// newRecoveryCodes returns n random recovery codes and their hashes.
+func newRecoveryCodes(n int) ([]string, []string, error) {
+	codes := make([]string, n)
+	hashes := make([]string, n)
+	for i := range codes {
+		code, err := newRecoveryCode()
+		if err != nil {
+			return nil, nil, err
+		}
+		codes[i] = code
+		hashes[i] = hashToken(normalizeRecoveryCode(code))
+	}
+	return codes, hashes, nil
+}
@@ -0,0 +1,52 @@
+// services/two_factor_service_test.go
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"crypto-exchange/models"
+	"crypto-exchange/policy"
+)
+
+func TestWithdrawalRequiresStepUp(t *testing.T) {
+	ctx := context.Background()
+	txs := NewMockTransactionService(NewMockLedgerService(), newTestAssets(t))
+	now := time.Unix(1700000000, 0)
+	_, userID, secret, _ := newTestTwoFactor(t, txs, &now)
+
+	// Fund the user through a deposit settled by the system
+	if _, err := txs.CreateTransaction(ctx, newTestTransaction("deposit", userID, "deposit", "2")); err != nil {
+		t.Fatal(err)
+	}
+	settle(t, txs, "deposit")
+
+	if _, err := txs.CreateTransaction(ctx, newTestTransaction("withdrawal", userID, "withdrawal", "1")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := txs.TransitionTransaction(ctx, policy.System, "withdrawal", models.StatusProcessing, "sent"); !errors.Is(err, ErrStepUpRequired) {
+		t.Fatalf("processing without step-up: got %v, want ErrStepUpRequired", err)
+	}
+
+	// A wrong code leaves the withdrawal waiting for the step-up
+	if _, err := txs.StepUpWithdrawal(ctx, "withdrawal", userID, "000000"); !errors.Is(err, ErrInvalidTwoFactorCode) {
+		t.Fatalf("wrong code: got %v, want ErrInvalidTwoFactorCode", err)
+	}
+	if _, err := txs.TransitionTransaction(ctx, policy.System, "withdrawal", models.StatusProcessing, "sent"); !errors.Is(err, ErrStepUpRequired) {
+		t.Fatalf("processing after a wrong code: got %v, want ErrStepUpRequired", err)
+	}
+
+	code, _ := totpCode(secret, totpStep(now)+1)
+	tx, err := txs.StepUpWithdrawal(ctx, "withdrawal", userID, code)
+	if err != nil {
+		t.Fatalf("step-up: %v", err)
+	}
+	if tx.StepUpAt == nil || tx.Status != models.StatusPending {
+		t.Fatalf("stepped-up withdrawal is %s with step_up_at %v", tx.Status, tx.StepUpAt)
+	}
+	if _, err := txs.TransitionTransaction(ctx, policy.System, "withdrawal", models.StatusProcessing, "sent"); err != nil {
+		t.Fatalf("processing after step-up: %v", err)
+	}
+}
@@ -0,0 +1,615 @@
+// services/user_service.go
+package services
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"crypto-exchange/apperrors"
+	"crypto-exchange/config"
+	"crypto-exchange/models"
+	"crypto-exchange/policy"
+
+	"github.com/rs/zerolog"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	// ErrUserNotFound is returned when no user has the requested ID.
+	ErrUserNotFound = apperrors.New(apperrors.ErrNotFound, "user_not_found", "user not found")
+	// ErrEmailTaken is returned when registering an email that already has an account.
+	ErrEmailTaken = apperrors.New(apperrors.ErrConflict, "email_taken", "email already registered")
+	// ErrInvalidVerificationToken is returned for email verification tokens that
+	// are unknown, already used or expired.
+	ErrInvalidVerificationToken = apperrors.New(apperrors.ErrValidation, "invalid_verification_token", "invalid verification token")
+	// ErrEmailNotVerified is returned when logging in before verifying the email address.
+	ErrEmailNotVerified = apperrors.New(apperrors.ErrForbidden, "email_not_verified", "email not verified")
+	// ErrUserNotActive is returned when a frozen or closed user logs in or moves funds.
+	ErrUserNotActive = apperrors.New(apperrors.ErrForbidden, "account_not_active", "user is not active")
+	// ErrInvalidStatusChange is returned for unknown statuses and for reopening closed accounts.
+	ErrInvalidStatusChange = apperrors.New(apperrors.ErrConflict, "invalid_status_change", "invalid status change")
+	// ErrInvalidRole is returned for roles that do not exist.
+	ErrInvalidRole = apperrors.New(apperrors.ErrConflict, "invalid_role", "invalid role")
+)
+
+// UserService defines the methods for managing user accounts. It also verifies
+// login credentials.
+type UserService interface {
+	CredentialVerifier
+	Register(req models.RegisterRequest) (models.User, error)
+	VerifyEmail(token string) (models.User, error)
+	// ResendVerification sends a new verification email. It does nothing for
+	// unknown and already verified emails, so that callers cannot tell them apart.
+	ResendVerification(email string) error
+	GetUser(id uint) (models.User, error)
+	UpdateProfile(id uint, req models.UpdateProfileRequest) (models.User, error)
+	// SetStatus and SetRole are staff operations, checked against the actor's role.
+	SetStatus(actor policy.Actor, id uint, status string) (models.User, error)
+	SetRole(actor policy.Actor, id uint, role string) (models.User, error)
+}
+
+// EmailSender delivers emails to users.
+type EmailSender interface {
+	SendVerification(user models.User, link string) error
+}
+
+// LogEmailSender writes emails to the log instead of sending them, until a
+// mail provider is configured.
+type LogEmailSender struct {
+	Logger zerolog.Logger
+}
+
+// SendVerification logs the verification link of a user.
+func (s LogEmailSender) SendVerification(user models.User, link string) error {
+	s.Logger.Info().
+		Uint("user_id", user.ID).
+		Str("email", user.Email).
+		Str("link", link).
+		Msg("Email verification link")
+	return nil
+}
+
+// UserServiceDB stores users in PostgreSQL.
+type UserServiceDB struct {
+	DB     *gorm.DB
+	Logger zerolog.Logger
+	Emails EmailSender
+	Config config.UsersConfig
+}
+
+// NewUserService initializes a new UserServiceDB.
+func NewUserService(db *gorm.DB, logger zerolog.Logger, emails EmailSender, cfg config.UsersConfig) *UserServiceDB {
+	return &UserServiceDB{
+		DB:     db,
+		Logger: logger,
+		Emails: emails,
+		Config: cfg,
+	}
+}
+
+// Register creates an active, unverified user and sends them a verification email.
+func (s *UserServiceDB) Register(req models.RegisterRequest) (models.User, error) {
+	hash, err := HashPassword(req.Password)
+	if err != nil {
+		return models.User{}, err
+	}
+	user := models.User{
+		Email:        normalizeEmail(req.Email),
+		PasswordHash: hash,
+		FullName:     strings.TrimSpace(req.FullName),
+		Status:       models.UserStatusActive,
+		Role:         models.RoleUser,
+	}
+	token, verification, err := newEmailVerification(s.Config)
+	if err != nil {
+		return models.User{}, err
+	}
+
+	err = s.DB.Transaction(func(db *gorm.DB) error {
+		result := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&user)
+		if result.Error != nil {
+			s.Logger.Error().Err(result.Error).Msg("Failed to create user in PostgreSQL")
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrEmailTaken
+		}
+		verification.UserID = user.ID
+		if err := db.Create(&verification).Error; err != nil {
+			s.Logger.Error().Err(err).Uint("user_id", user.ID).Msg("Failed to create email verification in PostgreSQL")
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return models.User{}, err
+	}
+
+	s.sendVerification(user, token)
+	s.Logger.Info().Uint("user_id", user.ID).Msg("User registered")
+	return user, nil
+}
+
+// VerifyEmail marks the email of the user a verification token was sent to as
+// verified and invalidates their other verification tokens.
+func (s *UserServiceDB) VerifyEmail(token string) (models.User, error) {
+	var user models.User
+	err := s.DB.Transaction(func(db *gorm.DB) error {
+		var verification models.EmailVerification
+		err := db.Clauses(clause.Locking{Strength: "UPDATE"}).
+			First(&verification, "token_hash = ? AND expires_at > ?", hashToken(token), time.Now().Unix()).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrInvalidVerificationToken
+		}
+		if err != nil {
+			s.Logger.Error().Err(err).Msg("Failed to retrieve email verification from PostgreSQL")
+			return err
+		}
+
+		now := time.Now().Unix()
+		err = db.Model(&models.User{}).
+			Where("id = ? AND email_verified_at IS NULL", verification.UserID).
+			Update("email_verified_at", now).Error
+		if err != nil {
+			s.Logger.Error().Err(err).Uint("user_id", verification.UserID).Msg("Failed to verify email in PostgreSQL")
+			return err
+		}
+		if err := db.Where("user_id = ?", verification.UserID).Delete(&models.EmailVerification{}).Error; err != nil {
+			s.Logger.Error().Err(err).Uint("user_id", verification.UserID).Msg("Failed to delete email verifications from PostgreSQL")
+			return err
+		}
+		return db.First(&user, verification.UserID).Error
+	})
+	if err != nil {
+		return models.User{}, err
+	}
+
+	s.Logger.Info().Uint("user_id", user.ID).Msg("Email verified")
+	return user, nil
+}
+
+// ResendVerification sends a new verification email to an unverified user.
+func (s *UserServiceDB) ResendVerification(email string) error {
+	var user models.User
+	err := s.DB.First(&user, "email = ?", normalizeEmail(email)).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		s.Logger.Error().Err(err).Msg("Failed to retrieve user from PostgreSQL")
+		return err
+	}
+	if user.EmailVerified() {
+		return nil
+	}
+
+	token, verification, err := newEmailVerification(s.Config)
+	if err != nil {
+		return err
+	}
+	verification.UserID = user.ID
+	if err := s.DB.Create(&verification).Error; err != nil {
+		s.Logger.Error().Err(err).Uint("user_id", user.ID).Msg("Failed to create email verification in PostgreSQL")
+		return err
+	}
+	s.sendVerification(user, token)
+	return nil
+}
+
+// GetUser retrieves a user by ID.
+func (s *UserServiceDB) GetUser(id uint) (models.User, error) {
+	var user models.User
+	err := s.DB.First(&user, id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return models.User{}, ErrUserNotFound
+	}
+	if err != nil {
+		s.Logger.Error().Err(err).Uint("user_id", id).Msg("Failed to retrieve user from PostgreSQL")
+		return models.User{}, err
+	}
+	return user, nil
+}
+
+// UpdateProfile changes the profile fields given in a request.
+func (s *UserServiceDB) UpdateProfile(id uint, req models.UpdateProfileRequest) (models.User, error) {
+	user, err := s.GetUser(id)
+	if err != nil {
+		return models.User{}, err
+	}
+	if req.FullName != nil {
+		user.FullName = strings.TrimSpace(*req.FullName)
+	}
+	if err := s.DB.Model(&user).Update("full_name", user.FullName).Error; err != nil {
+		s.Logger.Error().Err(err).Uint("user_id", id).Msg("Failed to update user in PostgreSQL")
+		return models.User{}, err
+	}
+	return user, nil
+}
+
+// SetStatus activates, freezes or closes an account. The row lock waits for
+// transactions of the user that are in flight, so that none is created after
+// the change.
+func (s *UserServiceDB) SetStatus(actor policy.Actor, id uint, status string) (models.User, error) {
+	if !actor.Can(policy.ManageUserStatus) {
+		return models.User{}, fmt.Errorf("%w: %s may not change account statuses", policy.ErrForbidden, actor.Role)
+	}
+	var user models.User
+	err := s.DB.Transaction(func(db *gorm.DB) error {
+		err := db.Clauses(clause.Locking{Strength: "UPDATE"}).First(&user, id).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrUserNotFound
+		}
+		if err != nil {
+			s.Logger.Error().Err(err).Uint("user_id", id).Msg("Failed to lock user in PostgreSQL")
+			return err
+		}
+		if err := checkStatusChange(user.Status, status); err != nil {
+			return err
+		}
+		return db.Model(&user).Update("status", status).Error
+	})
+	if err != nil {
+		return models.User{}, err
+	}
+
+	s.Logger.Info().Uint("user_id", id).Str("status", status).Uint("actor_id", actor.UserID).Msg("User status changed")
+	return user, nil
+}
+
+// SetRole changes the role of a user. It applies to access tokens issued
+// afterwards, at the latest when the current ones are refreshed.
+func (s *UserServiceDB) SetRole(actor policy.Actor, id uint, role string) (models.User, error) {
+	if err := checkRoleChange(actor, role); err != nil {
+		return models.User{}, err
+	}
+	user, err := s.GetUser(id)
+	if err != nil {
+		return models.User{}, err
+	}
+	if err := s.DB.Model(&user).Update("role", role).Error; err != nil {
+		s.Logger.Error().Err(err).Uint("user_id", id).Msg("Failed to update user role in PostgreSQL")
+		return models.User{}, err
+	}
+
+	s.Logger.Info().Uint("user_id", id).Str("role", role).Uint("actor_id", actor.UserID).Msg("User role changed")
+	return user, nil
+}
+
+// VerifyCredentials returns the ID of the user an email and password belong to.
+// The user must have verified their email and be active. Passwords hashed with
+// older parameters or bcrypt are rehashed on success.
+func (s *UserServiceDB) VerifyCredentials(email, password string) (uint, error) {
+	var user models.User
+	err := s.DB.First(&user, "email = ?", normalizeEmail(email)).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		// Spend as long as for a known email
+		VerifyPassword(dummyPasswordHash(), password)
+		return 0, ErrInvalidCredentials
+	}
+	if err != nil {
+		s.Logger.Error().Err(err).Msg("Failed to retrieve user from PostgreSQL")
+		return 0, err
+	}
+	if err := checkCredentials(user, password); err != nil {
+		return 0, err
+	}
+
+	if NeedsRehash(user.PasswordHash) {
+		if hash, err := HashPassword(password); err == nil {
+			err = s.DB.Model(&models.User{}).Where("id = ?", user.ID).Update("password_hash", hash).Error
+			if err != nil {
+				s.Logger.Error().Err(err).Uint("user_id", user.ID).Msg("Failed to rehash password")
+			}
+		}
+	}
+	return user.ID, nil
+}
+
+// sendVerification emails a verification link. Failures are only logged, as
+// the user can ask for another email.
+func (s *UserServiceDB) sendVerification(user models.User, token string) {
+	if err := s.Emails.SendVerification(user, verificationLink(s.Config, token)); err != nil {
+		s.Logger.Error().Err(err).Uint("user_id", user.ID).Msg("Failed to send verification email")
+	}
+}
+
+// checkUserActive locks the row of a user for the rest of a DB transaction and
+// returns ErrUserNotActive unless the user exists and is active.
+func checkUserActive(db *gorm.DB, userID uint) error {
+	var user models.User
+	err := db.Clauses(clause.Locking{Strength: "SHARE"}).Select("id", "status").First(&user, userID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return fmt.Errorf("%w: user %d does not exist", ErrUserNotActive, userID)
+	}
+	if err != nil {
+		return err
+	}
+	if user.Status != models.UserStatusActive {
+		return fmt.Errorf("%w: user %d is %s", ErrUserNotActive, userID, user.Status)
+	}
+	return nil
+}
+
+// MockUserService is a mock implementation of UserService.
+type MockUserService struct {
+	users         map[uint]models.User
+	emails        map[string]uint
+	verifications map[string]models.EmailVerification // by token hash
+	sender        EmailSender
+	config        config.UsersConfig
+	mutex         sync.RWMutex
+}
+
+// NewMockUserService creates a new instance of MockUserService. The
+// transaction service rejects transactions of users it does not know as active.
+func NewMockUserService(txService *MockTransactionService, sender EmailSender, cfg config.UsersConfig) *MockUserService {
+	s := &MockUserService{
+		users:         make(map[uint]models.User),
+		emails:        make(map[string]uint),
+		verifications: make(map[string]models.EmailVerification),
+		sender:        sender,
+		config:        cfg,
+	}
+	txService.users = s
+	return s
+}
+
+// Register creates an active, unverified user and sends them a verification email.
+func (s *MockUserService) Register(req models.RegisterRequest) (models.User, error) {
+	hash, err := HashPassword(req.Password)
+	if err != nil {
+		return models.User{}, err
+	}
+	token, verification, err := newEmailVerification(s.config)
+	if err != nil {
+		return models.User{}, err
+	}
+
+	s.mutex.Lock()
+	email := normalizeEmail(req.Email)
+	if _, exists := s.emails[email]; exists {
+		s.mutex.Unlock()
+		return models.User{}, ErrEmailTaken
+	}
+	now := time.Now().Unix()
+	user := models.User{
+		ID:           uint(len(s.users) + 1),
+		Email:        email,
+		PasswordHash: hash,
+		FullName:     strings.TrimSpace(req.FullName),
+		Status:       models.UserStatusActive,
+		Role:         models.RoleUser,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	}
+	s.users[user.ID] = user
+	s.emails[email] = user.ID
+	verification.UserID = user.ID
+	s.verifications[verification.TokenHash] = verification
+	s.mutex.Unlock()
+
+	// As with UserServiceDB, the user can ask for another email
+	_ = s.sender.SendVerification(user, verificationLink(s.config, token))
+	return user, nil
+}
+
+// VerifyEmail marks the email of the user a verification token was sent to as verified.
+func (s *MockUserService) VerifyEmail(token string) (models.User, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	verification, exists := s.verifications[hashToken(token)]
+	if !exists || verification.ExpiresAt <= time.Now().Unix() {
+		return models.User{}, ErrInvalidVerificationToken
+	}
+	for hash, v := range s.verifications {
+		if v.UserID == verification.UserID {
+			delete(s.verifications, hash)
+		}
+	}
+	user := s.users[verification.UserID]
+	if !user.EmailVerified() {
+		now := time.Now().Unix()
+		user.EmailVerifiedAt = &now
+		user.UpdatedAt = now
+		s.users[user.ID] = user
+	}
+	return user, nil
+}
+
+// ResendVerification sends a new verification email to an unverified user.
+func (s *MockUserService) ResendVerification(email string) error {
+	token, verification, err := newEmailVerification(s.config)
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	id, exists := s.emails[normalizeEmail(email)]
+	user := s.users[id]
+	if !exists || user.EmailVerified() {
+		s.mutex.Unlock()
+		return nil
+	}
+	verification.UserID = id
+	s.verifications[verification.TokenHash] = verification
+	s.mutex.Unlock()
+
+	return s.sender.SendVerification(user, verificationLink(s.config, token))
+}
+
+// GetUser retrieves a user by ID from the mock store.
+func (s *MockUserService) GetUser(id uint) (models.User, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	user, exists := s.users[id]
+	if !exists {
+		return models.User{}, ErrUserNotFound
+	}
+	return user, nil
+}
+
+// UpdateProfile changes the profile fields given in a request.
+func (s *MockUserService) UpdateProfile(id uint, req models.UpdateProfileRequest) (models.User, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	user, exists := s.users[id]
+	if !exists {
+		return models.User{}, ErrUserNotFound
+	}
+	if req.FullName != nil {
+		user.FullName = strings.TrimSpace(*req.FullName)
+	}
+	user.UpdatedAt = time.Now().Unix()
+	s.users[id] = user
+	return user, nil
+}
+
+// SetStatus activates, freezes or closes an account.
+func (s *MockUserService) SetStatus(actor policy.Actor, id uint, status string) (models.User, error) {
+	if !actor.Can(policy.ManageUserStatus) {
+		return models.User{}, fmt.Errorf("%w: %s may not change account statuses", policy.ErrForbidden, actor.Role)
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	user, exists := s.users[id]
+	if !exists {
+		return models.User{}, ErrUserNotFound
+	}
+	if err := checkStatusChange(user.Status, status); err != nil {
+		return models.User{}, err
+	}
+	user.Status = status
+	user.UpdatedAt = time.Now().Unix()
+	s.users[id] = user
+	return user, nil
+}
+
+// SetRole changes the role of a user.
+func (s *MockUserService) SetRole(actor policy.Actor, id uint, role string) (models.User, error) {
+	if err := checkRoleChange(actor, role); err != nil {
+		return models.User{}, err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	user, exists := s.users[id]
+	if !exists {
+		return models.User{}, ErrUserNotFound
+	}
+	user.Role = role
+	user.UpdatedAt = time.Now().Unix()
+	s.users[id] = user
+	return user, nil
+}
+
+// VerifyCredentials returns the ID of the user an email and password belong to.
+func (s *MockUserService) VerifyCredentials(email, password string) (uint, error) {
+	s.mutex.RLock()
+	id, exists := s.emails[normalizeEmail(email)]
+	user := s.users[id]
+	s.mutex.RUnlock()
+	if !exists {
+		VerifyPassword(dummyPasswordHash(), password)
+		return 0, ErrInvalidCredentials
+	}
+	if err := checkCredentials(user, password); err != nil {
+		return 0, err
+	}
+	return user.ID, nil
+}
+
+// checkActive returns ErrUserNotActive unless a user exists and is active.
+func (s *MockUserService) checkActive(userID uint) error {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	user, exists := s.users[userID]
+	if !exists {
+		return fmt.Errorf("%w: user %d does not exist", ErrUserNotActive, userID)
+	}
+	if user.Status != models.UserStatusActive {
+		return fmt.Errorf("%w: user %d is %s", ErrUserNotActive, userID, user.Status)
+	}
+	return nil
+}
+
+// checkCredentials checks the password of a user, and then that they may log in.
+func checkCredentials(user models.User, password string) error {
+	err := VerifyPassword(user.PasswordHash, password)
+	if errors.Is(err, ErrMismatchedPassword) {
+		return ErrInvalidCredentials
+	}
+	if err != nil {
+		return err
+	}
+	if !user.EmailVerified() {
+		return ErrEmailNotVerified
+	}
+	if user.Status != models.UserStatusActive {
+		return fmt.Errorf("%w: user is %s", ErrUserNotActive, user.Status)
+	}
+	return nil
+}
+
+// checkStatusChange returns ErrInvalidStatusChange for unknown statuses and
+// for changes of closed accounts.
+func checkStatusChange(from, to string) error {
+	switch to {
+	case models.UserStatusActive, models.UserStatusFrozen, models.UserStatusClosed:
+	default:
+		return fmt.Errorf("%w: unknown status %q", ErrInvalidStatusChange, to)
+	}
+	if from == models.UserStatusClosed && to != models.UserStatusClosed {
+		return fmt.Errorf("%w: account is closed", ErrInvalidStatusChange)
+	}
+	return nil
+}
+
+// checkRoleChange returns an error unless the actor may assign roles and the
+// role exists.
+func checkRoleChange(actor policy.Actor, role string) error {
+	if !actor.Can(policy.ManageRoles) {
+		return fmt.Errorf("%w: %s may not change roles", policy.ErrForbidden, actor.Role)
+	}
+	switch role {
+	case models.RoleUser, models.RoleSupport, models.RoleCompliance, models.RoleAdmin:
+		return nil
+	}
+	return fmt.Errorf("%w: %q", ErrInvalidRole, role)
+}
+
+// normalizeEmail trims and lower-cases an email address.
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// newEmailVerification creates a random verification token and its record,
+// which only holds the token's hash.
+func newEmailVerification(cfg config.UsersConfig) (string, models.EmailVerification, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", models.EmailVerification{}, fmt.Errorf("failed to generate verification token: %w", err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+	return token, models.EmailVerification{
+		TokenHash: hashToken(token),
+		ExpiresAt: time.Now().Add(cfg.VerificationTTL).Unix(),
+	}, nil
+}
+
+// hashToken returns the hex SHA-256 hash of a token.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// verificationLink returns the link of a verification email.
+func verificationLink(cfg config.UsersConfig, token string) string {
+	return fmt.Sprintf(cfg.VerificationURL, token)
+}
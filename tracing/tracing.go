@@ -0,0 +1,174 @@
+// tracing/tracing.go
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"crypto-exchange/config"
+
+	"github.com/rs/zerolog"
+	"github.com/segmentio/kafka-go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentationName names the tracer of the exchange's own spans.
+const instrumentationName = "crypto-exchange"
+
+// Span exporters.
+const (
+	ExporterOTLP   = "otlp"
+	ExporterStdout = "stdout"
+)
+
+// Setup installs the global tracer provider and the W3C trace context
+// propagator. It returns a function that flushes the buffered spans and stops
+// the provider. While tracing is disabled, incoming trace context is still
+// propagated but no spans are recorded.
+func Setup(ctx context.Context, cfg config.TracingConfig) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	if !cfg.Enabled {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch cfg.Exporter {
+	case ExporterOTLP:
+		opts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(cfg.Endpoint)}
+		if cfg.Insecure {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+		exporter, err = otlptracehttp.New(ctx, opts...)
+	case ExporterStdout:
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	default:
+		err = fmt.Errorf("unknown span exporter %q", cfg.Exporter)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create span exporter: %w", err)
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(
+		semconv.SchemaURL,
+		semconv.ServiceName(cfg.ServiceName),
+	))
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe tracing resource: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		// Follow the caller's sampling decision, so that traces stay complete
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// Start starts a span as a child of the span in ctx, if any.
+func Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentationName).Start(ctx, name, opts...)
+}
+
+// End ends a span, marking it failed if err is not nil.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Store runs a call to a store in a client span named after the store and the
+// operation, e.g. "postgresql insert transaction".
+func Store(ctx context.Context, system, operation string, call func(ctx context.Context) error) error {
+	ctx, span := Start(ctx, system+" "+operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("db.system", system)),
+	)
+	err := call(ctx)
+	End(span, err)
+	return err
+}
+
+// Inject returns the trace context of ctx as W3C headers, such as traceparent.
+func Inject(ctx context.Context) map[string]string {
+	carrier := propagation.MapCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+	return carrier
+}
+
+// Extract returns ctx with the trace context of W3C headers.
+func Extract(ctx context.Context, headers map[string]string) context.Context {
+	return otel.GetTextMapPropagator().Extract(ctx, propagation.MapCarrier(headers))
+}
+
+// KafkaHeaders carries trace context in the headers of a Kafka message.
+type KafkaHeaders struct {
+	Headers *[]kafka.Header
+}
+
+// Get returns the value of a header.
+func (h KafkaHeaders) Get(key string) string {
+	for _, header := range *h.Headers {
+		if header.Key == key {
+			return string(header.Value)
+		}
+	}
+	return ""
+}
+
+// Set replaces the value of a header.
+func (h KafkaHeaders) Set(key, value string) {
+	for i, header := range *h.Headers {
+		if header.Key == key {
+			(*h.Headers)[i].Value = []byte(value)
+			return
+		}
+	}
+	*h.Headers = append(*h.Headers, kafka.Header{Key: key, Value: []byte(value)})
+}
+
+// Keys returns the names of the headers.
+func (h KafkaHeaders) Keys() []string {
+	keys := make([]string, len(*h.Headers))
+	for i, header := range *h.Headers {
+		keys[i] = header.Key
+	}
+	return keys
+}
+
+// InjectKafka writes the trace context of ctx to the headers of a Kafka message.
+func InjectKafka(ctx context.Context, msg *kafka.Message) {
+	otel.GetTextMapPropagator().Inject(ctx, KafkaHeaders{Headers: &msg.Headers})
+}
+
+// ExtractKafka returns ctx with the trace context of a Kafka message.
+func ExtractKafka(ctx context.Context, msg kafka.Message) context.Context {
+	return otel.GetTextMapPropagator().Extract(ctx, KafkaHeaders{Headers: &msg.Headers})
+}
+
+// LogHook adds the trace and span IDs to log lines given a context with
+// zerolog's Ctx, so that logs can be found from a trace and vice versa.
+type LogHook struct{}
+
+// Run adds the IDs of the span in the event's context, if any.
+func (LogHook) Run(e *zerolog.Event, level zerolog.Level, msg string) {
+	sc := trace.SpanContextFromContext(e.GetCtx())
+	if !sc.IsValid() {
+		return
+	}
+	e.Str("trace_id", sc.TraceID().String()).Str("span_id", sc.SpanID().String())
+}